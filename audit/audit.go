@@ -0,0 +1,84 @@
+// Package audit records the changes made through the API in the audit log
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	repository "github.com/kbc0/DynamicStockManager/repository/audit"
+	"github.com/kbc0/DynamicStockManager/utils"
+)
+
+// Recorder appends audit entries for the mutating handlers
+type Recorder struct {
+	store repository.AuditStore
+}
+
+func NewRecorder(store repository.AuditStore) *Recorder {
+	return &Recorder{store: store}
+}
+
+// Change describes one change to an entity of a form. Before is nil for
+// created and After for deleted entities.
+type Change struct {
+	Action     entity.AuditAction
+	EntityType entity.AuditEntityType
+	EntityID   uuid.UUID
+	FormID     uuid.UUID
+	Before     interface{}
+	After      interface{}
+}
+
+// Record appends an entry for the change, made by the authenticated user in
+// the request. The form resolved by the access middleware determines the
+// owner; without one, as when creating a form, the actor is the owner.
+func (r *Recorder) Record(c *fiber.Ctx, change Change) error {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return err
+	}
+	ownerID := userID
+	if form := middleware.FormFromContext(c); form != nil {
+		ownerID = form.UserID
+	}
+
+	entry := entity.AuditEntry{
+		ID:         uuid.New(),
+		OwnerID:    ownerID,
+		UserID:     userID,
+		EntityType: change.EntityType,
+		EntityID:   change.EntityID,
+		FormID:     change.FormID,
+		Action:     change.Action,
+		RequestID:  middleware.RequestIDFromContext(c),
+		CreatedAt:  time.Now(),
+	}
+	if entry.Before, err = snapshot(change.Before); err != nil {
+		return err
+	}
+	if entry.After, err = snapshot(change.After); err != nil {
+		return err
+	}
+	return r.store.AppendEntry(entry)
+}
+
+// snapshot captures an entity the way the API shows it, so that entries read
+// the same no matter which storage backend wrote them
+func snapshot(value interface{}) (map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,83 @@
+// Package blob keeps the content of the files uploaded to attachment fields,
+// on the local filesystem, in an S3 compatible object store or in memory
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/config"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under keys made of segments separated by slashes, such as
+// the ones built by Key
+type Store interface {
+	// Put stores size bytes of content under the key, replacing any blob
+	// stored under it
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under the key, failing with ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under the key, a missing blob is not an
+	// error
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every blob whose key starts with the prefix, which
+	// ends with a slash
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// Open creates the store configured
+func Open(cfg config.BlobConfig) (Store, error) {
+	switch cfg.Backend {
+	case config.BlobFilesystem:
+		return NewFileStore(cfg.Path)
+	case config.BlobS3:
+		return NewS3Store(cfg.S3, nil)
+	case config.BlobMemory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+}
+
+// Key returns the key of the file of an attachment of a stock. The keys of a
+// stock start with StockPrefix, which starts with FormPrefix.
+func Key(formID uuid.UUID, stockID uuid.UUID, attachmentID string) string {
+	return StockPrefix(formID, stockID) + attachmentID
+}
+
+// FormPrefix returns the prefix of the keys of the files of a form
+func FormPrefix(formID uuid.UUID) string {
+	return formID.String() + "/"
+}
+
+// StockPrefix returns the prefix of the keys of the files of a stock
+func StockPrefix(formID uuid.UUID, stockID uuid.UUID) string {
+	return FormPrefix(formID) + stockID.String() + "/"
+}
+
+// checkKey refuses keys that could escape the store, such as ones with ".."
+// segments, with the trailing slash of prefixes allowed
+func checkKey(key string, prefix bool) error {
+	trimmed := key
+	if prefix {
+		if !strings.HasSuffix(key, "/") {
+			return fmt.Errorf("blob prefix %q must end with a slash", key)
+		}
+		trimmed = strings.TrimSuffix(key, "/")
+	}
+	if trimmed == "" || strings.ContainsAny(trimmed, "\\\x00") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, segment := range strings.Split(trimmed, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps blobs as files below a root directory, one directory per
+// key segment
+type FileStore struct {
+	root string
+}
+
+// NewFileStore creates the root directory if needed
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &FileStore{root: root}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// Put writes the content to a temporary file renamed into place once
+// complete, so that readers never see a partial blob
+func (s *FileStore) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	if err := checkKey(key, false); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("blob %s: expected %d bytes, got %d", key, size, written)
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key, false); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key, false); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	s.removeEmptyParents(path)
+	return nil
+}
+
+func (s *FileStore) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := checkKey(prefix, true); err != nil {
+		return err
+	}
+	path := s.path(prefix)
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	s.removeEmptyParents(path)
+	return nil
+}
+
+// removeEmptyParents removes the directories left empty above a deleted path,
+// stopping at the root or the first directory that is not empty
+func (s *FileStore) removeEmptyParents(path string) {
+	root := filepath.Clean(s.root)
+	for dir := filepath.Dir(filepath.Clean(path)); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"form/a/1", "form/b/1"} {
+		if err := store.Put(ctx, key, strings.NewReader("abc"), 3, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Put(ctx, "form/a/2", strings.NewReader("abc"), 4, ""); err == nil {
+		t.Fatal("stored a file shorter than its size")
+	}
+
+	reader, err := store.Get(ctx, "form/a/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(content) != "abc" {
+		t.Fatalf("read %q", content)
+	}
+
+	if err := store.Delete(ctx, "form/a/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "form/a/1"); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if err := store.DeletePrefix(ctx, "form/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "form/b/1"); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if err := store.DeletePrefix(ctx, "../"); err == nil {
+		t.Fatal("deleted outside the store")
+	}
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported storage backends
+const (
+	StorageMongo  = "mongo"
+	StorageMemory = "memory"
+)
+
+// Supported blob stores keeping the files of attachment fields
+const (
+	BlobFilesystem = "fs"
+	BlobS3         = "s3"
+	BlobMemory     = "memory"
+)
+
+// Config holds every setting the application needs at startup
+type Config struct {
+	Port    int         `yaml:"port"`
+	Storage string      `yaml:"storage"`
+	Mongo   MongoConfig `yaml:"mongo"`
+	JWT     JWTConfig   `yaml:"jwt"`
+	Trash   TrashConfig `yaml:"trash"`
+	Blobs   BlobConfig  `yaml:"blobs"`
+}
+
+// MongoConfig holds the MongoDB connection settings
+type MongoConfig struct {
+	URI      string `yaml:"uri"`
+	Database string `yaml:"database"`
+}
+
+// JWTConfig holds the settings used to sign and verify user tokens
+type JWTConfig struct {
+	Secret string        `yaml:"secret"`
+	TTL    time.Duration `yaml:"ttl"`
+}
+
+// TrashConfig holds the settings of the background job purging the trash
+type TrashConfig struct {
+	Retention     time.Duration `yaml:"retention"`     // How long deleted records stay restorable, 0 keeps them until purged by hand
+	PurgeInterval time.Duration `yaml:"purgeInterval"` // How often expired records are purged
+}
+
+// BlobConfig holds the settings of the store keeping the files uploaded to
+// attachment fields. The memory storage backend always keeps them in memory.
+type BlobConfig struct {
+	Backend       string   `yaml:"backend"`
+	Path          string   `yaml:"path"`          // Directory of the fs backend
+	MaxUploadSize int64    `yaml:"maxUploadSize"` // Largest request body in bytes, which caps the size of uploaded files
+	S3            S3Config `yaml:"s3"`
+}
+
+// S3Config holds the settings of the s3 blob backend, which works with any S3
+// compatible object store
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"` // Defaults to the AWS endpoint of the region
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"accessKey"` // Requests are not signed without keys
+	SecretKey string `yaml:"secretKey"`
+	PathStyle bool   `yaml:"pathStyle"` // Address the bucket in the path instead of the host name, as most S3 compatible stores expect
+}
+
+// Default returns the configuration used when nothing else is specified
+func Default() *Config {
+	return &Config{
+		Port:    8080,
+		Storage: StorageMongo,
+		Mongo: MongoConfig{
+			Database: "Users",
+		},
+		JWT: JWTConfig{
+			TTL: 144 * time.Hour,
+		},
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Blobs: BlobConfig{
+			Backend:       BlobFilesystem,
+			Path:          "data/blobs",
+			MaxUploadSize: 32 << 20,
+			S3: S3Config{
+				Region: "us-east-1",
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the optional YAML file at
+// path and finally the environment variables, which take precedence over both.
+// The result is validated before it is returned.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(content, cfg); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	if value, ok := os.LookupEnv("PORT"); ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid PORT %q: %w", value, err)
+		}
+		cfg.Port = port
+	}
+	if value, ok := os.LookupEnv("STORAGE_BACKEND"); ok {
+		cfg.Storage = value
+	}
+	if value, ok := os.LookupEnv("MONGO_URI"); ok {
+		cfg.Mongo.URI = value
+	}
+	if value, ok := os.LookupEnv("MONGO_DATABASE"); ok {
+		cfg.Mongo.Database = value
+	}
+	if value, ok := os.LookupEnv("JWT_SECRET"); ok {
+		cfg.JWT.Secret = value
+	}
+	if value, ok := os.LookupEnv("JWT_TTL"); ok {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid JWT_TTL %q: %w", value, err)
+		}
+		cfg.JWT.TTL = ttl
+	}
+	if value, ok := os.LookupEnv("TRASH_RETENTION"); ok {
+		retention, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid TRASH_RETENTION %q: %w", value, err)
+		}
+		cfg.Trash.Retention = retention
+	}
+	if value, ok := os.LookupEnv("TRASH_PURGE_INTERVAL"); ok {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid TRASH_PURGE_INTERVAL %q: %w", value, err)
+		}
+		cfg.Trash.PurgeInterval = interval
+	}
+	if value, ok := os.LookupEnv("BLOB_BACKEND"); ok {
+		cfg.Blobs.Backend = value
+	}
+	if value, ok := os.LookupEnv("BLOB_PATH"); ok {
+		cfg.Blobs.Path = value
+	}
+	if value, ok := os.LookupEnv("BLOB_MAX_UPLOAD_SIZE"); ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid BLOB_MAX_UPLOAD_SIZE %q: %w", value, err)
+		}
+		cfg.Blobs.MaxUploadSize = size
+	}
+	if value, ok := os.LookupEnv("S3_ENDPOINT"); ok {
+		cfg.Blobs.S3.Endpoint = value
+	}
+	if value, ok := os.LookupEnv("S3_REGION"); ok {
+		cfg.Blobs.S3.Region = value
+	}
+	if value, ok := os.LookupEnv("S3_BUCKET"); ok {
+		cfg.Blobs.S3.Bucket = value
+	}
+	if value, ok := os.LookupEnv("S3_ACCESS_KEY"); ok {
+		cfg.Blobs.S3.AccessKey = value
+	}
+	if value, ok := os.LookupEnv("S3_SECRET_KEY"); ok {
+		cfg.Blobs.S3.SecretKey = value
+	}
+	if value, ok := os.LookupEnv("S3_PATH_STYLE"); ok {
+		pathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid S3_PATH_STYLE %q: %w", value, err)
+		}
+		cfg.Blobs.S3.PathStyle = pathStyle
+	}
+	return nil
+}
+
+// Validate reports every invalid or missing setting at once
+func (cfg *Config) Validate() error {
+	var problems []string
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		problems = append(problems, "port must be between 1 and 65535")
+	}
+	switch cfg.Storage {
+	case StorageMongo:
+		if cfg.Mongo.URI == "" {
+			problems = append(problems, "mongo uri is required (MONGO_URI)")
+		}
+		if cfg.Mongo.Database == "" {
+			problems = append(problems, "mongo database is required (MONGO_DATABASE)")
+		}
+	case StorageMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("storage must be %q or %q", StorageMongo, StorageMemory))
+	}
+	if cfg.JWT.Secret == "" {
+		problems = append(problems, "jwt secret is required (JWT_SECRET)")
+	}
+	if cfg.JWT.TTL <= 0 {
+		problems = append(problems, "jwt ttl must be positive")
+	}
+	if cfg.Trash.Retention < 0 {
+		problems = append(problems, "trash retention must not be negative")
+	}
+	if cfg.Trash.Retention > 0 && cfg.Trash.PurgeInterval <= 0 {
+		problems = append(problems, "trash purge interval must be positive")
+	}
+	switch cfg.Blobs.Backend {
+	case BlobFilesystem:
+		if cfg.Blobs.Path == "" {
+			problems = append(problems, "blob path is required (BLOB_PATH)")
+		}
+	case BlobS3:
+		if cfg.Blobs.S3.Bucket == "" {
+			problems = append(problems, "s3 bucket is required (S3_BUCKET)")
+		}
+		if cfg.Blobs.S3.Region == "" {
+			problems = append(problems, "s3 region is required (S3_REGION)")
+		}
+		if endpoint := cfg.Blobs.S3.Endpoint; endpoint != "" {
+			if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, "s3 endpoint must be an http or https URL")
+			}
+		}
+		if (cfg.Blobs.S3.AccessKey == "") != (cfg.Blobs.S3.SecretKey == "") {
+			problems = append(problems, "s3 access key and secret key must be set together")
+		}
+	case BlobMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("blob backend must be %q, %q or %q", BlobFilesystem, BlobS3, BlobMemory))
+	}
+	if cfg.Blobs.MaxUploadSize <= 0 {
+		problems = append(problems, "blob max upload size must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Address returns the address the HTTP server listens on
+func (cfg *Config) Address() string {
+	return ":" + strconv.Itoa(cfg.Port)
+}
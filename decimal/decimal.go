@@ -0,0 +1,218 @@
+// Package decimal handles the exact decimal numbers of numberDecimal fields,
+// which are stored as BSON decimal128 values
+package decimal
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxPrecision is the most digits a decimal128 value holds
+const MaxPrecision = 34
+
+var ten = big.NewInt(10)
+
+// Parse converts a number, or its text, to a decimal. Floats are taken as the
+// shortest text that reads back as the same float, so 0.1 is exactly 0.1.
+// Exponents are written out, 1e3 is 1000.
+func Parse(value interface{}) (primitive.Decimal128, error) {
+	var text string
+	switch v := value.(type) {
+	case primitive.Decimal128:
+		text = v.String()
+	case json.Number:
+		text = string(v)
+	case string:
+		text = strings.TrimSpace(v)
+	case float64:
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		text = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case int:
+		text = strconv.Itoa(v)
+	case int32:
+		text = strconv.FormatInt(int64(v), 10)
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	default:
+		return primitive.Decimal128{}, errors.New("expected a decimal number")
+	}
+
+	parsed, err := primitive.ParseDecimal128(text)
+	if err != nil || parsed.IsNaN() || parsed.IsInf() != 0 {
+		return primitive.Decimal128{}, errors.New("invalid decimal number " + strconv.Quote(text))
+	}
+	if _, exp, _ := parsed.BigInt(); exp > 0 {
+		if plain, err := Round(parsed, 0, entity.RoundDown); err == nil {
+			return plain, nil
+		}
+	}
+	return parsed, nil
+}
+
+// Round rounds a decimal to the given digits after the decimal point, adding
+// zeros to one with fewer digits
+func Round(d primitive.Decimal128, scale int, mode entity.RoundingMode) (primitive.Decimal128, error) {
+	coefficient, exp, err := d.BigInt()
+	if err != nil {
+		return primitive.Decimal128{}, err
+	}
+
+	if exp >= -scale {
+		coefficient.Mul(coefficient, new(big.Int).Exp(ten, big.NewInt(int64(exp+scale)), nil))
+	} else {
+		divisor := new(big.Int).Exp(ten, big.NewInt(int64(-scale-exp)), nil)
+		remainder := new(big.Int)
+		coefficient.QuoRem(coefficient, divisor, remainder)
+		if awayFromZero(coefficient, remainder, divisor, mode) {
+			if remainder.Sign() < 0 {
+				coefficient.Sub(coefficient, big.NewInt(1))
+			} else {
+				coefficient.Add(coefficient, big.NewInt(1))
+			}
+		}
+	}
+
+	rounded, ok := primitive.ParseDecimal128FromBigInt(coefficient, -scale)
+	if !ok {
+		return primitive.Decimal128{}, errors.New("value has more than " + strconv.Itoa(MaxPrecision) + " digits")
+	}
+	return rounded, nil
+}
+
+// awayFromZero decides whether a quotient truncated toward zero is rounded away
+// from zero, given the remainder, which has the sign of the value, and the
+// divisor
+func awayFromZero(quotient, remainder, divisor *big.Int, mode entity.RoundingMode) bool {
+	if remainder.Sign() == 0 {
+		return false
+	}
+	switch mode {
+	case entity.RoundDown:
+		return false
+	case entity.RoundUp:
+		return true
+	case entity.RoundCeiling:
+		return remainder.Sign() > 0
+	case entity.RoundFloor:
+		return remainder.Sign() < 0
+	}
+
+	half := new(big.Int).Abs(remainder)
+	switch half.Lsh(half, 1).Cmp(divisor) {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	switch mode {
+	case entity.RoundHalfDown:
+		return false
+	case entity.RoundHalfEven:
+		return quotient.Bit(0) == 1
+	}
+	return true
+}
+
+// Digits returns the number of digits of a decimal and how many of them follow
+// the decimal point
+func Digits(d primitive.Decimal128) (precision, scale int) {
+	coefficient, exp, err := d.BigInt()
+	if err != nil {
+		return 0, 0
+	}
+	precision = len(coefficient.Abs(coefficient).String())
+	if exp < 0 {
+		scale = -exp
+	}
+	if precision < scale {
+		precision = scale
+	}
+	return precision, scale
+}
+
+// Text formats a decimal without an exponent, keeping its trailing zeros
+func Text(d primitive.Decimal128) string {
+	coefficient, exp, err := d.BigInt()
+	if err != nil {
+		return d.String()
+	}
+	sign := ""
+	if coefficient.Sign() < 0 {
+		sign = "-"
+	}
+	digits := coefficient.Abs(coefficient).String()
+	if exp >= 0 {
+		return sign + digits + strings.Repeat("0", exp)
+	}
+	if len(digits) <= -exp {
+		digits = strings.Repeat("0", -exp-len(digits)+1) + digits
+	}
+	point := len(digits) + exp
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Rat converts a number of any type to an exact rational, floats as the
+// shortest text that reads back as the same float like Parse does
+func Rat(value interface{}) (*big.Rat, bool) {
+	switch v := value.(type) {
+	case primitive.Decimal128:
+		coefficient, exp, err := v.BigInt()
+		if err != nil {
+			return nil, false
+		}
+		power := new(big.Int).Exp(ten, big.NewInt(int64(abs(exp))), nil)
+		if exp < 0 {
+			return new(big.Rat).SetFrac(coefficient, power), true
+		}
+		return new(big.Rat).SetInt(coefficient.Mul(coefficient, power)), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(strconv.FormatFloat(v, 'g', -1, 64))
+	case float32:
+		return new(big.Rat).SetString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case int:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case int32:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	}
+	return nil, false
+}
+
+// Compare returns -1, 0 or 1 comparing two numbers of any type exactly, and
+// false if either is not a number
+func Compare(a, b interface{}) (int, bool) {
+	x, ok := Rat(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := Rat(b)
+	if !ok {
+		return 0, false
+	}
+	return x.Cmp(y), true
+}
+
+// Float converts a decimal to the nearest float
+func Float(d primitive.Decimal128) float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,124 @@
+package decimal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string // Text of the parsed decimal, empty when it fails
+	}{
+		{"1.50", "1.50"},
+		{" -0.001 ", "-0.001"},
+		{0.1, "0.1"},
+		{float32(0.1), "0.1"},
+		{1e3, "1000"},
+		{"1E+3", "1000"},
+		{int64(42), "42"},
+		{json.Number("12.340"), "12.340"},
+		{"NaN", ""},
+		{"Infinity", ""},
+		{"1,5", ""},
+		{true, ""},
+	}
+	for _, test := range tests {
+		parsed, err := Parse(test.value)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%#v: parsed as %s", test.value, Text(parsed))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%#v: %v", test.value, err)
+			continue
+		}
+		if got := Text(parsed); got != test.want {
+			t.Errorf("%#v: got %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		value string
+		scale int
+		mode  entity.RoundingMode
+		want  string
+	}{
+		{"2.345", 2, entity.RoundHalfUp, "2.35"},
+		{"-2.345", 2, entity.RoundHalfUp, "-2.35"},
+		{"2.345", 2, entity.RoundHalfDown, "2.34"},
+		{"2.3451", 2, entity.RoundHalfDown, "2.35"},
+		{"2.345", 2, entity.RoundHalfEven, "2.34"},
+		{"2.355", 2, entity.RoundHalfEven, "2.36"},
+		{"2.341", 2, entity.RoundUp, "2.35"},
+		{"-2.341", 2, entity.RoundUp, "-2.35"},
+		{"2.349", 2, entity.RoundDown, "2.34"},
+		{"-2.349", 2, entity.RoundDown, "-2.34"},
+		{"-2.341", 2, entity.RoundCeiling, "-2.34"},
+		{"2.341", 2, entity.RoundCeiling, "2.35"},
+		{"-2.341", 2, entity.RoundFloor, "-2.35"},
+		{"2.349", 2, entity.RoundFloor, "2.34"},
+		{"2.5", 3, entity.RoundHalfUp, "2.500"},
+		{"9.999", 2, entity.RoundHalfUp, "10.00"},
+		{"125", 0, entity.RoundHalfUp, "125"},
+	}
+	for _, test := range tests {
+		d, err := Parse(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rounded, err := Round(d, test.scale, test.mode)
+		if err != nil {
+			t.Errorf("%s to %d %s: %v", test.value, test.scale, test.mode, err)
+			continue
+		}
+		if got := Text(rounded); got != test.want {
+			t.Errorf("%s to %d %s: got %s, want %s", test.value, test.scale, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestDigits(t *testing.T) {
+	tests := []struct {
+		value            string
+		precision, scale int
+	}{
+		{"123.45", 5, 2},
+		{"0.001", 3, 3},
+		{"-12", 2, 0},
+		{"1.50", 3, 2},
+	}
+	for _, test := range tests {
+		d, _ := Parse(test.value)
+		if precision, scale := Digits(d); precision != test.precision || scale != test.scale {
+			t.Errorf("%s: got %d, %d, want %d, %d", test.value, precision, scale, test.precision, test.scale)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a, _ := Parse("1.50")
+	tests := []struct {
+		x, y interface{}
+		want int
+		ok   bool
+	}{
+		{a, 1.5, 0, true},
+		{a, int64(2), -1, true},
+		{0.3, a, -1, true},
+		{0.30000000000000004, 0.3, 1, true}, // Floats compare by their shortest text
+		{a, "1.5", 0, false},
+	}
+	for _, test := range tests {
+		order, ok := Compare(test.x, test.y)
+		if order != test.want || ok != test.ok {
+			t.Errorf("%v <=> %v: got %d, %v, want %d, %v", test.x, test.y, order, ok, test.want, test.ok)
+		}
+	}
+}
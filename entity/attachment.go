@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// StockAttachment describes a file uploaded to an attachment field of a stock. The
+// content of the file is kept in the blob store.
+type StockAttachment struct {
+	ID          string    `json:"id" bson:"id"` // Stored as text like the stock IDs of reference values
+	Name        string    `json:"name" bson:"name"`
+	ContentType string    `json:"contentType" bson:"contentType"`
+	Size        int64     `json:"size" bson:"size"`
+	Checksum    string    `json:"checksum" bson:"checksum"` // Hex encoded SHA-256 of the content
+	UploadedAt  time.Time `json:"uploadedAt" bson:"uploadedAt"`
+}
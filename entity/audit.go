@@ -0,0 +1,41 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete" // Moved to the trash
+	AuditRestore AuditAction = "restore"
+	AuditPurge   AuditAction = "purge" // Removed from the trash for good
+)
+
+type AuditEntityType string
+
+const (
+	AuditForm  AuditEntityType = "form"
+	AuditField AuditEntityType = "field"
+	AuditStock AuditEntityType = "stock"
+)
+
+// AuditEntry records one change made through the API. Entries are only ever
+// appended, never changed or removed.
+type AuditEntry struct {
+	ID         uuid.UUID              `json:"id" bson:"_id"`
+	OwnerID    uuid.UUID              `json:"ownerId" bson:"ownerId"` // Owner of the form the entity belongs to
+	UserID     uuid.UUID              `json:"userId" bson:"userId"`   // The user who made the change
+	EntityType AuditEntityType        `json:"entityType" bson:"entityType"`
+	EntityID   uuid.UUID              `json:"entityId" bson:"entityId"`
+	FormID     uuid.UUID              `json:"formId" bson:"formId"`
+	Action     AuditAction            `json:"action" bson:"action"`
+	Before     map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"` // Entity as it was, unless created
+	After      map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`   // Entity as it became, unless deleted
+	RequestID  string                 `json:"requestId" bson:"requestId"`
+	CreatedAt  time.Time              `json:"createdAt" bson:"createdAt"`
+}
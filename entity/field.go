@@ -1,6 +1,10 @@
 package entity
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type FieldType string
 
@@ -9,7 +13,51 @@ const (
     Text          FieldType = "text"
     Checkbox      FieldType = "checkbox"
     Number        FieldType = "number"
-    NumberDecimal FieldType = "numberDecimal"
+    NumberDecimal FieldType = "numberDecimal" // Stored as a BSON decimal128
+    Date          FieldType = "date"     // Stored as a BSON date at midnight UTC
+    DateTime      FieldType = "datetime" // Stored as a BSON date
+    Time          FieldType = "time"     // Stored as a BSON date on January 1, 1970 UTC
+    Multiselect   FieldType = "multiselect" // Stored as an array of options
+    Reference     FieldType = "reference"   // Stored as an array of stock IDs of the target form
+    Attachment    FieldType = "attachment"  // Stored as an array of attachments, the files are kept in the blob store
+    Formula       FieldType = "formula"     // Computed from other fields on every write, stored as its result type
+)
+
+// TextFormat is a format the values of a text field must have
+type TextFormat string
+
+const (
+    FormatEmail TextFormat = "email" // An address such as name@example.com, without a display name
+    FormatURL   TextFormat = "url"   // An absolute http or https URL
+    FormatPhone TextFormat = "phone" // An E.164 phone number such as +14155552671
+    FormatEAN13 TextFormat = "ean13" // 13 digits ending in a valid GS1 check digit
+    FormatUPCA  TextFormat = "upca"  // 12 digits ending in a valid GS1 check digit
+    FormatGTIN  TextFormat = "gtin"  // 8, 12, 13 or 14 digits ending in a valid GS1 check digit
+    FormatRegex TextFormat = "regex" // Matches the pattern of the field
+)
+
+// RoundingMode is how a numberDecimal value with more digits than its field
+// keeps is rounded
+type RoundingMode string
+
+const (
+    RoundHalfUp   RoundingMode = "halfUp"   // To the nearest, ties away from zero, the default
+    RoundHalfEven RoundingMode = "halfEven" // To the nearest, ties to the even neighbour
+    RoundHalfDown RoundingMode = "halfDown" // To the nearest, ties toward zero
+    RoundUp       RoundingMode = "up"       // Away from zero
+    RoundDown     RoundingMode = "down"     // Toward zero, dropping the extra digits
+    RoundCeiling  RoundingMode = "ceiling"  // Toward positive infinity
+    RoundFloor    RoundingMode = "floor"    // Toward negative infinity
+)
+
+// ReferenceAction is what happens to the stocks referencing a stock when it is
+// deleted
+type ReferenceAction string
+
+const (
+    OnDeleteRestrict ReferenceAction = "restrict" // The stock cannot be deleted while referenced, the default
+    OnDeleteNullify  ReferenceAction = "nullify"  // The reference is removed from the referencing stocks
+    OnDeleteCascade  ReferenceAction = "cascade"  // The referencing stocks are deleted too
 )
 
 type Field struct {
@@ -20,8 +68,26 @@ type Field struct {
     IsHidden     bool      `json:"isHidden" bson:"isHidden"`
     Order        int       `json:"order" bson:"order"`
     IsUnique     bool      `json:"isUnique" bson:"isUnique"`
-    Options      []string  `json:"options,omitempty" bson:"options,omitempty"` // For combobox
-    MinValue     *int      `json:"minValue,omitempty" bson:"minValue,omitempty"` // For number and numberDecimal
-    MaxValue     *int      `json:"maxValue,omitempty" bson:"maxValue,omitempty"` // For number and numberDecimal
-    DefaultValue interface{} `json:"defaultValue,omitempty" bson:"defaultValue,omitempty"` // For number, numberDecimal, and combobox
+    Options      []string  `json:"options,omitempty" bson:"options,omitempty"` // For combobox and multiselect
+    MinValue     *int      `json:"minValue,omitempty" bson:"minValue,omitempty"` // For number and numberDecimal, or the fewest options selected in a multiselect or stocks referenced
+    MaxValue     *int      `json:"maxValue,omitempty" bson:"maxValue,omitempty"` // For number and numberDecimal, or the most options selected in a multiselect, stocks referenced or files attached
+    MinDate      string    `json:"minDate,omitempty" bson:"minDate,omitempty"` // For date, datetime and time, ISO-8601 or relative like "today"
+    MaxDate      string    `json:"maxDate,omitempty" bson:"maxDate,omitempty"` // For date, datetime and time, ISO-8601 or relative like "today"
+    TargetFormID *uuid.UUID `json:"targetFormId,omitempty" bson:"targetFormId,omitempty"` // For reference, the form of the referenced stocks
+    OnDelete     ReferenceAction `json:"onDelete,omitempty" bson:"onDelete,omitempty"` // For reference
+    AllowedTypes []string  `json:"allowedTypes,omitempty" bson:"allowedTypes,omitempty"` // For attachment, content types such as "application/pdf" or "image/*", any when empty
+    MaxSize      int64     `json:"maxSize,omitempty" bson:"maxSize,omitempty"` // For attachment, the largest file in bytes, up to the upload limit of the server when 0
+    Format       TextFormat `json:"format,omitempty" bson:"format,omitempty"` // For text, a format values must have
+    Pattern      string    `json:"pattern,omitempty" bson:"pattern,omitempty"` // For text with the regex format, an RE2 regular expression whole values must match
+    Scale        *int      `json:"scale,omitempty" bson:"scale,omitempty"` // For numberDecimal, the digits kept after the decimal point
+    Precision    *int      `json:"precision,omitempty" bson:"precision,omitempty"` // For numberDecimal, the most digits of a value, up to 34
+    Rounding     RoundingMode `json:"rounding,omitempty" bson:"rounding,omitempty"` // For numberDecimal, how values with more digits are rounded
+    MinDecimal   string    `json:"minDecimal,omitempty" bson:"minDecimal,omitempty"` // For numberDecimal, the smallest value as text such as "0.01"
+    MaxDecimal   string    `json:"maxDecimal,omitempty" bson:"maxDecimal,omitempty"` // For numberDecimal, the largest value as text
+    Expression   string    `json:"expression,omitempty" bson:"expression,omitempty"` // For formula, such as "quantity * unit_price"
+    ResultType   FieldType `json:"resultType,omitempty" bson:"resultType,omitempty"` // For formula, set by the server: numberDecimal, text or checkbox
+    DefaultValue interface{} `json:"defaultValue,omitempty" bson:"defaultValue,omitempty"` // For number, numberDecimal, combobox, multiselect, date, datetime and time
+    Version      int64     `json:"version" bson:"version"` // Incremented on every update, sent as the ETag
+    DeletedAt    *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"` // Set while the field is in the trash
+    DeletedBy    *uuid.UUID `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
@@ -10,5 +10,9 @@ type Form struct {
     ID       uuid.UUID `json:"id" bson:"_id"`
     UserID   uuid.UUID `json:"userId" bson:"userId"`
     Name     string    `json:"name" bson:"name"`
+    AllowNegativeStock bool `json:"allowNegativeStock" bson:"allowNegativeStock"` // Lets movements take on-hand quantities below zero
     CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+    Version  int64     `json:"version" bson:"version"` // Incremented on every update, sent as the ETag
+    DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"` // Set while the form is in the trash
+    DeletedBy *uuid.UUID `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
@@ -0,0 +1,46 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobKind string
+
+const (
+	JobRenameField  JobKind = "renameField"  // Renames the field's key in the data of every stock
+	JobDropField    JobKind = "dropField"    // Removes the deleted field's key from every stock
+	JobArchiveField JobKind = "archiveField" // Moves the deleted field's values to the archived data of every stock
+)
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job is a background migration of the stock data of a form, with its progress
+type Job struct {
+	ID         uuid.UUID  `json:"id" bson:"_id"`
+	FormID     uuid.UUID  `json:"formId" bson:"formId"`
+	FieldID    uuid.UUID  `json:"fieldId" bson:"fieldId"`
+	Kind       JobKind    `json:"kind" bson:"kind"`
+	FieldName  string     `json:"fieldName" bson:"fieldName"`                 // Data key the job migrates
+	NewName    string     `json:"newName,omitempty" bson:"newName,omitempty"` // Data key a rename moves the values to
+	Status     JobStatus  `json:"status" bson:"status"`
+	Total      int64      `json:"total" bson:"total"`                     // Stocks holding the key when the job started
+	Processed  int64      `json:"processed" bson:"processed"`             // Stocks migrated so far
+	Error      string     `json:"error,omitempty" bson:"error,omitempty"` // Why a failed job stopped
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+}
+
+// Finished reports whether the job is done or failed
+func (j Job) Finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
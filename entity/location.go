@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LocationKind string
+
+const (
+	Warehouse LocationKind = "warehouse"
+	Zone      LocationKind = "zone"
+	Bin       LocationKind = "bin"
+)
+
+// LocationParentKinds tells which kind of location each kind must be nested in
+var LocationParentKinds = map[LocationKind]LocationKind{
+	Zone: Warehouse,
+	Bin:  Zone,
+}
+
+// Location is a place stock can be kept in, organised as a
+// warehouse → zone → bin hierarchy owned by a user
+type Location struct {
+	ID        uuid.UUID    `json:"id" bson:"_id"`
+	UserID    uuid.UUID    `json:"userId" bson:"userId"`
+	ParentID  *uuid.UUID   `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	Path      []uuid.UUID  `json:"path" bson:"path"` // IDs of all ancestors, starting with the warehouse
+	Kind      LocationKind `json:"kind" bson:"kind"`
+	Name      string       `json:"name" bson:"name"`
+	Code      string       `json:"code,omitempty" bson:"code,omitempty"`
+	CreatedAt time.Time    `json:"createdAt" bson:"createdAt"`
+}
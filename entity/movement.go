@@ -0,0 +1,42 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MovementType string
+
+const (
+	Receipt    MovementType = "receipt"
+	Issue      MovementType = "issue"
+	Adjustment MovementType = "adjustment"
+	Transfer   MovementType = "transfer" // Recorded in pairs by stock transfers between locations
+)
+
+// MovementReasons lists the reason codes accepted for each movement type that
+// can be recorded directly
+var MovementReasons = map[MovementType][]string{
+	Receipt:    {"purchase", "customer_return", "production", "other"},
+	Issue:      {"sale", "consumption", "supplier_return", "damage", "other"},
+	Adjustment: {"cycle_count", "damage", "loss", "correction", "other"},
+}
+
+// Movement is an entry of the inventory ledger of a stock record. The on-hand
+// quantity of a stock is the sum of the quantities of all its movements.
+type Movement struct {
+	ID                   uuid.UUID    `json:"id" bson:"_id"`
+	FormID               uuid.UUID    `json:"formId" bson:"formId"`
+	StockID              uuid.UUID    `json:"stockId" bson:"stockId"`
+	Type                 MovementType `json:"type" bson:"type"`
+	Quantity             int64        `json:"quantity" bson:"quantity"` // Signed, negative quantities take stock out
+	LocationID           *uuid.UUID   `json:"locationId,omitempty" bson:"locationId,omitempty"`
+	TransferID           *uuid.UUID   `json:"transferId,omitempty" bson:"transferId,omitempty"` // Shared by both sides of a transfer
+	Reason               string       `json:"reason" bson:"reason"`
+	Note                 string       `json:"note,omitempty" bson:"note,omitempty"`
+	UserID               uuid.UUID    `json:"userId" bson:"userId"`
+	BalanceAfter         int64        `json:"balanceAfter" bson:"balanceAfter"` // On-hand quantity right after this movement
+	LocationBalanceAfter *int64       `json:"locationBalanceAfter,omitempty" bson:"locationBalanceAfter,omitempty"`
+	CreatedAt            time.Time    `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StockRevision keeps the data of a stock record as it was after one change.
+// Revisions of a stock are numbered from 1, the data it was created with.
+type StockRevision struct {
+	ID           uuid.UUID              `json:"id" bson:"_id"`
+	StockID      uuid.UUID              `json:"stockId" bson:"stockId"`
+	FormID       uuid.UUID              `json:"formId" bson:"formId"`
+	Revision     int                    `json:"revision" bson:"revision"`
+	Data         map[string]interface{} `json:"data" bson:"data"`
+	UserID       uuid.UUID              `json:"userId" bson:"userId"`                                 // The user who made the change
+	RestoredFrom int                    `json:"restoredFrom,omitempty" bson:"restoredFrom,omitempty"` // The revision the data was restored from
+	CreatedAt    time.Time              `json:"createdAt" bson:"createdAt"`
+}
+
+// RevisionChange is the difference of one field between two revisions
+type RevisionChange struct {
+	Field  string      `json:"field"`
+	Change string      `json:"change"` // added, removed or changed
+	From   interface{} `json:"from,omitempty"`
+	To     interface{} `json:"to,omitempty"`
+}
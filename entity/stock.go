@@ -9,7 +9,14 @@ import (
 type Stock struct {
 	ID        uuid.UUID              `json:"id" bson:"_id"`
 	FormID    uuid.UUID              `json:"formId" bson:"formId"`
-	Data      map[string]interface{} `json:"data" bson:"data"` // Dynamic data storage based on form fields
+	Data      map[string]interface{} `json:"data" bson:"data"`                               // Dynamic data storage based on form fields
+	Archived  map[string]interface{} `json:"archived,omitempty" bson:"archived,omitempty"`   // Values of deleted fields, kept by name
+	OnHand    int64                  `json:"onHand" bson:"onHand"`                           // Derived from the movement ledger, never set directly
+	Locations map[string]int64       `json:"locations,omitempty" bson:"locations,omitempty"` // On-hand quantity per location ID
+	Revision  int                    `json:"revision" bson:"revision"`                       // Number of the latest revision of Data
+	Version   int64                  `json:"version" bson:"version"`                         // Incremented on every update, sent as the ETag
 	CreatedAt time.Time              `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time              `json:"updatedAt" bson:"updatedAt"`
+	DeletedAt *time.Time             `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"` // Set while the stock is in the trash
+	DeletedBy *uuid.UUID             `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
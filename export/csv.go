@@ -0,0 +1,34 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// CSVWriter writes rows as comma separated values
+type CSVWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func (w *CSVWriter) WriteRow(values []interface{}) error {
+	w.record = w.record[:0]
+	for _, value := range values {
+		w.record = append(w.record, text(value))
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *CSVWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *CSVWriter) Abort() error {
+	w.w.Write([]string{ErrorMarker})
+	return w.Close()
+}
@@ -0,0 +1,179 @@
+// Package export writes the stocks of a form as spreadsheet files
+package export
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/schema"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Supported export formats
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// RowWriter writes one spreadsheet row at a time. Values are nil for empty
+// cells, or the string, bool, int64, float64 or decimal128 returned by Value.
+type RowWriter interface {
+	WriteRow(values []interface{}) error
+	// Close flushes the rows written so far and finishes the file
+	Close() error
+	// Abort flushes the rows written so far and ends the file in a way that
+	// cannot be mistaken for a complete one, after an error cut it short
+	Abort() error
+}
+
+// ErrorMarker is the last row of a CSV file cut short by an error
+const ErrorMarker = "#ERROR: export failed, rows are missing"
+
+// NewRowWriter creates the writer of a format, see ContentType for the formats
+func NewRowWriter(format string, w io.Writer) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	case FormatXLSX:
+		return NewXLSXWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// ContentType returns the MIME type of a format, or "" if it is not supported
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return ""
+}
+
+// Columns returns the fields to export in column order. Hidden fields are left
+// out unless includeHidden is set.
+func Columns(fields []entity.Field, includeHidden bool) []entity.Field {
+	columns := make([]entity.Field, 0, len(fields))
+	for _, field := range fields {
+		if field.IsHidden && !includeHidden {
+			continue
+		}
+		columns = append(columns, field)
+	}
+	sort.SliceStable(columns, func(i, j int) bool {
+		return columns[i].Order < columns[j].Order
+	})
+	return columns
+}
+
+// Header returns the header row, which holds the field names so that an
+// exported file can be imported again
+func Header(columns []entity.Field) []interface{} {
+	header := make([]interface{}, len(columns))
+	for i, field := range columns {
+		header[i] = field.Name
+	}
+	return header
+}
+
+// Row returns the cells of a stock for the columns
+func Row(stock entity.Stock, columns []entity.Field) []interface{} {
+	row := make([]interface{}, len(columns))
+	for i, field := range columns {
+		row[i] = Value(stock.Data[field.Name], field)
+	}
+	return row
+}
+
+// Value converts a stored value to the cell type of its field: a bool for
+// checkbox, an int64 for number, a decimal128 for numberDecimal and a string for
+// anything else, with dates and times in ISO-8601, multiselect options and
+// referenced stock IDs separated by commas and the names of attached files
+// likewise. Results of formulas are exported like values of their result type.
+// Values that do not fit the field are exported as text.
+func Value(value interface{}, field entity.Field) interface{} {
+	if value == nil {
+		return nil
+	}
+	if field.Type == entity.Formula {
+		field.Type = field.ResultType
+	}
+
+	switch field.Type {
+	case entity.Checkbox:
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	case entity.Number:
+		// Larger numbers do not fit an int64
+		if f, ok := toFloat(value); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f)
+		}
+	case entity.NumberDecimal:
+		// Results of formulas and values stored before decimals were exact
+		// are floats
+		if _, ok := decimal.Rat(value); ok {
+			if d, err := decimal.Parse(value); err == nil {
+				return d
+			}
+		}
+	case entity.Multiselect, entity.Reference:
+		if selection, ok := schema.Selection(value); ok {
+			return schema.JoinSelection(selection)
+		}
+	case entity.Attachment:
+		if attachments, ok := schema.Attachments(value); ok {
+			names := make([]string, len(attachments))
+			for i, attachment := range attachments {
+				names[i] = attachment.Name
+			}
+			return schema.JoinSelection(names)
+		}
+	case entity.Date, entity.DateTime, entity.Time:
+		if formatted, ok := schema.FormatTime(value, field.Type); ok {
+			return formatted
+		}
+	}
+	return text(value)
+}
+
+// text formats a cell value as a string
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case primitive.Decimal128:
+		return decimal.Text(v)
+	}
+	return fmt.Sprint(value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
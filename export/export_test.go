@@ -0,0 +1,110 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+func TestValue(t *testing.T) {
+	price, _ := decimal.Parse("2.50")
+	tests := []struct {
+		name  string
+		value interface{}
+		field entity.Field
+		want  interface{}
+	}{
+		{"whole number", 3.0, entity.Field{Type: entity.Number}, int64(3)},
+		{"fraction", 2.5, entity.Field{Type: entity.Number}, "2.5"},
+		{"number too large for int64", 1e300, entity.Field{Type: entity.Number}, "1" + strings.Repeat("0", 300)},
+		{"largest float below 2^63", 9223372036854774784.0, entity.Field{Type: entity.Number}, int64(9223372036854774784)},
+		{"2^63", 9223372036854775808.0, entity.Field{Type: entity.Number}, "9223372036854776000"},
+		{"decimal", price, entity.Field{Type: entity.NumberDecimal}, price},
+		{"float of a decimal field", 2.5, entity.Field{Type: entity.NumberDecimal}, mustDecimal(t, "2.5")},
+		{"checkbox", true, entity.Field{Type: entity.Checkbox}, true},
+		{"multiselect", []string{"a", "b"}, entity.Field{Type: entity.Multiselect}, "a, b"},
+		{"date", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), entity.Field{Type: entity.Date}, "2024-03-01"},
+		{"formula", 4.0, entity.Field{Type: entity.Formula, ResultType: entity.Number}, int64(4)},
+		{"value of another type", "x", entity.Field{Type: entity.Number}, "x"},
+		{"missing", nil, entity.Field{Type: entity.Text}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Value(test.value, test.field); got != test.want {
+				t.Fatalf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func mustDecimal(t *testing.T, text string) interface{} {
+	t.Helper()
+	d, err := decimal.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCSVAbort(t *testing.T) {
+	var out bytes.Buffer
+	w := NewCSVWriter(&out)
+	w.WriteRow([]interface{}{"sku", "qty"})
+	w.WriteRow([]interface{}{"A,1", int64(2)})
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(&out)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][0] != "A,1" || records[2][0] != ErrorMarker {
+		t.Fatalf("unexpected records %q", records)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	write := func(finish func(*XLSXWriter) error) []byte {
+		var out bytes.Buffer
+		w, err := NewXLSXWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteRow([]interface{}{"sku", "qty"})
+		w.WriteRow([]interface{}{"<A>", int64(2)})
+		if err := finish(w); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+
+	complete := write((*XLSXWriter).Close)
+	archive, err := zip.NewReader(bytes.NewReader(complete), int64(len(complete)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != len(xlsxParts)+1 {
+		t.Fatalf("got %d parts", len(archive.File))
+	}
+
+	aborted := write((*XLSXWriter).Abort)
+	if _, err := zip.NewReader(bytes.NewReader(aborted), int64(len(aborted))); err == nil {
+		t.Fatal("an aborted workbook opens")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for index, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(index); got != want {
+			t.Fatalf("column %d: got %s, want %s", index, got, want)
+		}
+	}
+}
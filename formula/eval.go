@@ -0,0 +1,369 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// node is a type checked part of an expression. Values are float64 for
+// numbers, string for text, bool for booleans and nil for null.
+type node interface {
+	kind() kind
+	eval(data map[string]interface{}) interface{}
+}
+
+type literal struct {
+	value interface{}
+	k     kind
+}
+
+// reference reads the value of a field from the stock data, anything that is
+// not of the kind of the field reads as null
+type reference struct {
+	name string
+	k    kind
+}
+
+type not struct{ operand node }
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+type logic struct {
+	and         bool
+	left, right node
+}
+
+type call struct {
+	fn   *function
+	args []node
+	k    kind
+}
+
+func (n literal) kind() kind    { return n.k }
+func (n reference) kind() kind  { return n.k }
+func (n not) kind() kind        { return kindBoolean }
+func (n binary) kind() kind     { return kindNumber }
+func (n comparison) kind() kind { return kindBoolean }
+func (n logic) kind() kind      { return kindBoolean }
+func (n call) kind() kind       { return n.k }
+
+func (n literal) eval(map[string]interface{}) interface{} {
+	return n.value
+}
+
+func (n reference) eval(data map[string]interface{}) interface{} {
+	value := data[n.name]
+	switch n.k {
+	case kindNumber:
+		if number, ok := toNumber(value); ok {
+			return number
+		}
+	case kindText:
+		if text, ok := value.(string); ok {
+			return text
+		}
+	case kindBoolean:
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func (n not) eval(data map[string]interface{}) interface{} {
+	if b, ok := n.operand.eval(data).(bool); ok {
+		return !b
+	}
+	return nil
+}
+
+// eval of arithmetic is null when an operand is null, or when the result is
+// not a number, such as for a division by zero
+func (n binary) eval(data map[string]interface{}) interface{} {
+	x, xOK := n.left.eval(data).(float64)
+	y, yOK := n.right.eval(data).(float64)
+	if !xOK || !yOK {
+		return nil
+	}
+	var result float64
+	switch n.op {
+	case "+":
+		result = x + y
+	case "-":
+		result = x - y
+	case "*":
+		result = x * y
+	case "/":
+		if y == 0 {
+			return nil
+		}
+		result = x / y
+	case "%":
+		if y == 0 {
+			return nil
+		}
+		result = math.Mod(x, y)
+	}
+	return finite(result)
+}
+
+// eval of a comparison is null when an operand is null
+func (n comparison) eval(data map[string]interface{}) interface{} {
+	left, right := n.left.eval(data), n.right.eval(data)
+	if left == nil || right == nil {
+		return nil
+	}
+	order := 0
+	switch x := left.(type) {
+	case float64:
+		y := right.(float64)
+		if x < y {
+			order = -1
+		} else if x > y {
+			order = 1
+		}
+	case string:
+		order = strings.Compare(x, right.(string))
+	case bool:
+		if x != right.(bool) {
+			order = 1
+		}
+	}
+	switch n.op {
+	case "==":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// eval of AND and OR is null when an operand is null and the other one does
+// not decide the result on its own
+func (n logic) eval(data map[string]interface{}) interface{} {
+	left, leftOK := n.left.eval(data).(bool)
+	if leftOK && left != n.and {
+		return left
+	}
+	right, rightOK := n.right.eval(data).(bool)
+	if rightOK && right != n.and {
+		return right
+	}
+	if !leftOK || !rightOK {
+		return nil
+	}
+	return n.and
+}
+
+func (n call) eval(data map[string]interface{}) interface{} {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(data)
+		if args[i] == nil && n.fn.strict {
+			return nil
+		}
+	}
+	return n.fn.eval(args)
+}
+
+// function is a function formulas can call
+type function struct {
+	minArgs int
+	maxArgs int  // -1 for any number of arguments
+	strict  bool // Null when any argument is null
+	check   func(args []kind) (kind, error)
+	eval    func(args []interface{}) interface{}
+}
+
+func (f *function) arity() string {
+	switch {
+	case f.minArgs == f.maxArgs && f.minArgs == 1:
+		return "1 argument"
+	case f.minArgs == f.maxArgs:
+		return strconv.Itoa(f.minArgs) + " arguments"
+	case f.maxArgs < 0:
+		return "at least " + strconv.Itoa(f.minArgs) + " arguments"
+	}
+	return strconv.Itoa(f.minArgs) + " to " + strconv.Itoa(f.maxArgs) + " arguments"
+}
+
+var functions = map[string]*function{
+	"IF": {minArgs: 3, maxArgs: 3, check: checkIf, eval: func(args []interface{}) interface{} {
+		if condition, _ := args[0].(bool); condition {
+			return args[1]
+		}
+		return args[2]
+	}},
+	"COALESCE": {minArgs: 1, maxArgs: -1, check: checkSame, eval: func(args []interface{}) interface{} {
+		for _, arg := range args {
+			if arg != nil {
+				return arg
+			}
+		}
+		return nil
+	}},
+	"ISNULL": {minArgs: 1, maxArgs: 1, check: returns(kindBoolean), eval: func(args []interface{}) interface{} {
+		return args[0] == nil
+	}},
+	"ROUND": {minArgs: 1, maxArgs: 2, strict: true, check: expects(kindNumber, kindNumber), eval: func(args []interface{}) interface{} {
+		digits := 0.0
+		if len(args) > 1 {
+			digits = math.Trunc(args[1].(float64))
+		}
+		scale := math.Pow(10, digits)
+		return finite(math.Round(args[0].(float64)*scale) / scale)
+	}},
+	"ABS":   numeric(math.Abs),
+	"FLOOR": numeric(math.Floor),
+	"CEIL":  numeric(math.Ceil),
+	"MIN": {minArgs: 1, maxArgs: -1, strict: true, check: expects(kindNumber, kindNumber), eval: func(args []interface{}) interface{} {
+		result := args[0].(float64)
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg.(float64))
+		}
+		return result
+	}},
+	"MAX": {minArgs: 1, maxArgs: -1, strict: true, check: expects(kindNumber, kindNumber), eval: func(args []interface{}) interface{} {
+		result := args[0].(float64)
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg.(float64))
+		}
+		return result
+	}},
+	"CONCAT": {minArgs: 1, maxArgs: -1, check: returns(kindText), eval: func(args []interface{}) interface{} {
+		var text strings.Builder
+		for _, arg := range args {
+			text.WriteString(format(arg))
+		}
+		return text.String()
+	}},
+	"TEXT": {minArgs: 1, maxArgs: 1, strict: true, check: returns(kindText), eval: func(args []interface{}) interface{} {
+		return format(args[0])
+	}},
+	"UPPER": textual(strings.ToUpper),
+	"LOWER": textual(strings.ToLower),
+	"TRIM":  textual(strings.TrimSpace),
+	"LEN": {minArgs: 1, maxArgs: 1, strict: true, check: expects(kindNumber, kindText), eval: func(args []interface{}) interface{} {
+		return float64(utf8.RuneCountInString(args[0].(string)))
+	}},
+	"CONTAINS": {minArgs: 2, maxArgs: 2, strict: true, check: expects(kindBoolean, kindText), eval: func(args []interface{}) interface{} {
+		return strings.Contains(args[0].(string), args[1].(string))
+	}},
+}
+
+// numeric is a function of one number returning a number
+func numeric(fn func(float64) float64) *function {
+	return &function{minArgs: 1, maxArgs: 1, strict: true, check: expects(kindNumber, kindNumber), eval: func(args []interface{}) interface{} {
+		return finite(fn(args[0].(float64)))
+	}}
+}
+
+// textual is a function of one text returning a text
+func textual(fn func(string) string) *function {
+	return &function{minArgs: 1, maxArgs: 1, strict: true, check: expects(kindText, kindText), eval: func(args []interface{}) interface{} {
+		return fn(args[0].(string))
+	}}
+}
+
+// expects checks that every argument is of the kind, or null
+func expects(result kind, argument kind) func([]kind) (kind, error) {
+	return func(args []kind) (kind, error) {
+		for i, arg := range args {
+			if _, ok := unify(arg, argument); !ok {
+				return kindNull, fmt.Errorf("expects a %s as argument %d, got %s", argument, i+1, arg)
+			}
+		}
+		return result, nil
+	}
+}
+
+// returns accepts arguments of any kind
+func returns(result kind) func([]kind) (kind, error) {
+	return func([]kind) (kind, error) {
+		return result, nil
+	}
+}
+
+// checkSame requires all arguments to be of one kind, which is the result
+func checkSame(args []kind) (kind, error) {
+	shared := kindNull
+	for i, arg := range args {
+		var ok bool
+		if shared, ok = unify(shared, arg); !ok {
+			return kindNull, fmt.Errorf("expects arguments of one type, argument %d is %s", i+1, arg)
+		}
+	}
+	return shared, nil
+}
+
+func checkIf(args []kind) (kind, error) {
+	if _, ok := unify(args[0], kindBoolean); !ok {
+		return kindNull, fmt.Errorf("expects a boolean condition, got %s", args[0])
+	}
+	shared, ok := unify(args[1], args[2])
+	if !ok {
+		return kindNull, fmt.Errorf("expects both results to be of one type, got %s and %s", args[1], args[2])
+	}
+	return shared, nil
+}
+
+// format writes a value as text, null as nothing
+func format(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// finite turns results that are not numbers, such as an overflow, into null
+func finite(value float64) interface{} {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return value
+}
+
+// toNumber reads a stored number, whatever numeric type it was decoded as
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case primitive.Decimal128:
+		// Formulas compute with floats, decimals are rounded to the nearest one
+		return decimal.Float(v), true
+	}
+	return 0, false
+}
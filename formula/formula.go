@@ -0,0 +1,228 @@
+// Package formula parses, checks and evaluates the expressions of formula
+// fields, which compute a value from the other fields of a stock
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+// Formula is the parsed and type checked expression of a formula field
+type Formula struct {
+	Type   entity.FieldType // Type of the results: numberDecimal, text or checkbox
+	Fields []string         // Names of the fields the expression references, sorted
+	root   node
+}
+
+// Parse parses an expression such as `quantity * unit_price` and checks it
+// against the fields it may reference. Fields are referenced by name, between
+// backticks for names that are not plain words, and combined with arithmetic
+// (+ - * / %), comparisons (== != < <= > >=), AND, OR, NOT and the functions
+// IF, COALESCE, ISNULL, ROUND, ABS, FLOOR, CEIL, MIN, MAX, CONCAT, TEXT, UPPER,
+// LOWER, TRIM, LEN and CONTAINS. Number, numberDecimal, text, combobox,
+// checkbox and other formula fields can be referenced.
+func Parse(expression string, fields []entity.Field) (*Formula, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("formula expression is required")
+	}
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, fields: make(map[string]entity.Field, len(fields)), used: make(map[string]bool)}
+	for _, field := range fields {
+		p.fields[field.Name] = field
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", next.text, next.pos)
+	}
+
+	f := &Formula{root: root, Fields: []string{}}
+	switch root.kind() {
+	case kindNumber:
+		f.Type = entity.NumberDecimal
+	case kindText:
+		f.Type = entity.Text
+	case kindBoolean:
+		f.Type = entity.Checkbox
+	default:
+		return nil, fmt.Errorf("formula is always null")
+	}
+	for name := range p.used {
+		f.Fields = append(f.Fields, name)
+	}
+	sort.Strings(f.Fields)
+	return f, nil
+}
+
+// Evaluate computes the result of the formula for stock data, nil when it is
+// null. Referenced fields missing from the data are null, and so is anything
+// computed from them, except for COALESCE, ISNULL and CONCAT.
+func (f *Formula) Evaluate(data map[string]interface{}) interface{} {
+	return f.root.eval(data)
+}
+
+// Check parses the expression of a formula field against the other fields of
+// its form and returns the type of its results. fields may hold the field
+// itself, as it was before an update. A formula cannot reference itself, also
+// not through other formula fields, and the type of its results cannot change
+// while other formula fields reference it.
+func Check(field entity.Field, fields []entity.Field) (entity.FieldType, error) {
+	var others []entity.Field
+	var previous *entity.Field
+	for i := range fields {
+		if fields[i].ID == field.ID {
+			previous = &fields[i]
+		} else {
+			others = append(others, fields[i])
+		}
+	}
+	for _, name := range names(field.Expression) {
+		if name == field.Name {
+			return "", fmt.Errorf("formula %s cannot reference itself", field.Name)
+		}
+	}
+	f, err := Parse(field.Expression, others)
+	if err != nil {
+		return "", err
+	}
+
+	field.ResultType = f.Type
+	if previous != nil && previous.Type == entity.Formula && previous.ResultType != f.Type {
+		if referencing := Referencing(others, field.Name); len(referencing) > 0 {
+			return "", fmt.Errorf("formula %s must keep returning %s, formula field %s references it", field.Name, previous.ResultType, referencing[0].Name)
+		}
+	}
+	if _, err := order(append(others, field)); err != nil {
+		return "", err
+	}
+	return f.Type, nil
+}
+
+// Compute stores the results of the formula fields among fields in stock data,
+// computing formulas referencing other formulas last. A null result removes
+// the key of the field.
+func Compute(fields []entity.Field, data map[string]interface{}) error {
+	formulas, err := order(fields)
+	if err != nil {
+		return err
+	}
+	for _, computed := range formulas {
+		if value := computed.formula.Evaluate(data); value != nil {
+			data[computed.field.Name] = value
+		} else {
+			delete(data, computed.field.Name)
+		}
+	}
+	return nil
+}
+
+// Referencing returns the formula fields among fields whose expression
+// references the named field
+func Referencing(fields []entity.Field, name string) []entity.Field {
+	var referencing []entity.Field
+	for _, field := range fields {
+		if field.Type != entity.Formula || field.Name == name {
+			continue
+		}
+		for _, referenced := range names(field.Expression) {
+			if referenced == name {
+				referencing = append(referencing, field)
+				break
+			}
+		}
+	}
+	return referencing
+}
+
+// compiled is a formula field with its parsed expression
+type compiled struct {
+	field   entity.Field
+	formula *Formula
+}
+
+// order parses the formula fields among fields and sorts them so that every
+// formula comes after the formulas it references, failing on a cycle
+func order(fields []entity.Field) ([]compiled, error) {
+	byName := make(map[string]int)
+	var formulas []compiled
+	for _, field := range fields {
+		if field.Type != entity.Formula {
+			continue
+		}
+		f, err := Parse(field.Expression, fields)
+		if err != nil {
+			return nil, fmt.Errorf("formula %s: %w", field.Name, err)
+		}
+		byName[field.Name] = len(formulas)
+		formulas = append(formulas, compiled{field: field, formula: f})
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(formulas))
+	sorted := make([]compiled, 0, len(formulas))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(path, formulas[i].field.Name)
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("formulas reference each other: %s", strings.Join(path, " -> "))
+		case done:
+			return nil
+		}
+		state[i] = visiting
+		for _, name := range formulas[i].formula.Fields {
+			if j, ok := byName[name]; ok {
+				if err := visit(j, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = done
+		sorted = append(sorted, formulas[i])
+		return nil
+	}
+	for i := range formulas {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// names lists the field names an expression references, without checking it
+func names(expression string) []string {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for i, t := range tokens {
+		if t.kind != tokenIdent {
+			continue
+		}
+		if !t.quote {
+			if tokens[i+1].kind == tokenLParen {
+				continue
+			}
+			switch strings.ToUpper(t.text) {
+			case "AND", "OR", "NOT", "TRUE", "FALSE", "NULL":
+				continue
+			}
+		}
+		found = append(found, t.text)
+	}
+	return found
+}
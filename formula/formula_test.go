@@ -0,0 +1,151 @@
+package formula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+var testFields = []entity.Field{
+	{Name: "qty", Type: entity.Number},
+	{Name: "price", Type: entity.NumberDecimal},
+	{Name: "name", Type: entity.Text},
+	{Name: "unit cost", Type: entity.Number},
+	{Name: "active", Type: entity.Checkbox},
+	{Name: "tags", Type: entity.Multiselect},
+}
+
+func TestEvaluate(t *testing.T) {
+	data := map[string]interface{}{"qty": int64(4), "price": 2.5, "name": " Bolt ", "unit cost": 1.25, "active": true}
+	tests := []struct {
+		expression string
+		typ        entity.FieldType
+		want       interface{}
+	}{
+		{"qty * price", entity.NumberDecimal, 10.0},
+		{"1 + 2 * 3 - 4 / 2", entity.NumberDecimal, 5.0},
+		{"(1 + 2) * 3", entity.NumberDecimal, 9.0},
+		{"-qty % 3", entity.NumberDecimal, -1.0},
+		{"qty * `unit cost`", entity.NumberDecimal, 5.0},
+		{"qty / 0", entity.NumberDecimal, nil},
+		{"ROUND(price / 3, 2)", entity.NumberDecimal, 0.83},
+		{"ABS(-2) + FLOOR(1.5) + CEIL(1.5)", entity.NumberDecimal, 5.0},
+		{"MIN(qty, price, 3) + MAX(qty, 1)", entity.NumberDecimal, 6.5},
+		{"LEN(TRIM(name))", entity.NumberDecimal, 4.0},
+		{"COALESCE(missing, qty)", entity.NumberDecimal, 4.0},
+		{"UPPER(TRIM(name))", entity.Text, "BOLT"},
+		{"CONCAT(TRIM(name), \"-\", qty)", entity.Text, "Bolt-4"},
+		{"TEXT(price)", entity.Text, "2.5"},
+		{"IF(qty > 3, \"many\", \"few\")", entity.Text, "many"},
+		{"qty >= 4 AND NOT active", entity.Checkbox, false},
+		{"qty == 4 OR price < 1", entity.Checkbox, true},
+		{"CONTAINS(LOWER(name), \"bolt\")", entity.Checkbox, true},
+		{"ISNULL(missing)", entity.Checkbox, true},
+		{"name != \"x\"", entity.Checkbox, true},
+	}
+	fields := append(append([]entity.Field{}, testFields...), entity.Field{Name: "missing", Type: entity.Number})
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			f, err := Parse(test.expression, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Type != test.typ {
+				t.Fatalf("got type %s, want %s", f.Type, test.typ)
+			}
+			if got := f.Evaluate(data); got != test.want {
+				t.Fatalf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"", "required"},
+		{"qty +", "end"},
+		{"qty price", "position"},
+		{"(qty", ""},
+		{"qty + name", ""},
+		{"unknown", "unknown"},
+		{"tags", "tags"},
+		{"UNKNOWN(qty)", "UNKNOWN"},
+		{"ROUND()", "ROUND"},
+		{"IF(qty, 1, 2)", ""},
+		{"\"open", ""},
+		{"qty & 1", ""},
+		{"null", "null"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Parse(test.expression, testFields)
+			if err == nil {
+				t.Fatal("parsed")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got %q, want it to mention %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	total := entity.Field{ID: uuid.New(), Name: "total", Type: entity.Formula, Expression: "qty * price", ResultType: entity.NumberDecimal}
+	double := entity.Field{ID: uuid.New(), Name: "double", Type: entity.Formula, Expression: "total * 2", ResultType: entity.NumberDecimal}
+	fields := append(append([]entity.Field{}, testFields...), total, double)
+
+	if typ, err := Check(total, fields); err != nil || typ != entity.NumberDecimal {
+		t.Fatalf("got %s, %v", typ, err)
+	}
+
+	self := total
+	self.Expression = "total + 1"
+	if _, err := Check(self, fields); err == nil {
+		t.Fatal("a formula referenced itself")
+	}
+
+	cycle := total
+	cycle.Expression = "double + 1"
+	if _, err := Check(cycle, fields); err == nil {
+		t.Fatal("formulas referenced each other")
+	}
+
+	// double needs total to stay a number
+	text := total
+	text.Expression = "name"
+	if _, err := Check(text, fields); err == nil || !strings.Contains(err.Error(), "double") {
+		t.Fatalf("got %v, want the referencing formula named", err)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	fields := append(append([]entity.Field{}, testFields...),
+		// Referencing formulas come first, so Compute has to order them
+		entity.Field{Name: "double", Type: entity.Formula, Expression: "total * 2", ResultType: entity.NumberDecimal},
+		entity.Field{Name: "total", Type: entity.Formula, Expression: "qty * price", ResultType: entity.NumberDecimal},
+	)
+	data := map[string]interface{}{"qty": int64(2), "price": 1.5, "double": "stale"}
+	if err := Compute(fields, data); err != nil {
+		t.Fatal(err)
+	}
+	if data["total"] != 3.0 || data["double"] != 6.0 {
+		t.Fatalf("computed %v", data)
+	}
+
+	// Null results remove the stored value
+	delete(data, "qty")
+	if err := Compute(fields, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := data["total"]; ok {
+		t.Fatalf("null result kept: %v", data)
+	}
+	if _, ok := data["double"]; ok {
+		t.Fatalf("null result kept: %v", data)
+	}
+}
@@ -0,0 +1,361 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+// kind is the type of a value in a formula
+type kind int
+
+const (
+	kindNull kind = iota // The null literal, which fits any other kind
+	kindNumber
+	kindText
+	kindBoolean
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindNumber:
+		return "number"
+	case kindText:
+		return "text"
+	case kindBoolean:
+		return "boolean"
+	}
+	return "null"
+}
+
+// fieldKind returns the kind of the values of a field, formula fields having
+// the kind of their results
+func fieldKind(field entity.Field) (kind, error) {
+	switch field.Type {
+	case entity.Number, entity.NumberDecimal:
+		return kindNumber, nil
+	case entity.Text, entity.Combobox:
+		return kindText, nil
+	case entity.Checkbox:
+		return kindBoolean, nil
+	case entity.Formula:
+		switch field.ResultType {
+		case entity.NumberDecimal:
+			return kindNumber, nil
+		case entity.Text:
+			return kindText, nil
+		case entity.Checkbox:
+			return kindBoolean, nil
+		}
+	}
+	return kindNull, fmt.Errorf("%s field %q cannot be used in a formula", field.Type, field.Name)
+}
+
+// unify returns the kind two values of an expression share, if any
+func unify(a, b kind) (kind, bool) {
+	switch {
+	case a == kindNull:
+		return b, true
+	case b == kindNull, a == b:
+		return a, true
+	}
+	return kindNull, false
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	fields map[string]entity.Field
+	used   map[string]bool // Names of the fields referenced so far
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && !t.quote && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// operator consumes the next token if it is one of the operators
+func (p *parser) operator(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.keyword("OR") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = logical("OR", left, right, pos); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.keyword("AND") {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = logical("AND", left, right, pos); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	pos := p.peek().pos
+	if p.keyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := unify(operand.kind(), kindBoolean); !ok {
+			return nil, fmt.Errorf("NOT at position %d expects a boolean, got %s", pos, operand.kind())
+		}
+		return not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.operator("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if left.kind() == kindNull || right.kind() == kindNull {
+		return nil, fmt.Errorf("comparing with null at position %d is never true, use ISNULL", op.pos)
+	}
+	shared, ok := unify(left.kind(), right.kind())
+	if !ok {
+		return nil, fmt.Errorf("cannot compare %s with %s at position %d", left.kind(), right.kind(), op.pos)
+	}
+	if shared == kindBoolean && op.text != "==" && op.text != "!=" {
+		return nil, fmt.Errorf("booleans only support == and != at position %d", op.pos)
+	}
+	return comparison{op: op.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.operator("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = arithmetic(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseNegation()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.operator("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNegation()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = arithmetic(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseNegation() (node, error) {
+	op, ok := p.operator("-", "+")
+	if !ok {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseNegation()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := unify(operand.kind(), kindNumber); !ok {
+		return nil, fmt.Errorf("%s at position %d expects a number, got %s", op.text, op.pos, operand.kind())
+	}
+	if op.text == "+" {
+		return operand, nil
+	}
+	return arithmetic(op, literal{value: float64(0), k: kindNumber}, operand)
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return literal{value: value, k: kindNumber}, nil
+	case tokenString:
+		return literal{value: t.text, k: kindText}, nil
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ) at position %d", closing.pos)
+		}
+		return expr, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLParen && !t.quote {
+			return p.parseCall(t)
+		}
+		if !t.quote {
+			switch strings.ToLower(t.text) {
+			case "true", "false":
+				return literal{value: strings.EqualFold(t.text, "true"), k: kindBoolean}, nil
+			case "null":
+				return literal{k: kindNull}, nil
+			}
+		}
+		field, ok := p.fields[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q at position %d", t.text, t.pos)
+		}
+		k, err := fieldKind(field)
+		if err != nil {
+			return nil, err
+		}
+		p.used[field.Name] = true
+		return reference{name: field.Name, k: k}, nil
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of formula")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// parseCall parses the arguments of a function call and checks them against
+// the function
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[strings.ToUpper(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", name.text, name.pos)
+	}
+	p.next() // (
+
+	var args []node
+	if p.peek().kind == tokenRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			t := p.next()
+			if t.kind == tokenRParen {
+				break
+			}
+			if t.kind != tokenComma {
+				return nil, fmt.Errorf("expected , or ) at position %d", t.pos)
+			}
+		}
+	}
+
+	upper := strings.ToUpper(name.text)
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("%s at position %d takes %s", upper, name.pos, fn.arity())
+	}
+	kinds := make([]kind, len(args))
+	for i, arg := range args {
+		kinds[i] = arg.kind()
+	}
+	k, err := fn.check(kinds)
+	if err != nil {
+		return nil, fmt.Errorf("%s at position %d %s", upper, name.pos, err)
+	}
+	return call{fn: fn, args: args, k: k}, nil
+}
+
+// arithmetic builds the node of an arithmetic operator on two numbers
+func arithmetic(op token, left, right node) (node, error) {
+	for _, operand := range []node{left, right} {
+		if _, ok := unify(operand.kind(), kindNumber); !ok {
+			return nil, fmt.Errorf("%s at position %d expects numbers, got %s, use CONCAT to join text", op.text, op.pos, operand.kind())
+		}
+	}
+	return binary{op: op.text, left: left, right: right}, nil
+}
+
+// logical builds the node of AND or OR on two booleans
+func logical(op string, left, right node, pos int) (node, error) {
+	for _, operand := range []node{left, right} {
+		if _, ok := unify(operand.kind(), kindBoolean); !ok {
+			return nil, fmt.Errorf("%s at position %d expects booleans, got %s", op, pos, operand.kind())
+		}
+	}
+	return logic{and: op == "AND", left: left, right: right}, nil
+}
@@ -0,0 +1,80 @@
+package formula
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+)
+
+// Updater stores the results of formula fields again when formulas change
+type Updater struct {
+	stocks       stockRepo.StockStore
+	transactions transaction.Transactor
+}
+
+func NewUpdater(stocks stockRepo.StockStore, transactions transaction.Transactor) *Updater {
+	return &Updater{
+		stocks:       stocks,
+		transactions: transactions,
+	}
+}
+
+// StockUpdate is a stock before and after computing its formulas again
+type StockUpdate struct {
+	Before entity.Stock
+	After  entity.Stock
+}
+
+// Recompute computes the formula fields of every stock of a form, including
+// the ones in the trash, from the fields of the form and saves the stocks
+// whose results changed, all together or not at all
+func (u *Updater) Recompute(ctx context.Context, formID uuid.UUID, fields []entity.Field) ([]StockUpdate, error) {
+	stocks, err := u.stocks.GetAllStocksByFormId(formID)
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := u.stocks.GetTrashedStocks([]uuid.UUID{formID}, time.Time{}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	updates := []StockUpdate{}
+	for _, stock := range append(stocks, trashed...) {
+		after := stock
+		after.Data = make(map[string]interface{}, len(stock.Data))
+		for key, value := range stock.Data {
+			after.Data[key] = value
+		}
+		if err := Compute(fields, after.Data); err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(after.Data, stock.Data) {
+			continue
+		}
+		after.Version++
+		after.UpdatedAt = now
+		updates = append(updates, StockUpdate{Before: stock, After: after})
+	}
+	if len(updates) == 0 {
+		return updates, nil
+	}
+
+	err = u.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, update := range updates {
+			if err := u.stocks.UpdateStock(ctx, update.After); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	repository "github.com/kbc0/DynamicStockManager/repository/audit"
+	"github.com/kbc0/DynamicStockManager/utils"
+)
+
+type AuditHandler struct {
+	repo repository.AuditStore
+}
+
+func NewAuditHandler(repo repository.AuditStore) *AuditHandler {
+	return &AuditHandler{repo: repo}
+}
+
+// GetAuditEntries lists the audit entries of the forms of the authenticated
+// user, newest first. They can be narrowed down with ?entityType= (form, field
+// or stock), ?entityId=, ?formId=, ?userId= for the user who made the change,
+// and a ?from= and ?to= time range given as RFC 3339 timestamps or dates.
+func (h *AuditHandler) GetAuditEntries(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	filter := repository.AuditFilter{OwnerID: userID}
+	switch entityType := entity.AuditEntityType(c.Query("entityType")); entityType {
+	case "", entity.AuditForm, entity.AuditField, entity.AuditStock:
+		filter.EntityType = entityType
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "entityType must be form, field or stock"})
+	}
+	for param, target := range map[string]*uuid.UUID{
+		"entityId": &filter.EntityID,
+		"formId":   &filter.FormID,
+		"userId":   &filter.UserID,
+	} {
+		if value := c.Query(param); value != "" {
+			if *target, err = uuid.Parse(value); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid " + param + " format"})
+			}
+		}
+	}
+	for param, target := range map[string]*time.Time{
+		"from": &filter.From,
+		"to":   &filter.To,
+	} {
+		if value := c.Query(param); value != "" {
+			if *target, err = parseTime(value); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid " + param + " time, expected RFC 3339 or YYYY-MM-DD"})
+			}
+		}
+	}
+
+	limit, offset := utils.ParsePagination(c)
+	entries, err := h.repo.FindEntries(filter, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(entries)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/audit"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	"github.com/kbc0/DynamicStockManager/schema"
+	"github.com/kbc0/DynamicStockManager/utils"
+)
+
+// Strategies for values that cannot be converted to the new type of a field
+const (
+	OnFailureDefault = "default" // Replace the value with the default value of the field
+	OnFailureClear   = "clear"   // Remove the value from the stock
+)
+
+// maxReportedFailures caps the failures listed one by one in a report
+const maxReportedFailures = 100
+
+// TypeChange is the new definition of a field of another type. Name, order,
+// visibility and uniqueness stay as they are; type specific attributes left
+// out of the request are cleared.
+type TypeChange struct {
+	entity.Field
+	Mapping   map[string]interface{} `json:"mapping,omitempty"`   // New values by the text of stored values
+	OnFailure string                 `json:"onFailure,omitempty"` // default or clear, required when values fail to convert
+}
+
+// ConversionReport tells how the values stocks hold for a field convert to its
+// new type
+type ConversionReport struct {
+	Total     int                 `json:"total"`     // Stocks holding a value for the field, including the ones in the trash
+	Converted int                 `json:"converted"` // Values that convert cleanly
+	Failed    int                 `json:"failed"`
+	Reasons   map[string]int      `json:"reasons"`  // Number of failures by reason
+	Failures  []ConversionFailure `json:"failures"` // The first failures, one by one
+}
+
+type ConversionFailure struct {
+	StockID uuid.UUID   `json:"stockId"`
+	Value   interface{} `json:"value"`
+	Error   string      `json:"error"`
+}
+
+// conversion is a planned type change: the new field and the stocks with
+// their converted data, along with the stocks whose value failed to convert
+type conversion struct {
+	field     entity.Field
+	onFailure string
+	fallback  interface{} // Stored default value replacing failed values
+	converted []stockConversion
+	failed    []stockConversion
+	report    ConversionReport
+}
+
+// stockConversion is a stock before and after converting its data
+type stockConversion struct {
+	before entity.Stock
+	after  entity.Stock
+}
+
+// PreviewTypeChange reports how the values stocks hold for a field would convert
+// to a new type, without changing anything
+func (h *FieldHandler) PreviewTypeChange(c *fiber.Ctx) error {
+	plan, failure := h.planTypeChange(c)
+	if failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
+	return c.JSON(fiber.Map{"field": plan.field, "report": plan.report})
+}
+
+// ChangeFieldType changes the type of a field and converts the values stocks
+// hold for it, all together or not at all. Values that fail to convert are
+// handled by the onFailure strategy, without which the change is refused.
+func (h *FieldHandler) ChangeFieldType(c *fiber.Ctx) error {
+	plan, failure := h.planTypeChange(c)
+	if failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
+	if len(plan.failed) > 0 {
+		if plan.onFailure == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":  fmt.Sprintf("%d values cannot be converted, choose onFailure %s or %s", plan.report.Failed, OnFailureDefault, OnFailureClear),
+				"report": plan.report,
+			})
+		}
+		for _, failed := range plan.failed {
+			if plan.onFailure == OnFailureDefault {
+				failed.after.Data[plan.field.Name] = plan.fallback
+			} else {
+				delete(failed.after.Data, plan.field.Name)
+			}
+			plan.converted = append(plan.converted, failed)
+		}
+	}
+
+	previous := *middleware.FieldFromContext(c)
+	now := time.Now()
+	err := h.transactions.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+		for i := range plan.converted {
+			stock := &plan.converted[i].after
+			stock.Version++
+			stock.UpdatedAt = now
+			if err := h.stocks.UpdateStock(ctx, *stock); err != nil {
+				return err
+			}
+		}
+		return h.repo.ReplaceField(ctx, plan.field)
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrVersionConflict) {
+			return c.Status(utils.ConflictStatus(c)).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, utils.ErrDuplicate) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	changes := []audit.Change{{Action: entity.AuditUpdate, EntityType: entity.AuditField, EntityID: previous.ID, FormID: previous.FormID, Before: previous, After: plan.field}}
+	for _, stock := range plan.converted {
+		changes = append(changes, audit.Change{Action: entity.AuditUpdate, EntityType: entity.AuditStock, EntityID: stock.after.ID, FormID: previous.FormID, Before: stock.before, After: stock.after})
+	}
+	for _, change := range changes {
+		if err := h.audit.Record(c, change); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	utils.SetETag(c, plan.field.Version)
+	return c.JSON(fiber.Map{"field": plan.field, "report": plan.report})
+}
+
+// planTypeChange validates the requested type change of the field resolved by
+// the access middleware and converts the values of its stocks in memory
+func (h *FieldHandler) planTypeChange(c *fiber.Ctx) (*conversion, *fiber.Error) {
+	existing := middleware.FieldFromContext(c)
+	if existing == nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Field not found")
+	}
+	if !utils.IfMatch(c, existing.Version) {
+		return nil, fiber.NewError(fiber.StatusPreconditionFailed, utils.ErrVersionConflict.Error())
+	}
+
+	var change TypeChange
+	if err := c.BodyParser(&change); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+	}
+	switch change.OnFailure {
+	case "", OnFailureDefault, OnFailureClear:
+	default:
+		return nil, fiber.NewError(fiber.StatusBadRequest, "onFailure must be default or clear")
+	}
+
+	field := change.Field
+	field.ID, field.FormID, field.Name = existing.ID, existing.FormID, existing.Name
+	field.IsHidden, field.Order, field.IsUnique = existing.IsHidden, existing.Order, existing.IsUnique
+	field.Version = existing.Version + 1
+	field.DeletedAt, field.DeletedBy = nil, nil
+	if field.Type == entity.Reference || existing.Type == entity.Reference {
+		// Values cannot be converted to or from references to stocks
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The type of a reference field cannot be changed, nor a field changed to a reference")
+	}
+	if field.Type == entity.Attachment || existing.Type == entity.Attachment {
+		// Nor to or from uploaded files
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The type of an attachment field cannot be changed, nor a field changed to an attachment")
+	}
+	if field.Type == entity.Formula || existing.Type == entity.Formula {
+		// Nor to or from computed values
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The type of a formula field cannot be changed, nor a field changed to a formula")
+	}
+	fields, err := h.repo.GetFieldsByFormID(existing.FormID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if failure := usedByFormula(fields, *existing); failure != nil {
+		return nil, failure
+	}
+	if err := schema.ValidateField(field); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	var fallback interface{}
+	if change.OnFailure == OnFailureDefault {
+		if field.DefaultValue == nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "onFailure default requires a default value")
+		}
+		var err error
+		if fallback, err = schema.ParseValue(field.DefaultValue, field); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid default value: "+err.Error())
+		}
+	}
+
+	// Stock data must be keyed by the current name of the field
+	pending, err := h.jobs.Unfinished(existing.FormID, existing.ID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if pending != nil {
+		return nil, fiber.NewError(fiber.StatusConflict, "The stock data of the field is still being migrated by job "+pending.ID.String())
+	}
+
+	// Stocks in the trash are converted too, so that they can be restored
+	stocks, err := h.stocks.GetAllStocksByFormId(existing.FormID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	trashed, err := h.stocks.GetTrashedStocks([]uuid.UUID{existing.FormID}, time.Time{}, 0, 0)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	plan := &conversion{field: field, onFailure: change.OnFailure, fallback: fallback, report: ConversionReport{Reasons: map[string]int{}, Failures: []ConversionFailure{}}}
+	taken := make(map[string]bool) // Converted values, or selected options, of a unique field
+	for _, stock := range append(stocks, trashed...) {
+		value, ok := stock.Data[existing.Name]
+		if !ok {
+			continue
+		}
+		plan.report.Total++
+
+		converted, err := schema.Convert(value, field, change.Mapping)
+		if err == nil && field.IsUnique {
+			// Every option of a unique multiselect can only be selected once
+			keys := []string{fmt.Sprint(converted)}
+			if selection, ok := schema.Selection(converted); ok {
+				keys = selection
+			}
+			for _, key := range keys {
+				if taken[key] {
+					err = errors.New("value is not unique after conversion")
+				}
+			}
+			for _, key := range keys {
+				taken[key] = true
+			}
+		}
+		if err != nil {
+			plan.report.Failed++
+			plan.report.Reasons[err.Error()]++
+			if len(plan.report.Failures) < maxReportedFailures {
+				plan.report.Failures = append(plan.report.Failures, ConversionFailure{StockID: stock.ID, Value: value, Error: err.Error()})
+			}
+			plan.failed = append(plan.failed, stockConversion{before: stock, after: withData(stock)})
+			continue
+		}
+
+		plan.report.Converted++
+		if !reflect.DeepEqual(converted, value) {
+			after := withData(stock)
+			after.Data[existing.Name] = converted
+			plan.converted = append(plan.converted, stockConversion{before: stock, after: after})
+		}
+	}
+	return plan, nil
+}
+
+// withData returns the stock with a copy of its data, safe to change
+func withData(stock entity.Stock) entity.Stock {
+	data := make(map[string]interface{}, len(stock.Data))
+	for key, value := range stock.Data {
+		data[key] = value
+	}
+	stock.Data = data
+	return stock
+}
@@ -4,20 +4,43 @@ import (
 	"errors"
 
 	"reflect"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/audit"
 	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/formula"
+	"github.com/kbc0/DynamicStockManager/job"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	"github.com/kbc0/DynamicStockManager/reference"
 	repository "github.com/kbc0/DynamicStockManager/repository/field"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	"github.com/kbc0/DynamicStockManager/schema"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type FieldHandler struct {
-	repo *repository.FieldRepository
+	repo         repository.FieldStore
+	stocks       stockRepo.StockStore
+	jobs         *job.Runner
+	references   *reference.Resolver
+	transactions transaction.Transactor
+	formulas     *formula.Updater
+	audit        *audit.Recorder
 }
 
-func NewFieldHandler(repo *repository.FieldRepository) *FieldHandler {
+func NewFieldHandler(repo repository.FieldStore, stocks stockRepo.StockStore, jobs *job.Runner, references *reference.Resolver, transactions transaction.Transactor, formulas *formula.Updater, audit *audit.Recorder) *FieldHandler {
 	return &FieldHandler{
-		repo: repo,
+		repo:         repo,
+		stocks:       stocks,
+		jobs:         jobs,
+		references:   references,
+		transactions: transactions,
+		formulas:     formulas,
+		audit:        audit,
 	}
 }
 
@@ -35,15 +58,51 @@ func (h *FieldHandler) AddFieldToForm(c *fiber.Ctx) error {
 
 	field.FormID = formID
 	field.ID = uuid.New()
+	field.Version = 1
+	field.ResultType = ""
+	// Only the trash endpoints move a field to the trash
+	field.DeletedAt, field.DeletedBy = nil, nil
 
 	// Perform validations based on the field type
-	if err := validateField(field); err != nil {
+	if err := schema.ValidateField(field); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
+	if field.Type == entity.Reference {
+		if err := h.references.CheckTarget(field); err != nil {
+			return c.Status(referenceErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	var fields []entity.Field
+	if field.Type == entity.Formula {
+		if fields, err = h.repo.GetFieldsByFormID(formID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if field.ResultType, err = formula.Check(field, fields); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid formula: " + err.Error()})
+		}
+	}
+
+	if failure := h.nameInUse(formID, field.Name); failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
 
 	if err := h.repo.CreateField(field); err != nil {
+		if errors.Is(err, utils.ErrDuplicate) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
+	change := audit.Change{Action: entity.AuditCreate, EntityType: entity.AuditField, EntityID: field.ID, FormID: formID, After: field}
+	if err := h.audit.Record(c, change); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Existing stocks get the results of the new formula right away
+	if field.Type == entity.Formula {
+		if err := h.recompute(c, formID, append(fields, field)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Field added, but computing its values failed: " + err.Error()})
+		}
+	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Field added to form"})
 }
@@ -87,6 +146,7 @@ func (h *FieldHandler) GetField(c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "This is a hidden field"})
     }
 
+    utils.SetETag(c, field.Version)
     return c.JSON(field)
 }
 
@@ -104,6 +164,11 @@ func (h *FieldHandler) UpdateField(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Field not found"})
 	}
 
+	before := *existingField
+	if !utils.IfMatch(c, before.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
+	}
+
 	// Parse the request into a new field struct which will contain only the fields that were provided in the request
 	var updates entity.Field
 	if err := c.BodyParser(&updates); err != nil {
@@ -124,72 +189,213 @@ func (h *FieldHandler) UpdateField(c *fiber.Ctx) error {
 		}
 	}
 
+	// Identity, version and trash state are not up to the client
+	existingField.ID = before.ID
+	existingField.FormID = before.FormID
+	existingField.Version = before.Version + 1
+	existingField.DeletedAt, existingField.DeletedBy = before.DeletedAt, before.DeletedBy
+
 	// Validate the potentially updated field
-	if err := validateField(*existingField); err != nil {
+	if existingField.Type != entity.Formula {
+		existingField.Expression, existingField.ResultType = "", ""
+	}
+	if existingField.Type != entity.Text {
+		existingField.Format = ""
+	}
+	if existingField.Format != entity.FormatRegex {
+		existingField.Pattern = ""
+	}
+	if existingField.Type != entity.NumberDecimal {
+		existingField.Scale, existingField.Precision, existingField.Rounding = nil, nil, ""
+		existingField.MinDecimal, existingField.MaxDecimal = "", ""
+	}
+	if err := schema.ValidateField(*existingField); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
+	if existingField.Type == entity.Reference {
+		// Stored references point into the target form
+		if before.Type == entity.Reference && *existingField.TargetFormID != *before.TargetFormID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The target form of a reference field cannot be changed"})
+		}
+		if err := h.references.CheckTarget(*existingField); err != nil {
+			return c.Status(referenceErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	// Formulas reference fields by name and rely on their type
+	fields, err := h.repo.GetFieldsByFormID(before.FormID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if existingField.Name != before.Name || existingField.Type != before.Type {
+		if failure := usedByFormula(fields, before); failure != nil {
+			return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+		}
+	}
+	if existingField.Type == entity.Formula {
+		if existingField.ResultType, err = formula.Check(*existingField, fields); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid formula: " + err.Error()})
+		}
+	}
+
+	// Values stocks hold for the field are converted by ChangeFieldType
+	if existingField.Type != before.Type {
+		holding, err := h.stocks.CountStocksWithDataKey(before.FormID, before.Name)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if holding > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Stocks hold values for this field, change its type with POST /api/v1/form/:_id/field/:field_id/type"})
+		}
+	}
+
+	if existingField.Name != before.Name {
+		if failure := h.nameInUse(before.FormID, existingField.Name); failure != nil {
+			return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+		}
+	}
 
 	// Save the updated field entity
 	if err := h.repo.UpdateField(*existingField); err != nil {
+		if errors.Is(err, utils.ErrVersionConflict) {
+			return c.Status(utils.ConflictStatus(c)).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, utils.ErrDuplicate) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	change := audit.Change{Action: entity.AuditUpdate, EntityType: entity.AuditField, EntityID: fieldID, FormID: existingField.FormID, Before: before, After: existingField}
+	if err := h.audit.Record(c, change); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	utils.SetETag(c, existingField.Version)
+
+	// A changed formula is computed again for every stock, under the new name
+	// of a renamed field, whose values under the old name are then dropped by
+	// the renaming job
+	if existingField.Type == entity.Formula && (before.Type != entity.Formula || existingField.Expression != before.Expression) {
+		if err := h.recompute(c, existingField.FormID, replaceField(fields, *existingField)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Field updated, but computing its values failed: " + err.Error()})
+		}
+	}
+
+	// Stock data is keyed by field name, so a rename moves it in the background
+	if existingField.Name != before.Name {
+		renaming, err := h.jobs.Submit(entity.Job{
+			Kind:      entity.JobRenameField,
+			FormID:    existingField.FormID,
+			FieldID:   existingField.ID,
+			FieldName: before.Name,
+			NewName:   existingField.Name,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Field renamed, but renaming its stock data failed: " + err.Error()})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Field updated", "job": renaming})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Field updated"})
 }
 
-// DeleteField removes a field from a form
-func (h *FieldHandler) DeleteField(c *fiber.Ctx) error {
-	fieldID, err := uuid.Parse(c.Params("field_id"))
+// nameInUse refuses a field name under which a job still moves or removes stock
+// data, since the job finds that data by name and would take the values of the
+// field along
+func (h *FieldHandler) nameInUse(formID uuid.UUID, name string) *fiber.Error {
+	pending, err := h.jobs.UsingName(formID, name)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid field ID format"})
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if pending != nil {
+		return fiber.NewError(fiber.StatusConflict, "The stock data under the name "+name+" is still being migrated by job "+pending.ID.String())
 	}
+	return nil
+}
 
-	if err := h.repo.DeleteField(fieldID); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+// usedByFormula refuses changes to a field that would break the formula fields
+// among fields referencing it
+func usedByFormula(fields []entity.Field, field entity.Field) *fiber.Error {
+	if referencing := formula.Referencing(fields, field.Name); len(referencing) > 0 {
+		return fiber.NewError(fiber.StatusConflict, field.Name+" is used by formula field "+referencing[0].Name+", change the formula first")
 	}
+	return nil
+}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Field deleted"})
+// recompute computes the formula fields of every stock of the form again and
+// records the stocks whose results changed
+func (h *FieldHandler) recompute(c *fiber.Ctx, formID uuid.UUID, fields []entity.Field) error {
+	updates, err := h.formulas.Recompute(c.UserContext(), formID, fields)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		change := audit.Change{Action: entity.AuditUpdate, EntityType: entity.AuditStock, EntityID: update.After.ID, FormID: formID, Before: update.Before, After: update.After}
+		if err := h.audit.Record(c, change); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func validateField(field entity.Field) error {
-    // Add validation logic here based on field.Type
-    switch field.Type {
-    case entity.Combobox:
-        if len(field.Options) == 0 || len(field.Options) > 10 {
-            return errors.New("combobox must have 1 to 10 options")
-        }
-        if field.DefaultValue == nil || !contains(field.Options, field.DefaultValue.(string)) {
-            return errors.New("default value must be one of the provided options")
-        }
-    case entity.Text:
-        // Handling -1 as no limit for maxValue in text fields
-        if field.MinValue != nil && field.MaxValue != nil {
-            if *field.MaxValue == -1 {
-                field.MaxValue = nil // Set maxValue to nil indicating no upper limit
-            } else if *field.MinValue > *field.MaxValue {
-                return errors.New("min value cannot be greater than max value")
-            }
-        }
-    case entity.Number, entity.NumberDecimal:
-        // Handling -1 as no limit for maxValue in number and numberDecimal fields
-        if field.MinValue != nil && field.MaxValue != nil {
-            if *field.MaxValue == -1 {
-                field.MaxValue = nil // Set maxValue to nil indicating no upper limit
-            } else if *field.MinValue > *field.MaxValue {
-                return errors.New("min value cannot be greater than max value")
-            }
-        }
-    }
+// replaceField returns the fields with the field of the same ID replaced
+func replaceField(fields []entity.Field, field entity.Field) []entity.Field {
+	replaced := make([]entity.Field, len(fields))
+	for i := range fields {
+		replaced[i] = fields[i]
+		if fields[i].ID == field.ID {
+			replaced[i] = field
+		}
+	}
+	return replaced
+}
 
-    return nil
+// referenceErrorStatus returns the status code to answer an error checking the
+// target form of a reference field with
+func referenceErrorStatus(err error) int {
+	if errors.Is(err, reference.ErrInvalid) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
 }
 
-// contains checks if a slice contains a specific string
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
+// DeleteField removes a field from a form
+func (h *FieldHandler) DeleteField(c *fiber.Ctx) error {
+	fieldID, err := uuid.Parse(c.Params("field_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid field ID format"})
+	}
+
+	// The access middleware has already loaded the field
+	field := middleware.FieldFromContext(c)
+	if field != nil {
+		fields, err := h.repo.GetFieldsByFormID(field.FormID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if failure := usedByFormula(fields, *field); failure != nil {
+			return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
 		}
 	}
-	return false
+
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	// A unique field in the trash stays enforced, so that it can be restored
+	if err := h.repo.TrashField(fieldID, userID, time.Now()); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Field not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	change := audit.Change{Action: entity.AuditDelete, EntityType: entity.AuditField, EntityID: fieldID, Before: field}
+	if field != nil {
+		change.FormID = field.FormID
+	}
+	if err := h.audit.Record(c, change); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Field moved to the trash"})
 }
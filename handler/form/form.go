@@ -1,29 +1,32 @@
 package handler
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/audit"
 	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	"github.com/kbc0/DynamicStockManager/reference"
 	"github.com/kbc0/DynamicStockManager/repository/form"
-	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
-	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
 
 	utils "github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type FormHandler struct {
-	repo *repository.FormRepository
-	fieldRepo *fieldRepo.FieldRepository
-	stockRepo *stockRepo.StockRepository
+	repo repository.FormStore
+	references *reference.Resolver
+	audit *audit.Recorder
 }
 
-func NewFormHandler(repo *repository.FormRepository, fieldRepo *fieldRepo.FieldRepository, stockRepo *stockRepo.StockRepository) *FormHandler {
+func NewFormHandler(repo repository.FormStore, references *reference.Resolver, audit *audit.Recorder) *FormHandler {
 	return &FormHandler{
 		repo: repo,
-		fieldRepo: fieldRepo,
-		stockRepo: stockRepo,
+		references: references,
+		audit: audit,
 	}
 }
 
@@ -35,6 +38,9 @@ func (h *FormHandler) CreateFormHandler(c *fiber.Ctx) error {
 	if err := c.BodyParser(&form); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
+	form.Version = 1
+	// Only the trash endpoints move a form to the trash
+	form.DeletedAt, form.DeletedBy = nil, nil
 
 	// Authenticate and authorize
 	userID, err := utils.ExtractUserID(c)
@@ -44,8 +50,15 @@ func (h *FormHandler) CreateFormHandler(c *fiber.Ctx) error {
 	form.UserID = userID
 
 	if err := h.repo.CreateForm(form); err != nil {
+		if errors.Is(err, utils.ErrDuplicate) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
+	change := audit.Change{Action: entity.AuditCreate, EntityType: entity.AuditForm, EntityID: form.ID, FormID: form.ID, After: form}
+	if err := h.audit.Record(c, change); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"ID":form.ID.String(),"message": "Form created"})
 }
 
@@ -65,14 +78,12 @@ func (h *FormHandler) GetFormsHandler(c *fiber.Ctx) error {
 
 // GetFormHandler retrieves a single form by ID
 func (h *FormHandler) GetFormHandler(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("_id"))
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID format"})
-	}
-	form, err := h.repo.GetFormByID(id)
-	if err != nil {
+	// The form has already been resolved and authorized by the access middleware
+	form := middleware.FormFromContext(c)
+	if form == nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
 	}
+	utils.SetETag(c, form.Version)
 	return c.JSON(form)
 }
 
@@ -88,9 +99,33 @@ func (h *FormHandler) UpdateFormHandler(c *fiber.Ctx) error {
 	}
 	form.ID = id
 
+	// Keep the ownership and creation metadata of the stored form
+	existing := middleware.FormFromContext(c)
+	if existing == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
+	}
+	if !utils.IfMatch(c, existing.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
+	}
+	form.UserID = existing.UserID
+	form.CreatedAt = existing.CreatedAt
+	form.Version = existing.Version + 1
+	form.DeletedAt, form.DeletedBy = nil, nil
+
 	if err := h.repo.UpdateForm(form); err != nil {
+		if errors.Is(err, utils.ErrVersionConflict) {
+			return c.Status(utils.ConflictStatus(c)).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, utils.ErrDuplicate) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	change := audit.Change{Action: entity.AuditUpdate, EntityType: entity.AuditForm, EntityID: form.ID, FormID: form.ID, Before: existing, After: form}
+	if err := h.audit.Record(c, change); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	utils.SetETag(c, form.Version)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Form updated"})
 }
 
@@ -101,20 +136,33 @@ func (h *FormHandler) DeleteFormHandler(c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID format"})
     }
 
-    // First, delete all fields associated with the form
-    if err := h.fieldRepo.DeleteFieldsByFormID(id); err != nil {
+    userID, err := utils.ExtractUserID(c)
+    if err != nil {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+    }
+
+    // Other forms may not lose the stocks they reference
+    if err := h.references.CheckFormDeletion(id); err != nil {
+        if errors.Is(err, reference.ErrReferenced) {
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
 
-    // Then, delete all stocks associated with the form
-    if err := h.stockRepo.DeleteStocksByFormID(id); err != nil {
+    // The form goes to the trash, its fields and stocks stay with it until it
+    // is restored or purged
+    if err := h.repo.TrashForm(id, userID, time.Now()); err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
 
-    // Finally, delete the form itself
-    if err := h.repo.DeleteForm(id); err != nil {
+    // One entry covers the form along with everything trashed with it
+    change := audit.Change{Action: entity.AuditDelete, EntityType: entity.AuditForm, EntityID: id, FormID: id, Before: middleware.FormFromContext(c)}
+    if err := h.audit.Record(c, change); err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
 
-    return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Form and all related data deleted"})
+    return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Form moved to the trash"})
 }
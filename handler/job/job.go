@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	repository "github.com/kbc0/DynamicStockManager/repository/job"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type JobHandler struct {
+	repo repository.JobStore
+}
+
+func NewJobHandler(repo repository.JobStore) *JobHandler {
+	return &JobHandler{repo: repo}
+}
+
+// GetJobs lists the background jobs of a form, newest first
+func (h *JobHandler) GetJobs(c *fiber.Ctx) error {
+	form := middleware.FormFromContext(c)
+	if form == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
+	}
+
+	limit, offset := utils.ParsePagination(c)
+	jobs, err := h.repo.GetJobsByFormID(form.ID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(jobs)
+}
+
+// GetJob reports the status and progress of a background job of a form
+func (h *JobHandler) GetJob(c *fiber.Ctx) error {
+	form := middleware.FormFromContext(c)
+	if form == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
+	}
+	jobID, err := uuid.Parse(c.Params("job_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid job ID format"})
+	}
+
+	job, err := h.repo.GetJobByID(jobID)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && job.FormID != form.ID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Job not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(job)
+}
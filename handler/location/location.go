@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	repository "github.com/kbc0/DynamicStockManager/repository/location"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	utils "github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type LocationHandler struct {
+	repo      repository.LocationStore
+	stockRepo stockRepo.StockStore
+}
+
+func NewLocationHandler(repo repository.LocationStore, stockRepo stockRepo.StockStore) *LocationHandler {
+	return &LocationHandler{
+		repo:      repo,
+		stockRepo: stockRepo,
+	}
+}
+
+// CreateLocation creates a warehouse, or a zone or bin nested in the location given as parentId
+func (h *LocationHandler) CreateLocation(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var location entity.Location
+	if err := c.BodyParser(&location); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	location.ID = uuid.New()
+	location.UserID = userID
+	location.Path = []uuid.UUID{}
+	location.CreatedAt = time.Now()
+
+	if location.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Location name is required"})
+	}
+
+	switch location.Kind {
+	case entity.Warehouse:
+		if location.ParentID != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A warehouse cannot have a parent location"})
+		}
+	case entity.Zone, entity.Bin:
+		if location.ParentID == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A " + string(location.Kind) + " needs a parent location"})
+		}
+		parent, err := h.repo.GetLocationByID(*location.ParentID)
+		if err != nil || parent.UserID != userID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Parent location not found"})
+		}
+		if parent.Kind != entity.LocationParentKinds[location.Kind] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A " + string(location.Kind) + " must be nested in a " + string(entity.LocationParentKinds[location.Kind])})
+		}
+		location.Path = append(parent.Path, parent.ID)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Location kind must be warehouse, zone or bin"})
+	}
+
+	if err := h.repo.CreateLocation(location); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(location)
+}
+
+// GetLocations lists the locations of the authenticated user
+func (h *LocationHandler) GetLocations(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	limit, offset := utils.ParsePagination(c)
+	locations, err := h.repo.GetLocationsByUserID(userID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if locations == nil {
+		locations = []entity.Location{}
+	}
+	return c.JSON(locations)
+}
+
+// GetLocation retrieves a location together with everything nested in it
+func (h *LocationHandler) GetLocation(c *fiber.Ctx) error {
+	location, status, err := h.ownedLocation(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	subtree, err := h.repo.GetLocationSubtree(location.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"location": location, "descendants": subtree[1:]})
+}
+
+// UpdateLocation renames a location, its place in the hierarchy cannot change
+func (h *LocationHandler) UpdateLocation(c *fiber.Ctx) error {
+	location, status, err := h.ownedLocation(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var updates struct {
+		Name string `json:"name"`
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&updates); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if updates.Name != "" {
+		location.Name = updates.Name
+	}
+	location.Code = updates.Code
+
+	if err := h.repo.UpdateLocation(*location); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Location updated"})
+}
+
+// DeleteLocation removes an empty location without nested locations
+func (h *LocationHandler) DeleteLocation(c *fiber.Ctx) error {
+	location, status, err := h.ownedLocation(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	subtree, err := h.repo.GetLocationSubtree(location.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(subtree) > 1 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Location still contains other locations"})
+	}
+
+	count, err := h.stockRepo.CountStocksAtLocation(location.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Location still holds stock"})
+	}
+
+	if err := h.repo.DeleteLocation(location.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Location deleted"})
+}
+
+// ownedLocation resolves the :location_id parameter and checks it belongs to the
+// authenticated user, returning the status code to answer with on failure
+func (h *LocationHandler) ownedLocation(c *fiber.Ctx) (*entity.Location, int, error) {
+	locationID, err := uuid.Parse(c.Params("location_id"))
+	if err != nil {
+		return nil, fiber.StatusBadRequest, errors.New("Invalid location ID format")
+	}
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return nil, fiber.StatusUnauthorized, errors.New("Unauthorized")
+	}
+
+	location, err := h.repo.GetLocationByID(locationID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fiber.StatusNotFound, errors.New("Location not found")
+		}
+		return nil, fiber.StatusInternalServerError, err
+	}
+	if location.UserID != userID {
+		return nil, fiber.StatusForbidden, errors.New("You do not have access to this location")
+	}
+	return location, fiber.StatusOK, nil
+}
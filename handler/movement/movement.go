@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	locationRepo "github.com/kbc0/DynamicStockManager/repository/location"
+	repository "github.com/kbc0/DynamicStockManager/repository/movement"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	utils "github.com/kbc0/DynamicStockManager/utils"
+)
+
+type MovementHandler struct {
+	repo         repository.MovementStore
+	locationRepo locationRepo.LocationStore
+	transactions transaction.Transactor
+}
+
+func NewMovementHandler(repo repository.MovementStore, locationRepo locationRepo.LocationStore, transactions transaction.Transactor) *MovementHandler {
+	return &MovementHandler{
+		repo:         repo,
+		locationRepo: locationRepo,
+		transactions: transactions,
+	}
+}
+
+// movementRequest is the body accepted when recording a movement. Receipts and
+// issues take a positive quantity, adjustments take a signed one.
+type movementRequest struct {
+	Type       entity.MovementType `json:"type"`
+	Quantity   int64               `json:"quantity"`
+	Reason     string              `json:"reason"`
+	Note       string              `json:"note"`
+	LocationID *uuid.UUID          `json:"locationId"`
+}
+
+// transferRequest is the body accepted when transferring stock between locations
+type transferRequest struct {
+	From     uuid.UUID `json:"from"`
+	To       uuid.UUID `json:"to"`
+	Quantity int64     `json:"quantity"`
+	Note     string    `json:"note"`
+}
+
+// RecordMovement adds a receipt, issue or adjustment to the ledger of a stock
+func (h *MovementHandler) RecordMovement(c *fiber.Ctx) error {
+	form := middleware.FormFromContext(c)
+	stock := middleware.StockFromContext(c)
+	if form == nil || stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var request movementRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	quantity, err := signedQuantity(request)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if request.LocationID != nil {
+		if err := h.checkLocation(*request.LocationID, form); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	movement := entity.Movement{
+		ID:         uuid.New(),
+		FormID:     form.ID,
+		StockID:    stock.ID,
+		Type:       request.Type,
+		Quantity:   quantity,
+		Reason:     request.Reason,
+		Note:       request.Note,
+		LocationID: request.LocationID,
+		UserID:     userID,
+		CreatedAt:  time.Now(),
+	}
+
+	// The quantities of the stock and its ledger change together
+	var recorded *entity.Movement
+	err = h.transactions.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+		var err error
+		recorded, err = h.repo.RecordMovement(ctx, movement, form.AllowNegativeStock)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientStock) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(recorded)
+}
+
+// TransferStock moves quantity of a stock from one location to another
+func (h *MovementHandler) TransferStock(c *fiber.Ctx) error {
+	form := middleware.FormFromContext(c)
+	stock := middleware.StockFromContext(c)
+	if form == nil || stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var request transferRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if request.Quantity <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "transfer quantity must be positive"})
+	}
+	if request.From == request.To {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "source and destination locations must differ"})
+	}
+	for _, locationID := range []uuid.UUID{request.From, request.To} {
+		if err := h.checkLocation(locationID, form); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	transferID := uuid.New()
+	now := time.Now()
+	out := entity.Movement{
+		ID:         uuid.New(),
+		FormID:     form.ID,
+		StockID:    stock.ID,
+		Type:       entity.Transfer,
+		Quantity:   -request.Quantity,
+		Reason:     "transfer_out",
+		Note:       request.Note,
+		LocationID: &request.From,
+		TransferID: &transferID,
+		UserID:     userID,
+		CreatedAt:  now,
+	}
+	in := out
+	in.ID = uuid.New()
+	in.Quantity = request.Quantity
+	in.Reason = "transfer_in"
+	in.LocationID = &request.To
+
+	var recorded []entity.Movement
+	err = h.transactions.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+		var err error
+		recorded, err = h.repo.RecordTransfer(ctx, out, in, form.AllowNegativeStock)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientStock) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(recorded)
+}
+
+// checkLocation makes sure a location exists and belongs to the owner of the form
+func (h *MovementHandler) checkLocation(locationID uuid.UUID, form *entity.Form) error {
+	location, err := h.locationRepo.GetLocationByID(locationID)
+	if err != nil || location.UserID != form.UserID {
+		return errors.New("location " + locationID.String() + " not found")
+	}
+	return nil
+}
+
+// GetMovements lists the ledger of a stock, newest first
+func (h *MovementHandler) GetMovements(c *fiber.Ctx) error {
+	stock := middleware.StockFromContext(c)
+	if stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	limit, offset := utils.ParsePagination(c)
+	movements, err := h.repo.GetMovementsByStockID(stock.ID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if movements == nil {
+		movements = []entity.Movement{}
+	}
+
+	return c.JSON(fiber.Map{"onHand": stock.OnHand, "movements": movements})
+}
+
+// signedQuantity validates the request and returns the quantity to store in the ledger
+func signedQuantity(request movementRequest) (int64, error) {
+	reasons, ok := entity.MovementReasons[request.Type]
+	if !ok {
+		return 0, errors.New("movement type must be receipt, issue or adjustment")
+	}
+	if !contains(reasons, request.Reason) {
+		return 0, errors.New("invalid reason code for " + string(request.Type))
+	}
+
+	switch request.Type {
+	case entity.Receipt:
+		if request.Quantity <= 0 {
+			return 0, errors.New("receipt quantity must be positive")
+		}
+		return request.Quantity, nil
+	case entity.Issue:
+		if request.Quantity <= 0 {
+			return 0, errors.New("issue quantity must be positive")
+		}
+		return -request.Quantity, nil
+	default:
+		if request.Quantity == 0 {
+			return 0, errors.New("adjustment quantity cannot be zero")
+		}
+		return request.Quantity, nil
+	}
+}
+
+// contains checks if a slice contains a specific string
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
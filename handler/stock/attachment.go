@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/blob"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	"github.com/kbc0/DynamicStockManager/schema"
+	utils "github.com/kbc0/DynamicStockManager/utils"
+)
+
+// sniffLength is how much of a file is read to detect its content type
+const sniffLength = 512
+
+// UploadAttachment attaches the file in the multipart field "file" to the
+// attachment field in :field_id of the stock. The content type is detected
+// from the content, falling back to the declared one for formats the detection
+// does not know, and checked against the field along with the size. The file
+// is stored in the blob store and described in the stock data, with its
+// SHA-256 checksum.
+func (h *StockHandler) UploadAttachment(c *fiber.Ctx) error {
+	stock := middleware.StockFromContext(c)
+	if stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+	field := middleware.FieldFromContext(c)
+	if field == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Field not found"})
+	}
+	if field.Type != entity.Attachment {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": field.Name + " is not an attachment field"})
+	}
+	if !utils.IfMatch(c, stock.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A file is required in the multipart field file"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The file could not be read"})
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The file could not be read"})
+	}
+	head = head[:n]
+
+	attachment := entity.StockAttachment{
+		ID:          uuid.NewString(),
+		Name:        attachmentName(fileHeader.Filename),
+		ContentType: detectContentType(head, fileHeader.Header.Get("Content-Type")),
+		Size:        fileHeader.Size,
+		UploadedAt:  time.Now().UTC(),
+	}
+	attached, _ := schema.Attachments(stock.Data[field.Name])
+	if err := schema.CheckAttachment(*field, attached, attachment); err != nil {
+		status := fiber.StatusBadRequest
+		if field.MaxSize > 0 && attachment.Size > field.MaxSize {
+			status = fiber.StatusRequestEntityTooLarge
+		}
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	key := blob.Key(stock.FormID, stock.ID, attachment.ID)
+	checksum := sha256.New()
+	content := io.TeeReader(io.MultiReader(bytes.NewReader(head), file), checksum)
+	if err := h.blobs.Put(c.UserContext(), key, content, attachment.Size, attachment.ContentType); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	attachment.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+	data := copyData(stock.Data)
+	data[field.Name] = append(append([]entity.StockAttachment{}, attached...), attachment)
+	updated, err := h.saveStockData(c, *stock, data, 0)
+	if err != nil {
+		// The file is not described anywhere, so nothing else refers to it
+		h.blobs.Delete(context.Background(), key)
+		return c.Status(saveErrorStatus(c, err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	utils.SetETag(c, updated.Version)
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+// DownloadAttachment sends the file of an attachment of the stock, with the
+// checksum of its content as the ETag
+func (h *StockHandler) DownloadAttachment(c *fiber.Ctx) error {
+	stock, _, attachment, failure := h.attachmentParam(c)
+	if failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
+
+	content, err := h.blobs.Get(c.UserContext(), blob.Key(stock.FormID, stock.ID, attachment.ID))
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "The file of the attachment is missing"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	c.Set(fiber.HeaderETag, strconv.Quote(attachment.Checksum))
+	return c.SendStream(content, int(attachment.Size))
+}
+
+// DeleteAttachment removes an attachment from the stock data and deletes its
+// file
+func (h *StockHandler) DeleteAttachment(c *fiber.Ctx) error {
+	stock, field, attachment, failure := h.attachmentParam(c)
+	if failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
+	if !utils.IfMatch(c, stock.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
+	}
+
+	attached, _ := schema.Attachments(stock.Data[field.Name])
+	kept := []entity.StockAttachment{}
+	for _, other := range attached {
+		if other.ID != attachment.ID {
+			kept = append(kept, other)
+		}
+	}
+	data := copyData(stock.Data)
+	data[field.Name] = kept
+	updated, err := h.saveStockData(c, *stock, data, 0)
+	if err != nil {
+		return c.Status(saveErrorStatus(c, err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// A file left behind is still deleted along with the stock when it is purged
+	if err := h.blobs.Delete(c.UserContext(), blob.Key(stock.FormID, stock.ID, attachment.ID)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	utils.SetETag(c, updated.Version)
+	return c.JSON(fiber.Map{"message": "Attachment deleted"})
+}
+
+// attachmentParam finds the attachment in :attachment_id among the attachment
+// fields of the stock resolved by the access middleware, failing with the
+// status code to answer with
+func (h *StockHandler) attachmentParam(c *fiber.Ctx) (*entity.Stock, *entity.Field, *entity.StockAttachment, *fiber.Error) {
+	stock := middleware.StockFromContext(c)
+	if stock == nil {
+		return nil, nil, nil, fiber.NewError(fiber.StatusNotFound, "Stock not found")
+	}
+	id, err := uuid.Parse(c.Params("attachment_id"))
+	if err != nil {
+		return nil, nil, nil, fiber.NewError(fiber.StatusBadRequest, "Invalid attachment ID format")
+	}
+
+	fields, err := h.fieldRepo.GetFieldsByFormID(stock.FormID)
+	if err != nil {
+		return nil, nil, nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	for i := range fields {
+		if fields[i].Type != entity.Attachment {
+			continue
+		}
+		attached, _ := schema.Attachments(stock.Data[fields[i].Name])
+		for j := range attached {
+			if attached[j].ID == id.String() {
+				return stock, &fields[i], &attached[j], nil
+			}
+		}
+	}
+	return nil, nil, nil, fiber.NewError(fiber.StatusNotFound, "Attachment not found")
+}
+
+// dropReadOnly removes the values of attachment and formula fields from
+// submitted data: attachments only change through the attachment endpoints
+// and formulas are computed
+func dropReadOnly(fields []entity.Field, data map[string]interface{}) {
+	for _, field := range fields {
+		if field.Type == entity.Attachment || field.Type == entity.Formula {
+			delete(data, field.Name)
+		}
+	}
+}
+
+// keepAttachments copies the values of attachment fields from the stored data
+// of a stock into its new data
+func keepAttachments(fields []entity.Field, data map[string]interface{}, stored map[string]interface{}) {
+	for _, field := range fields {
+		if value, ok := stored[field.Name]; ok && field.Type == entity.Attachment {
+			data[field.Name] = value
+		}
+	}
+}
+
+// attachmentName keeps the base name of an uploaded file, which some clients
+// send with its path
+func attachmentName(filename string) string {
+	name := filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	return name
+}
+
+// detectContentType detects the content type of a file from its first bytes.
+// The declared type is used when the content does not tell, and for text when
+// it declares a more specific kind of text such as text/csv.
+func detectContentType(head []byte, declared string) string {
+	detected := http.DetectContentType(head)
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return detected
+	}
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") && strings.HasPrefix(mediaType, "text/") {
+		return mime.FormatMediaType(mediaType, params)
+	}
+	return detected
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
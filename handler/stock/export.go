@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/export"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	"github.com/kbc0/DynamicStockManager/query"
+)
+
+// ExportStocks downloads the stocks of a form as a spreadsheet, with one column
+// per field in field order. ?format= is csv (the default) or xlsx, hidden
+// fields are only exported with ?includeHidden=true, and ?filter= and ?sort=
+// work as in GetAllStocks. Rows are streamed from storage while the response
+// is written, so exports of any size take constant memory.
+func (h *StockHandler) ExportStocks(c *fiber.Ctx) error {
+	formID, err := uuid.Parse(c.Params("_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid form ID format"})
+	}
+
+	format := strings.ToLower(c.Query("format", export.FormatCSV))
+	contentType := export.ContentType(format)
+	if contentType == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be csv or xlsx"})
+	}
+
+	fields, err := h.fieldRepo.GetFieldsByFormID(formID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var q query.StockQuery
+	if q.Filter, err = query.ParseFilter(c.Query("filter"), fields); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid filter: " + err.Error()})
+	}
+	if q.Sort, err = query.ParseSort(c.Query("sort"), fields); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid sort: " + err.Error()})
+	}
+	columns := export.Columns(fields, c.QueryBool("includeHidden"))
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Attachment(exportFileName(middleware.FormFromContext(c), formID) + "." + format)
+
+	// The status line is sent before the first row, so a failure halfway can
+	// only end the file in a way that shows it is incomplete
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := h.writeExport(w, format, formID, q, columns); err != nil {
+			h.logger.Error().Err(err).Str("form", formID.String()).Str("format", format).Msg("export failed")
+		}
+	})
+	return nil
+}
+
+// writeExport writes the header and the rows of an export, aborting the file
+// when an error cuts it short
+func (h *StockHandler) writeExport(w io.Writer, format string, formID uuid.UUID, q query.StockQuery, columns []entity.Field) error {
+	rows, err := export.NewRowWriter(format, w)
+	if err != nil {
+		return err
+	}
+	err = rows.WriteRow(export.Header(columns))
+	if err == nil {
+		err = h.repo.EachStock(formID, q, func(stock entity.Stock) error {
+			return rows.WriteRow(export.Row(stock, columns))
+		})
+	}
+	if err != nil {
+		rows.Abort()
+		return err
+	}
+	return rows.Close()
+}
+
+// exportFileName names the export after the form, keeping only characters
+// that are safe in a file name
+func exportFileName(form *entity.Form, formID uuid.UUID) string {
+	if form != nil {
+		name := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+				return r
+			case r == ' ' || r == '.':
+				return '_'
+			}
+			return -1
+		}, form.Name)
+		if name != "" {
+			return name
+		}
+	}
+	return "stocks-" + formID.String()
+}
@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/schema"
+	utils "github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ImportRowError describes why a row of an imported CSV file was rejected
+type ImportRowError struct {
+	Row    int               `json:"row"` // Line number in the file, the header being line 1
+	Column string            `json:"column,omitempty"`
+	Rule   entity.TextFormat `json:"rule,omitempty"` // Format the value of a text field failed
+	Error  string            `json:"error"`
+}
+
+// ImportReport summarises a CSV import
+type ImportReport struct {
+	DryRun   bool             `json:"dryRun"`
+	Total    int              `json:"total"`    // Number of data rows in the file
+	Valid    int              `json:"valid"`    // Rows that passed validation
+	Imported int              `json:"imported"` // Rows stored as stocks, always 0 for a dry run
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+// ImportStocks creates a stock for every row of the CSV file uploaded in the
+// multipart field "file". Column headers are matched to the field names of the
+// form, and each row goes through the same validation, default values and
+// uniqueness checks as AddStock. Invalid rows are skipped and reported, and
+// with ?dryRun=true nothing is stored at all.
+func (h *StockHandler) ImportStocks(c *fiber.Ctx) error {
+	formID, err := uuid.Parse(c.Params("_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid form ID format"})
+	}
+	dryRun := c.QueryBool("dryRun") || c.FormValue("dryRun") == "true"
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A CSV file is required in the file field"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read the uploaded file"})
+	}
+	defer file.Close()
+
+	fields, err := h.fieldRepo.GetFieldsByFormID(formID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Rows with a wrong number of cells are reported per row
+	header, err := reader.Read()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The CSV file must start with a header row"})
+	}
+	columns, err := mapColumns(header, fields)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report := ImportReport{DryRun: dryRun, Errors: []ImportRowError{}}
+	// Unique values seen in earlier rows, the stored stocks are checked by prepareStockData
+	seen := make(map[string]map[interface{}]int)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The positions of the reader only describe records read successfully
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "report": report})
+			}
+			report.Total++
+			report.reject(ImportRowError{Row: parseErr.StartLine, Error: err.Error()})
+			continue
+		}
+		row, _ := reader.FieldPos(0)
+		report.Total++
+
+		data, rowErr := parseRecord(record, columns)
+		if rowErr != nil {
+			rowErr.Row = row
+			report.reject(*rowErr)
+			continue
+		}
+
+		if err := h.prepareStockData(fields, data, uuid.Nil); err != nil {
+			var invalid *dataError
+			if !errors.As(err, &invalid) {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "report": report})
+			}
+			report.reject(ImportRowError{Row: row, Column: invalid.field, Rule: invalid.rule, Error: err.Error()})
+			continue
+		}
+		if duplicate := checkImportDuplicates(seen, fields, data, row); duplicate != nil {
+			report.reject(*duplicate)
+			continue
+		}
+
+		report.Valid++
+		if dryRun {
+			continue
+		}
+
+		stock := entity.Stock{
+			ID:        uuid.New(),
+			FormID:    formID,
+			Data:      data,
+			Revision:  1,
+			Version:   1,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if err := h.repo.CreateStock(stock); err != nil {
+			if errors.Is(err, utils.ErrDuplicate) {
+				// Another request took a unique value since the row was checked
+				report.Valid--
+				report.reject(ImportRowError{Row: row, Error: err.Error()})
+				continue
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "report": report})
+		}
+		if err := h.recordRevision(c, stock, 0); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "report": report})
+		}
+		if err := h.audit.Record(c, stockChange(entity.AuditCreate, nil, &stock)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "report": report})
+		}
+		report.Imported++
+	}
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+func (r *ImportReport) reject(rowErr ImportRowError) {
+	r.Failed++
+	r.Errors = append(r.Errors, rowErr)
+}
+
+// mapColumns matches every header to a field of the form, first by exact name
+// and then ignoring case and surrounding spaces
+func mapColumns(header []string, fields []entity.Field) ([]entity.Field, error) {
+	columns := make([]entity.Field, len(header))
+	used := make(map[string]bool)
+	var unknown []string
+
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // Spreadsheet exports often start with a byte order mark
+		}
+		field, ok := findField(fields, name)
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if used[field.Name] {
+			return nil, errors.New("Column " + field.Name + " appears more than once")
+		}
+		used[field.Name] = true
+		columns[i] = field
+	}
+
+	if len(unknown) > 0 {
+		return nil, errors.New("Unknown columns: " + strings.Join(unknown, ", "))
+	}
+	return columns, nil
+}
+
+func findField(fields []entity.Field, name string) (entity.Field, bool) {
+	for _, field := range fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.Name, strings.TrimSpace(name)) {
+			return field, true
+		}
+	}
+	return entity.Field{}, false
+}
+
+// parseRecord converts the cells of a row into stock data. Empty cells are left
+// out so the default value of the field applies.
+func parseRecord(record []string, columns []entity.Field) (map[string]interface{}, *ImportRowError) {
+	if len(record) != len(columns) {
+		return nil, &ImportRowError{Error: "expected " + strconv.Itoa(len(columns)) + " cells, got " + strconv.Itoa(len(record))}
+	}
+
+	data := make(map[string]interface{})
+	for i, cell := range record {
+		// Files are uploaded one by one and formulas are computed, the names
+		// exported for attachment fields and results of formulas are ignored
+		if strings.TrimSpace(cell) == "" || columns[i].Type == entity.Attachment || columns[i].Type == entity.Formula {
+			continue
+		}
+		value, err := parseCell(cell, columns[i])
+		if err != nil {
+			return nil, &ImportRowError{Column: columns[i].Name, Error: err.Error()}
+		}
+		data[columns[i].Name] = value
+	}
+	return data, nil
+}
+
+// parseCell converts a CSV cell into the value a JSON request would carry for the field
+func parseCell(cell string, field entity.Field) (interface{}, error) {
+	switch field.Type {
+	case entity.NumberDecimal:
+		value, err := decimal.Parse(cell)
+		if err != nil {
+			return nil, errors.New("invalid number " + strconv.Quote(cell))
+		}
+		return value, nil
+	case entity.Number:
+		value, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+		if err != nil {
+			return nil, errors.New("invalid number " + strconv.Quote(cell))
+		}
+		return value, nil
+	case entity.Checkbox:
+		switch strings.ToLower(strings.TrimSpace(cell)) {
+		case "true", "yes", "1":
+			return true, nil
+		case "false", "no", "0":
+			return false, nil
+		}
+		return nil, errors.New("invalid boolean " + strconv.Quote(cell))
+	case entity.Multiselect, entity.Reference:
+		return schema.SplitSelection(cell), nil
+	default:
+		return cell, nil
+	}
+}
+
+// checkImportDuplicates rejects unique values already used by an earlier row of
+// the same file, and remembers the values of this row otherwise
+func checkImportDuplicates(seen map[string]map[interface{}]int, fields []entity.Field, data map[string]interface{}, row int) *ImportRowError {
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if !field.IsUnique || !ok {
+			continue
+		}
+		for _, key := range uniqueKeys(value) {
+			if earlier, exists := seen[field.Name][key]; exists {
+				return &ImportRowError{Row: row, Column: field.Name, Error: "Value for " + field.Name + " must be unique, already used in row " + strconv.Itoa(earlier)}
+			}
+		}
+	}
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if !field.IsUnique || !ok {
+			continue
+		}
+		if seen[field.Name] == nil {
+			seen[field.Name] = make(map[interface{}]int)
+		}
+		for _, key := range uniqueKeys(value) {
+			seen[field.Name][key] = row
+		}
+	}
+	return nil
+}
+
+// uniqueKeys returns what a unique value takes: every option of a multiselect,
+// which no other stock can select, or else the value itself
+func uniqueKeys(value interface{}) []interface{} {
+	if d, ok := value.(primitive.Decimal128); ok {
+		// Equal decimals can differ in their trailing zeros
+		number, _ := decimal.Rat(d)
+		return []interface{}{number.RatString()}
+	}
+	selection, ok := schema.Selection(value)
+	if !ok {
+		return []interface{}{value}
+	}
+	keys := make([]interface{}, len(selection))
+	for i, option := range selection {
+		keys[i] = option
+	}
+	return keys
+}
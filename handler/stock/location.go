@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+)
+
+// LocationQuantity is the stock held at a location of the hierarchy
+type LocationQuantity struct {
+	Location    entity.Location `json:"location"`
+	OnHand      int64           `json:"onHand"`      // Quantity kept directly at the location
+	TotalOnHand int64           `json:"totalOnHand"` // Quantity kept at the location and everything nested in it
+	StockCount  int             `json:"stockCount"`  // Number of stocks with a quantity directly at the location
+}
+
+// aggregateByLocation sums the location quantities of the stocks for each of
+// the locations, rolling nested quantities up to their ancestors. The result is
+// ordered so every location directly follows its ancestors.
+func aggregateByLocation(stocks []entity.Stock, locations []entity.Location) []LocationQuantity {
+	byID := make(map[string]*LocationQuantity, len(locations))
+	result := make([]LocationQuantity, len(locations))
+	for i, location := range locations {
+		result[i] = LocationQuantity{Location: location}
+	}
+	for i := range result {
+		byID[result[i].Location.ID.String()] = &result[i]
+	}
+
+	for _, stock := range stocks {
+		for locationID, quantity := range stock.Locations {
+			entry, ok := byID[locationID]
+			if !ok || quantity == 0 {
+				continue
+			}
+			entry.OnHand += quantity
+			entry.StockCount++
+			entry.TotalOnHand += quantity
+			for _, ancestorID := range entry.Location.Path {
+				if ancestor, ok := byID[ancestorID.String()]; ok {
+					ancestor.TotalOnHand += quantity
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return hierarchyKey(result[i].Location) < hierarchyKey(result[j].Location)
+	})
+	return result
+}
+
+// hierarchyKey sorts locations depth first along their ancestor path
+func hierarchyKey(location entity.Location) string {
+	key := ""
+	for _, ancestorID := range location.Path {
+		key += ancestorID.String() + "/"
+	}
+	return key + location.ID.String()
+}
+
+func locationIDs(locations []entity.Location) []uuid.UUID {
+	ids := make([]uuid.UUID, len(locations))
+	for i, location := range locations {
+		ids[i] = location.ID
+	}
+	return ids
+}
+
+// ownsLocation reports whether the location belongs to the owner of the form of the request
+func ownsLocation(c *fiber.Ctx, location *entity.Location) bool {
+	form := middleware.FormFromContext(c)
+	return form != nil && location.UserID == form.UserID
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	revisionRepo "github.com/kbc0/DynamicStockManager/repository/revision"
+	utils "github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordRevision stores the data of the stock as its current revision
+func (h *StockHandler) recordRevision(c *fiber.Ctx, stock entity.Stock, restoredFrom int) error {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return err
+	}
+	return h.revisionRepo.CreateRevision(entity.StockRevision{
+		ID:           uuid.New(),
+		StockID:      stock.ID,
+		FormID:       stock.FormID,
+		Revision:     stock.Revision,
+		Data:         stock.Data,
+		UserID:       userID,
+		RestoredFrom: restoredFrom,
+		CreatedAt:    stock.UpdatedAt,
+	})
+}
+
+// saveStockData replaces the data of a stored stock with already validated data
+// and records it as the next revision. Stocks created before revisions were
+// kept get their current data recorded as revision 1 first.
+func (h *StockHandler) saveStockData(c *fiber.Ctx, existing entity.Stock, data map[string]interface{}, restoredFrom int) (*entity.Stock, error) {
+	if existing.Revision == 0 {
+		existing.Revision = 1
+		if err := h.recordRevision(c, existing, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	stock := existing
+	stock.Data = data
+	stock.Revision = existing.Revision + 1
+	stock.Version = existing.Version + 1
+	stock.UpdatedAt = time.Now()
+
+	if err := h.repo.UpdateStock(c.UserContext(), stock); err != nil {
+		return nil, err
+	}
+	if err := h.recordRevision(c, stock, restoredFrom); err != nil {
+		return nil, err
+	}
+	if err := h.audit.Record(c, stockChange(entity.AuditUpdate, &existing, &stock)); err != nil {
+		return nil, err
+	}
+	return &stock, nil
+}
+
+// saveErrorStatus returns the status code to answer a saveStockData error with
+func saveErrorStatus(c *fiber.Ctx, err error) int {
+	if errors.Is(err, utils.ErrVersionConflict) {
+		return utils.ConflictStatus(c)
+	}
+	if errors.Is(err, utils.ErrDuplicate) {
+		return fiber.StatusConflict
+	}
+	if errors.Is(err, revisionRepo.ErrRevisionExists) {
+		// Another update of the stock took the revision number first
+		return fiber.StatusConflict
+	}
+	return fiber.StatusInternalServerError
+}
+
+// GetRevisions lists the revisions of a stock, newest first
+func (h *StockHandler) GetRevisions(c *fiber.Ctx) error {
+	stock := middleware.StockFromContext(c)
+	if stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	limit, offset := utils.ParsePagination(c)
+	revisions, err := h.revisionRepo.GetRevisionsByStockID(stock.ID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(revisions)
+}
+
+// GetRevision returns one revision of a stock
+func (h *StockHandler) GetRevision(c *fiber.Ctx) error {
+	revision, paramErr := h.revisionParam(c, c.Params("revision"))
+	if paramErr != nil {
+		return c.Status(paramErr.Code).JSON(fiber.Map{"error": paramErr.Message})
+	}
+	return c.JSON(revision)
+}
+
+// DiffRevisions compares the data of the revisions in ?from= and ?to= field by
+// field. ?to= defaults to the current revision.
+func (h *StockHandler) DiffRevisions(c *fiber.Ctx) error {
+	stock := middleware.StockFromContext(c)
+	if stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	from, paramErr := h.revisionParam(c, c.Query("from"))
+	if paramErr != nil {
+		return c.Status(paramErr.Code).JSON(fiber.Map{"error": paramErr.Message})
+	}
+	to, paramErr := h.revisionParam(c, c.Query("to", strconv.Itoa(stock.Revision)))
+	if paramErr != nil {
+		return c.Status(paramErr.Code).JSON(fiber.Map{"error": paramErr.Message})
+	}
+
+	return c.JSON(fiber.Map{
+		"from":    from.Revision,
+		"to":      to.Revision,
+		"changes": diffData(from.Data, to.Data),
+	})
+}
+
+// RestoreRevision replaces the data of a stock with the data of an earlier
+// revision, which must still be valid for the current fields of the form. The
+// restored data is recorded as a new revision. The files of attachment fields
+// stay as they are, since the ones removed since are gone.
+func (h *StockHandler) RestoreRevision(c *fiber.Ctx) error {
+	stock := middleware.StockFromContext(c)
+	if stock == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+	if !utils.IfMatch(c, stock.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
+	}
+	revision, paramErr := h.revisionParam(c, c.Params("revision"))
+	if paramErr != nil {
+		return c.Status(paramErr.Code).JSON(fiber.Map{"error": paramErr.Message})
+	}
+
+	fields, err := h.fieldRepo.GetFieldsByFormID(stock.FormID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	data := revision.Data
+	dropReadOnly(fields, data)
+	if err := h.prepareStockData(fields, data, stock.ID); err != nil {
+		status := dataErrorStatus(err)
+		if status != fiber.StatusInternalServerError {
+			body := dataErrorBody(err)
+			body["error"] = "Revision " + strconv.Itoa(revision.Revision) + " is no longer valid: " + err.Error()
+			return c.Status(status).JSON(body)
+		}
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	keepAttachments(fields, data, stock.Data)
+
+	restored, err := h.saveStockData(c, *stock, data, revision.Revision)
+	if err != nil {
+		return c.Status(saveErrorStatus(c, err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	utils.SetETag(c, restored.Version)
+	return c.JSON(restored)
+}
+
+// revisionParam loads a revision of the stock resolved by the access
+// middleware, failing with the status code to answer with
+func (h *StockHandler) revisionParam(c *fiber.Ctx, param string) (*entity.StockRevision, *fiber.Error) {
+	stock := middleware.StockFromContext(c)
+	number, err := strconv.Atoi(param)
+	if err != nil || number < 1 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid revision number")
+	}
+	if stock == nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Stock not found")
+	}
+
+	revision, err := h.revisionRepo.GetRevision(stock.ID, number)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Revision not found")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return revision, nil
+}
+
+// diffData lists the fields that differ between two versions of stock data,
+// in field name order
+func diffData(from, to map[string]interface{}) []entity.RevisionChange {
+	changes := []entity.RevisionChange{}
+	for name, before := range from {
+		after, ok := to[name]
+		switch {
+		case !ok:
+			changes = append(changes, entity.RevisionChange{Field: name, Change: "removed", From: before})
+		case !sameValue(before, after):
+			changes = append(changes, entity.RevisionChange{Field: name, Change: "changed", From: before, To: after})
+		}
+	}
+	for name, after := range to {
+		if _, ok := from[name]; !ok {
+			changes = append(changes, entity.RevisionChange{Field: name, Change: "added", To: after})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// sameValue compares two stored values, treating all numeric types alike
+func sameValue(a, b interface{}) bool {
+	_, aNumber := decimal.Rat(a)
+	_, bNumber := decimal.Rat(b)
+	if aNumber || bNumber {
+		order, ok := decimal.Compare(a, b)
+		return ok && order == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -1,25 +1,53 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/audit"
+	"github.com/kbc0/DynamicStockManager/blob"
 	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/formula"
+	"github.com/kbc0/DynamicStockManager/middleware"
+	"github.com/kbc0/DynamicStockManager/query"
+	"github.com/kbc0/DynamicStockManager/reference"
 	"github.com/kbc0/DynamicStockManager/repository/stock"
+	"github.com/kbc0/DynamicStockManager/schema"
 	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	locationRepo "github.com/kbc0/DynamicStockManager/repository/location"
+	revisionRepo "github.com/kbc0/DynamicStockManager/repository/revision"
+	utils "github.com/kbc0/DynamicStockManager/utils"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type StockHandler struct {
-	repo *repository.StockRepository
-	fieldRepo *fieldRepo.FieldRepository
+	repo repository.StockStore
+	fieldRepo fieldRepo.FieldStore
+	locationRepo locationRepo.LocationStore
+	revisionRepo revisionRepo.RevisionStore
+	references *reference.Resolver
+	blobs blob.Store
+	audit *audit.Recorder
+	logger *zerolog.Logger // Reports the errors of exports, which happen after the response has started
 }
 
-func NewStockHandler(repo *repository.StockRepository, fieldRepo *fieldRepo.FieldRepository) *StockHandler {
+func NewStockHandler(repo repository.StockStore, fieldRepo fieldRepo.FieldStore, locationRepo locationRepo.LocationStore, revisionRepo revisionRepo.RevisionStore, references *reference.Resolver, blobs blob.Store, audit *audit.Recorder, logger *zerolog.Logger) *StockHandler {
 	return &StockHandler{
 		repo: repo,
 		fieldRepo: fieldRepo,
+		locationRepo: locationRepo,
+		revisionRepo: revisionRepo,
+		references: references,
+		blobs: blobs,
+		audit: audit,
+		logger: logger,
 	}
 }
 
@@ -30,7 +58,7 @@ func (h *StockHandler) AddStock(c *fiber.Ctx) error {
     }
 
     var data map[string]interface{}
-    if err := c.BodyParser(&data); err != nil {
+    if err := parseData(c, &data); err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
     }
 
@@ -40,138 +68,204 @@ func (h *StockHandler) AddStock(c *fiber.Ctx) error {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
 
-    fieldMap := make(map[string]entity.Field)
-    uniqueFields := make(map[string]interface{}) // Map to store the values of unique fields
-
-    for _, field := range fields {
-        fieldMap[field.Name] = field
-        if field.IsUnique {
-            uniqueFields[field.Name] = nil
-        }
-    }
-
-    // Validate and prepare data
-    for key, value := range data {
-        field, exists := fieldMap[key]
-        if !exists {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid field provided: " + key})
-        }
-
-        if err := validateFieldData(value, field); err != nil {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-        }
-
-        if field.IsUnique {
-            // Check if the value already exists in other stocks
-            exists, err := h.repo.CheckUniqueField(field.FormID, key, value)
-            if err != nil {
-                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
-            }
-            if exists {
-                return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Value for " + key + " must be unique"})
-            }
-            uniqueFields[key] = value
-        }
-    }
-
-    // Use default values for missing fields
-    for fieldName, field := range fieldMap {
-        if _, ok := data[fieldName]; !ok && !field.IsHidden {
-            if field.DefaultValue != nil {
-                data[fieldName] = field.DefaultValue
-            } else {
-                return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing required field: " + fieldName})
-            }
-        }
+    if err := h.prepareStockData(fields, data, uuid.Nil); err != nil {
+        return c.Status(dataErrorStatus(err)).JSON(dataErrorBody(err))
     }
 
     stock := entity.Stock{
         ID:        uuid.New(),
         FormID:    formID,
         Data:      data,
+        Revision:  1,
+        Version:   1,
         CreatedAt: time.Now(),
         UpdatedAt: time.Now(),
     }
 
     if err := h.repo.CreateStock(stock); err != nil {
+        return c.Status(dataErrorStatus(err)).JSON(dataErrorBody(err))
+    }
+    if err := h.recordRevision(c, stock, 0); err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    if err := h.audit.Record(c, stockChange(entity.AuditCreate, nil, &stock)); err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
 
     return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Stock added"})
 }
 
-// validateFieldData checks if the given data is valid for the field type
-func validateFieldData(value interface{}, field entity.Field) error {
-    switch field.Type {
-    case entity.Combobox:
-        valStr, ok := value.(string)
-        if !ok {
-            return errors.New("invalid data type for combobox, expected string")
-        }
-        if !contains(field.Options, valStr) {
-            return errors.New("value not in combobox options")
+// stockChange describes a change to a stock for the audit log, before is nil
+// for created and after for deleted stocks
+func stockChange(action entity.AuditAction, before *entity.Stock, after *entity.Stock) audit.Change {
+    change := audit.Change{Action: action, EntityType: entity.AuditStock}
+    for _, stock := range []*entity.Stock{after, before} {
+        if stock != nil {
+            change.EntityID = stock.ID
+            change.FormID = stock.FormID
         }
+    }
+    if before != nil {
+        change.Before = before
+    }
+    if after != nil {
+        change.After = after
+    }
+    return change
+}
 
-    case entity.Text:
-        valStr, ok := value.(string)
-        if !ok {
-            return errors.New("invalid data type for text, expected string")
-        }
-        if field.MinValue != nil && len(valStr) < *field.MinValue {
-            return errors.New("text length below minimum limit")
-        }
-        if field.MaxValue != nil && *field.MaxValue != -1 && len(valStr) > *field.MaxValue {
-            return errors.New("text length exceeds maximum limit")
-        }
+// dataError reports a problem with submitted stock data, as opposed to a storage failure
+type dataError struct {
+    field     string            // Name of the offending field, when known
+    rule      entity.TextFormat // Format the value of a text field failed, if any
+    message   string
+    duplicate bool // The value of a unique field is already taken
+}
 
-    case entity.Checkbox:
-        if _, ok := value.(bool); !ok {
-            return errors.New("invalid data type for checkbox, expected boolean")
-        }
+func (e *dataError) Error() string {
+    return e.message
+}
 
-    case entity.Number:
-        valFloat, ok := value.(float64) // JSON numbers are decoded as float64 by default
-        if !ok {
-            return errors.New("invalid data type for number, expected integer")
+func (e *dataError) Unwrap() error {
+    if e.duplicate {
+        return utils.ErrDuplicate
+    }
+    return nil
+}
+
+// dataErrorStatus returns the status code to answer a prepareStockData error
+// with: 409 for values of unique fields already taken, 400 for other problems
+// with the data and 500 for storage failures
+func dataErrorStatus(err error) int {
+    var invalid *dataError
+    switch {
+    case errors.Is(err, utils.ErrDuplicate):
+        return fiber.StatusConflict
+    case errors.As(err, &invalid):
+        return fiber.StatusBadRequest
+    }
+    return fiber.StatusInternalServerError
+}
+
+// dataErrorBody returns the body to answer a prepareStockData error with,
+// naming the offending field and the format it failed when known
+func dataErrorBody(err error) fiber.Map {
+    body := fiber.Map{"error": err.Error()}
+    var invalid *dataError
+    if errors.As(err, &invalid) {
+        if invalid.field != "" {
+            body["field"] = invalid.field
         }
-        valInt := int(valFloat) // Convert float64 to int; ensure it is a natural number
-        if float64(valInt) != valFloat {
-            return errors.New("invalid number value, expected integer without fractional part")
+        if invalid.rule != "" {
+            body["rule"] = invalid.rule
         }
-        if field.MinValue != nil && valInt < *field.MinValue {
-            return errors.New("number below minimum limit")
+    }
+    return body
+}
+
+// prepareStockData validates the data against the fields of the form, checks
+// unique fields against the stored stocks other than stockID, fills in
+// default values for missing fields and computes the formula fields. Problems
+// with the data itself are returned as *dataError.
+func (h *StockHandler) prepareStockData(fields []entity.Field, data map[string]interface{}, stockID uuid.UUID) error {
+    fieldMap := fieldsByName(fields)
+
+    // Validate and prepare data
+    for key, value := range data {
+        parsed, err := h.checkStockValue(fieldMap, key, value, stockID)
+        if err != nil {
+            return err
         }
-        if field.MaxValue != nil && *field.MaxValue != -1 && valInt > *field.MaxValue {
-            return errors.New("number exceeds maximum limit")
+        data[key] = parsed
+    }
+
+    if err := fillDefaults(fieldMap, data); err != nil {
+        return err
+    }
+    return formula.Compute(fields, data)
+}
+
+// parseData decodes the stock data in the body of a request, keeping numbers as
+// json.Number so that numberDecimal values keep every digit
+func parseData(c *fiber.Ctx, data *map[string]interface{}) error {
+    decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+    decoder.UseNumber()
+    return decoder.Decode(data)
+}
+
+func fieldsByName(fields []entity.Field) map[string]entity.Field {
+    fieldMap := make(map[string]entity.Field)
+    for _, field := range fields {
+        fieldMap[field.Name] = field
+    }
+    return fieldMap
+}
+
+// checkStockValue validates one value of stock data against its field, the
+// referenced stocks of reference fields and, for unique fields, the stored
+// stocks other than stockID. It returns
+// the value to store, such as a date for an ISO-8601 string.
+func (h *StockHandler) checkStockValue(fieldMap map[string]entity.Field, key string, value interface{}, stockID uuid.UUID) (interface{}, error) {
+    field, exists := fieldMap[key]
+    if !exists {
+        return nil, &dataError{field: key, message: "Invalid field provided: " + key}
+    }
+
+    value, err := schema.ParseValue(value, field)
+    if err != nil {
+        invalid := &dataError{field: key, message: err.Error()}
+        var format *schema.FormatError
+        if errors.As(err, &format) {
+            invalid.rule = format.Rule
         }
+        return nil, invalid
+    }
 
-    case entity.NumberDecimal:
-        valFloat, ok := value.(float64)
-        if !ok {
-            return errors.New("invalid data type for numberDecimal, expected decimal number")
+    if field.Type == entity.Reference {
+        if err := h.references.Check(field, value); err != nil {
+            if errors.Is(err, reference.ErrInvalid) {
+                return nil, &dataError{field: key, message: err.Error()}
+            }
+            return nil, err
         }
-        if field.MinValue != nil && valFloat < float64(*field.MinValue) {
-            return errors.New("decimal number below minimum limit")
+    }
+
+    if field.IsUnique {
+        // Check if the value already exists in other stocks
+        exists, err := h.repo.CheckUniqueField(field.FormID, key, value, stockID)
+        if err != nil {
+            return nil, err
         }
-        if field.MaxValue != nil && *field.MaxValue != -1 && valFloat > float64(*field.MaxValue) {
-            return errors.New("decimal number exceeds maximum limit")
+        if exists {
+            return nil, &dataError{field: key, message: "Value for " + key + " must be unique", duplicate: true}
         }
-
-    default:
-        return errors.New("unknown field type")
     }
-    return nil
+    return value, nil
 }
 
-
-// contains checks if a slice contains a specific string
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
+// fillDefaults uses default values for missing fields, which are required
+// unless hidden. Attachment fields are never required, their files are
+// uploaded once the stock exists, and neither are formula fields, which are
+// computed.
+func fillDefaults(fieldMap map[string]entity.Field, data map[string]interface{}) error {
+    for fieldName, field := range fieldMap {
+        if _, ok := data[fieldName]; !ok && !field.IsHidden && field.Type != entity.Attachment && field.Type != entity.Formula {
+            if field.DefaultValue != nil && (schema.IsTemporal(field.Type) || field.Type == entity.Multiselect || field.Type == entity.NumberDecimal) {
+                // Relative defaults such as "today" are resolved on every write,
+                // and default selections and decimals are stored like submitted ones
+                value, err := schema.ParseValue(field.DefaultValue, field)
+                if err != nil {
+                    return &dataError{field: fieldName, message: "Invalid default value for " + fieldName + ": " + err.Error()}
+                }
+                data[fieldName] = value
+            } else if field.DefaultValue != nil {
+                data[fieldName] = field.DefaultValue
+            } else {
+                return &dataError{field: fieldName, message: "Missing required field: " + fieldName}
+            }
+        }
+    }
+    return nil
 }
 
 func (h *StockHandler) GetStock(c *fiber.Ctx) error {
@@ -185,56 +279,281 @@ func (h *StockHandler) GetStock(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
 	}
 
-	return c.JSON(stock)
+	stocks, err := h.expand(c, []entity.Stock{*stock}, nil)
+	if err != nil {
+		return c.Status(expandErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	utils.SetETag(c, stock.Version)
+	return c.JSON(stocks[0])
+}
+
+// expand inlines the stocks referenced by the reference fields named in the
+// comma separated ?expand= list. The fields of the form are loaded unless given.
+func (h *StockHandler) expand(c *fiber.Ctx, stocks []entity.Stock, fields []entity.Field) ([]entity.Stock, error) {
+	var names []string
+	for _, name := range strings.Split(c.Query("expand"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return stocks, nil
+	}
+	if fields == nil {
+		form := middleware.FormFromContext(c)
+		if form == nil {
+			return nil, errors.New("Form not found")
+		}
+		var err error
+		if fields, err = h.fieldRepo.GetFieldsByFormID(form.ID); err != nil {
+			return nil, err
+		}
+	}
+	return h.references.Expand(stocks, fields, names)
 }
+
+// expandErrorStatus returns the status code to answer an expand error with
+func expandErrorStatus(err error) int {
+	if errors.Is(err, reference.ErrInvalid) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+// GetAllStocks lists the stocks of a form. It accepts a ?filter= expression
+// such as `price > 10 AND category == "tools"`, a ?sort= list such as
+// `-createdAt,name` and the usual limit and offset pagination. With
+// ?location=<id> only stocks held in that location or anything nested in it are
+// listed, and ?groupBy=location returns the quantities of all matching stocks
+// aggregated per location instead. ?expand= inlines referenced stocks like for
+// a single stock.
 func (h *StockHandler) GetAllStocks(c *fiber.Ctx) error {
 	formId, err := uuid.Parse(c.Params("_id"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid form ID format"})
 	}
 
-	stocks, err := h.repo.GetAllStocksByFormId(formId)
+	fields, err := h.fieldRepo.GetFieldsByFormID(formId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var q query.StockQuery
+	if q.Filter, err = query.ParseFilter(c.Query("filter"), fields); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid filter: " + err.Error()})
+	}
+	if q.Sort, err = query.ParseSort(c.Query("sort"), fields); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid sort: " + err.Error()})
+	}
+	q.Limit, q.Offset = utils.ParsePagination(c)
+
+	var locations []entity.Location
+	if locationParam := c.Query("location"); locationParam != "" {
+		locationID, err := uuid.Parse(locationParam)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid location ID format"})
+		}
+		location, err := h.locationRepo.GetLocationByID(locationID)
+		if err != nil || !ownsLocation(c, location) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Location not found"})
+		}
+		if locations, err = h.locationRepo.GetLocationSubtree(locationID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		q.Locations = locationIDs(locations)
+	}
+
+	switch c.Query("groupBy") {
+	case "":
+	case "location":
+		// Aggregates cover every matching stock, not just one page
+		q.Limit, q.Offset = 0, 0
+		stocks, err := h.repo.FindStocks(formId, q)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if locations == nil {
+			form := middleware.FormFromContext(c)
+			if form == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
+			}
+			if locations, err = h.locationRepo.GetLocationsByUserID(form.UserID, 0, 0); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		return c.JSON(aggregateByLocation(stocks, locations))
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "groupBy only supports location"})
+	}
+
+	stocks, err := h.repo.FindStocks(formId, q)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	total, err := h.repo.CountStocks(formId, q)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if stocks, err = h.expand(c, stocks, fields); err != nil {
+		return c.Status(expandErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
 
+	// The total number of matches lets clients page through the results
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
 	return c.JSON(stocks)
 }
 
 
+// UpdateStock replaces the data of a stock. The new data is validated like the
+// data of a new stock, keeping the previous data as a revision. The files of
+// attachment fields stay as they are and formula fields are computed again,
+// whatever values the body holds for them.
 func (h *StockHandler) UpdateStock(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("stock_id"))
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid stock ID format"})
+	existing := middleware.StockFromContext(c)
+	if existing == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+	if !utils.IfMatch(c, existing.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
 	}
 
 	var data map[string]interface{}
-	if err := c.BodyParser(&data); err != nil {
+	if err := parseData(c, &data); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	stock := entity.Stock{
-		ID:        id,
-		Data:      data,
-		UpdatedAt: time.Now(),
+	fields, err := h.fieldRepo.GetFieldsByFormID(existing.FormID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	dropReadOnly(fields, data)
+	if err := h.prepareStockData(fields, data, existing.ID); err != nil {
+		return c.Status(dataErrorStatus(err)).JSON(dataErrorBody(err))
+	}
+	keepAttachments(fields, data, existing.Data)
 
-	if err := h.repo.UpdateStock(stock); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	stock, err := h.saveStockData(c, *existing, data, 0)
+	if err != nil {
+		return c.Status(saveErrorStatus(c, err)).JSON(fiber.Map{"error": err.Error()})
 	}
+	utils.SetETag(c, stock.Version)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Stock updated"})
 }
 
+// PatchStock merges the keys in the body into the data of a stock, leaving the
+// other keys as they are; a null value removes a key. The supplied values are
+// validated like the data of a new stock, and a removed key falls back to the
+// default value of its field. Keys of formula fields are ignored, since they are
+// computed again, and attachment fields are refused, since their files are only
+// added and removed through the attachment endpoints.
+func (h *StockHandler) PatchStock(c *fiber.Ctx) error {
+	existing := middleware.StockFromContext(c)
+	if existing == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+	if !utils.IfMatch(c, existing.Version) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": utils.ErrVersionConflict.Error()})
+	}
+
+	var patch map[string]interface{}
+	if err := parseData(c, &patch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	fields, err := h.fieldRepo.GetFieldsByFormID(existing.FormID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	fieldMap := fieldsByName(fields)
+
+	data := make(map[string]interface{}, len(existing.Data)+len(patch))
+	for key, value := range existing.Data {
+		data[key] = value
+	}
+	for key, value := range patch {
+		field, exists := fieldMap[key]
+		switch {
+		case !exists:
+			invalid := &dataError{field: key, message: "Invalid field provided: " + key}
+			return c.Status(dataErrorStatus(invalid)).JSON(dataErrorBody(invalid))
+		case field.Type == entity.Attachment:
+			invalid := &dataError{field: key, message: "Files of " + key + " are added and removed with the attachment endpoints"}
+			return c.Status(dataErrorStatus(invalid)).JSON(dataErrorBody(invalid))
+		case field.Type == entity.Formula:
+			// Computed below
+			continue
+		}
+		if value == nil {
+			delete(data, key)
+			continue
+		}
+		parsed, err := h.checkStockValue(fieldMap, key, value, existing.ID)
+		if err != nil {
+			return c.Status(dataErrorStatus(err)).JSON(dataErrorBody(err))
+		}
+		data[key] = parsed
+	}
+	if err := fillDefaults(fieldMap, data); err != nil {
+		return c.Status(dataErrorStatus(err)).JSON(dataErrorBody(err))
+	}
+	if err := formula.Compute(fields, data); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	stock, err := h.saveStockData(c, *existing, data, 0)
+	if err != nil {
+		return c.Status(saveErrorStatus(c, err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	utils.SetETag(c, stock.Version)
+	return c.JSON(stock)
+}
+
+// DeleteStock moves a stock to the trash, applying the on-delete action of the
+// reference fields referencing it
 func (h *StockHandler) DeleteStock(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("stock_id"))
+	existing := middleware.StockFromContext(c)
+	if existing == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	userID, err := utils.ExtractUserID(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid stock ID format"})
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
-	if err := h.repo.DeleteStock(id); err != nil {
+	// The stock keeps its ledger and history in the trash, they are only
+	// deleted when it is purged
+	deletion, err := h.references.DeleteStock(c.UserContext(), *existing, userID, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+		case errors.Is(err, reference.ErrReferenced):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, utils.ErrVersionConflict):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Stock deleted"})
+	for i := range deletion.Trashed {
+		if err := h.audit.Record(c, stockChange(entity.AuditDelete, &deletion.Trashed[i], nil)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	for i := range deletion.Updated {
+		update := &deletion.Updated[i]
+		if err := h.audit.Record(c, stockChange(entity.AuditUpdate, &update.Before, &update.After)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	response := fiber.Map{"message": "Stock moved to the trash"}
+	if len(deletion.Trashed) > 1 || len(deletion.Updated) > 0 {
+		response["trashed"] = len(deletion.Trashed) - 1
+		response["updated"] = len(deletion.Updated)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
 }
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/audit"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/formula"
+	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	"github.com/kbc0/DynamicStockManager/trash"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type TrashHandler struct {
+	forms    formRepo.FormStore
+	fields   fieldRepo.FieldStore
+	stocks   stockRepo.StockStore
+	purger   *trash.Purger
+	formulas *formula.Updater
+	audit    *audit.Recorder
+}
+
+func NewTrashHandler(forms formRepo.FormStore, fields fieldRepo.FieldStore, stocks stockRepo.StockStore, purger *trash.Purger, formulas *formula.Updater, audit *audit.Recorder) *TrashHandler {
+	return &TrashHandler{
+		forms:    forms,
+		fields:   fields,
+		stocks:   stocks,
+		purger:   purger,
+		formulas: formulas,
+		audit:    audit,
+	}
+}
+
+// trashedItem is a form, field or stock in the trash
+type trashedItem struct {
+	kind   entity.AuditEntityType
+	id     uuid.UUID
+	formID uuid.UUID
+	entity interface{}
+}
+
+// GetTrash lists the caller's forms, and the fields and stocks of their forms,
+// that are in the trash, most recently deleted first. ?type= limits the
+// listing to forms, fields or stocks, and limit and offset apply to each list.
+func (h *TrashHandler) GetTrash(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	limit, offset := utils.ParsePagination(c)
+	kind := entity.AuditEntityType(c.Query("type"))
+	switch kind {
+	case "", entity.AuditForm, entity.AuditField, entity.AuditStock:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type must be form, field or stock"})
+	}
+
+	result := fiber.Map{}
+	if kind == "" || kind == entity.AuditForm {
+		forms, err := h.forms.GetTrashedForms(userID, time.Time{}, limit, offset)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		result["forms"] = forms
+	}
+	if kind == entity.AuditForm {
+		return c.JSON(result)
+	}
+
+	// Fields and stocks of forms in the trash come back with their form
+	forms, err := h.forms.GetFormsByUserID(userID, 0, 0)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	formIDs := make([]uuid.UUID, 0, len(forms))
+	for _, form := range forms {
+		formIDs = append(formIDs, form.ID)
+	}
+	if kind == "" || kind == entity.AuditField {
+		fields, err := h.fields.GetTrashedFields(formIDs, time.Time{}, limit, offset)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		result["fields"] = fields
+	}
+	if kind == "" || kind == entity.AuditStock {
+		stocks, err := h.stocks.GetTrashedStocks(formIDs, time.Time{}, limit, offset)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		result["stocks"] = stocks
+	}
+	return c.JSON(result)
+}
+
+// RestoreItem takes a form, field or stock out of the trash and returns it. A
+// formula field is only restored while the fields it references exist, and
+// its results are computed again for every stock.
+func (h *TrashHandler) RestoreItem(c *fiber.Ctx) error {
+	item, failure := h.findItem(c)
+	if failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
+
+	var fields []entity.Field
+	field, isField := item.entity.(*entity.Field)
+	if isField && field.Type == entity.Formula {
+		var err error
+		if fields, err = h.fields.GetFieldsByFormID(item.formID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := formula.Check(*field, fields); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The formula can no longer be computed: " + err.Error()})
+		}
+	}
+
+	var restored interface{}
+	var err error
+	switch item.kind {
+	case entity.AuditForm:
+		if err = h.forms.RestoreForm(item.id); err == nil {
+			restored, err = h.forms.GetFormByID(item.id)
+		}
+	case entity.AuditField:
+		if err = h.fields.RestoreField(item.id); err == nil {
+			restored, err = h.fields.GetFieldByID(item.id)
+		}
+	case entity.AuditStock:
+		if err = h.stocks.RestoreStock(item.id); err == nil {
+			restored, err = h.stocks.GetStockById(item.id)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Not found in the trash"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	change := audit.Change{Action: entity.AuditRestore, EntityType: item.kind, EntityID: item.id, FormID: item.formID, Before: item.entity, After: restored}
+	if err := h.audit.Record(c, change); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Results stored before the field was deleted may be out of date
+	if restoredField, ok := restored.(*entity.Field); ok && restoredField.Type == entity.Formula {
+		updates, err := h.formulas.Recompute(c.UserContext(), item.formID, append(fields, *restoredField))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Field restored, but computing its values failed: " + err.Error()})
+		}
+		for _, update := range updates {
+			change := audit.Change{Action: entity.AuditUpdate, EntityType: entity.AuditStock, EntityID: update.After.ID, FormID: item.formID, Before: update.Before, After: update.After}
+			if err := h.audit.Record(c, change); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(restored)
+}
+
+// PurgeItem deletes a form, field or stock in the trash for good, along with
+// everything that belongs to it. The values of a field are moved to the
+// archived data of the stocks in the background, or removed with ?data=drop.
+func (h *TrashHandler) PurgeItem(c *fiber.Ctx) error {
+	item, failure := h.findItem(c)
+	if failure != nil {
+		return c.Status(failure.Code).JSON(fiber.Map{"error": failure.Message})
+	}
+	archive := true
+	switch c.Query("data") {
+	case "", "archive":
+	case "drop":
+		archive = false
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "data must be archive or drop"})
+	}
+
+	var cleanup *entity.Job
+	var err error
+	switch item.kind {
+	case entity.AuditForm:
+		err = h.purger.PurgeForm(c.UserContext(), item.id)
+	case entity.AuditField:
+		cleanup, err = h.purger.PurgeField(c.UserContext(), item.id, archive)
+	case entity.AuditStock:
+		err = h.purger.PurgeStock(c.UserContext(), item.id)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	change := audit.Change{Action: entity.AuditPurge, EntityType: item.kind, EntityID: item.id, FormID: item.formID, Before: item.entity}
+	if err := h.audit.Record(c, change); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if cleanup != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Deleted for good", "job": cleanup})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Deleted for good"})
+}
+
+// findItem loads the item of the :type and :id route parameters from the trash
+// and checks that it belongs to the caller. Fields and stocks can only be
+// restored or purged while their form is not in the trash itself.
+func (h *TrashHandler) findItem(c *fiber.Ctx) (*trashedItem, *fiber.Error) {
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid ID format")
+	}
+
+	item := &trashedItem{kind: entity.AuditEntityType(c.Params("type")), id: id}
+	switch item.kind {
+	case entity.AuditForm:
+		form, err := h.forms.GetTrashedFormByID(id)
+		if err != nil {
+			return nil, lookupError(err)
+		}
+		if form.UserID != userID {
+			return nil, fiber.NewError(fiber.StatusForbidden, "You do not have access to this form")
+		}
+		item.formID, item.entity = form.ID, form
+		return item, nil
+	case entity.AuditField:
+		field, err := h.fields.GetTrashedFieldByID(id)
+		if err != nil {
+			return nil, lookupError(err)
+		}
+		item.formID, item.entity = field.FormID, field
+	case entity.AuditStock:
+		stock, err := h.stocks.GetTrashedStockByID(id)
+		if err != nil {
+			return nil, lookupError(err)
+		}
+		item.formID, item.entity = stock.FormID, stock
+	default:
+		return nil, fiber.NewError(fiber.StatusBadRequest, "type must be form, field or stock")
+	}
+
+	form, err := h.forms.GetFormByID(item.formID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if trashed, err := h.forms.GetTrashedFormByID(item.formID); err == nil && trashed.UserID == userID {
+			return nil, fiber.NewError(fiber.StatusConflict, "The form is in the trash, restore it first")
+		}
+		return nil, fiber.NewError(fiber.StatusNotFound, "Not found in the trash")
+	}
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if form.UserID != userID {
+		return nil, fiber.NewError(fiber.StatusForbidden, "You do not have access to this form")
+	}
+	return item, nil
+}
+
+func lookupError(err error) *fiber.Error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fiber.NewError(fiber.StatusNotFound, "Not found in the trash")
+	}
+	return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+}
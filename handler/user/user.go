@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/kbc0/DynamicStockManager/entity"
@@ -10,12 +12,14 @@ import (
 )
 
 type UserHandler struct {
-	repo *userRepo.UserRepository
+	repo   userRepo.UserStore
+	tokens *utils.TokenManager
 }
 
-func NewUserHandler(repo *userRepo.UserRepository) *UserHandler {
+func NewUserHandler(repo userRepo.UserStore, tokens *utils.TokenManager) *UserHandler {
 	return &UserHandler{
-		repo: repo,
+		repo:   repo,
+		tokens: tokens,
 	}
 }
 func encryptPassword(password string) string {
@@ -42,11 +46,14 @@ func (h *UserHandler) RegisterUser(c *fiber.Ctx) error {
 	user.Password = encryptPassword(user.Password)
 	id, err := h.repo.CreateUser(user)
 	if err != nil {
+		if errors.Is(err, utils.ErrDuplicate) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	// Generate JWT token
-	token, err := utils.GenerateToken(user.ID, user.Username)
+	token, err := h.tokens.GenerateToken(user.ID, user.Username)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
@@ -76,7 +83,7 @@ func (h *UserHandler) LoginUser(c *fiber.Ctx) error {
 	}
 
 	// Generate JWT token
-	token, err := utils.GenerateToken(user.ID, user.Username)
+	token, err := h.tokens.GenerateToken(user.ID, user.Username)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
@@ -94,7 +101,7 @@ func (h *UserHandler) GetAccount(c *fiber.Ctx) error {
 
 	// Parse the JWT token
 	tokenString := authHeader[len("Bearer "):]
-	claims, err := utils.VerifyToken(tokenString)
+	claims, err := h.tokens.VerifyToken(tokenString)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
 	}
@@ -0,0 +1,221 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	jobRepo "github.com/kbc0/DynamicStockManager/repository/job"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	"github.com/rs/zerolog"
+)
+
+// BatchSize is the number of stocks a job migrates between progress updates
+const BatchSize = 500
+
+// Runner migrates the stock data of forms in the background, one job at a
+// time in the order they were submitted, so that a rename and a later rename
+// of the same field never overlap
+type Runner struct {
+	jobs   jobRepo.JobStore
+	stocks stockRepo.StockStore
+
+	mu     sync.Mutex
+	queue  []uuid.UUID
+	notify chan struct{}
+}
+
+func NewRunner(jobs jobRepo.JobStore, stocks stockRepo.StockStore) *Runner {
+	return &Runner{
+		jobs:   jobs,
+		stocks: stocks,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Submit records a pending job and queues it, returning the recorded job
+func (r *Runner) Submit(job entity.Job) (*entity.Job, error) {
+	job.ID = uuid.New()
+	job.Status = entity.JobPending
+	job.CreatedAt = time.Now()
+	if err := r.jobs.CreateJob(job); err != nil {
+		return nil, err
+	}
+	r.enqueue(job.ID)
+	return &job, nil
+}
+
+// Unfinished returns a pending or running job migrating the data of a field,
+// or nil if there is none
+func (r *Runner) Unfinished(formID uuid.UUID, fieldID uuid.UUID) (*entity.Job, error) {
+	jobs, err := r.jobs.GetJobsByFormID(formID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.FieldID == fieldID && !job.Finished() {
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+// UsingName returns a pending or running job of the form that moves or removes
+// stock data under the field name, or nil if there is none
+func (r *Runner) UsingName(formID uuid.UUID, name string) (*entity.Job, error) {
+	jobs, err := r.jobs.GetJobsByFormID(formID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if (job.FieldName == name || job.NewName == name) && !job.Finished() {
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *Runner) enqueue(id uuid.UUID) {
+	r.mu.Lock()
+	r.queue = append(r.queue, id)
+	r.mu.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the first queued job ID, if any
+func (r *Runner) next() (uuid.UUID, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return uuid.Nil, false
+	}
+	id := r.queue[0]
+	r.queue = r.queue[1:]
+	return id, true
+}
+
+// Run processes queued jobs until the context is cancelled. It first resumes
+// the jobs left unfinished by a previous run, which is safe because every job
+// only touches the stocks that still need migrating.
+func (r *Runner) Run(ctx context.Context, logger *zerolog.Logger) {
+	unfinished, err := r.jobs.GetUnfinishedJobs()
+	if err != nil {
+		logger.Error().Err(err).Msg("loading unfinished jobs failed")
+	}
+	r.mu.Lock()
+	resumed := make([]uuid.UUID, 0, len(unfinished)+len(r.queue))
+	for _, job := range unfinished {
+		resumed = append(resumed, job.ID)
+	}
+	r.queue = append(resumed, r.queue...)
+	r.mu.Unlock()
+
+	for {
+		for {
+			id, ok := r.next()
+			if !ok {
+				break
+			}
+			if err := r.process(ctx, id); err != nil {
+				logger.Error().Err(err).Str("job", id.String()).Msg("job failed")
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.notify:
+		}
+	}
+}
+
+// process runs a job to completion, recording its progress after every batch.
+// Jobs that are already finished, which happens when a job is both resumed and
+// still queued, are skipped.
+func (r *Runner) process(ctx context.Context, id uuid.UUID) error {
+	job, err := r.jobs.GetJobByID(id)
+	if err != nil {
+		return err
+	}
+	if job.Finished() {
+		return nil
+	}
+
+	remaining, err := r.stocks.CountStocksWithDataKey(job.FormID, job.FieldName)
+	if err != nil {
+		return r.fail(job, err)
+	}
+	now := time.Now()
+	job.Status = entity.JobRunning
+	job.StartedAt = &now
+	job.Total = job.Processed + remaining
+	if err := r.jobs.UpdateJob(*job); err != nil {
+		return err
+	}
+
+	for {
+		changed, err := r.batch(ctx, job)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Interrupted by a shutdown rather than failed
+				return nil
+			}
+			return r.fail(job, err)
+		}
+		if changed == 0 {
+			break
+		}
+		job.Processed += changed
+		if job.Processed > job.Total {
+			// Stocks written under the old key while the job ran
+			job.Total = job.Processed
+		}
+		if err := r.jobs.UpdateJob(*job); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			// Left running, to be resumed by the next run
+			return nil
+		}
+	}
+
+	finished := time.Now()
+	job.Status = entity.JobDone
+	job.FinishedAt = &finished
+	return r.jobs.UpdateJob(*job)
+}
+
+// batch migrates the next batch of stocks of a job and returns how many it changed
+func (r *Runner) batch(ctx context.Context, job *entity.Job) (int64, error) {
+	switch job.Kind {
+	case entity.JobRenameField:
+		return r.stocks.RenameDataKey(ctx, job.FormID, job.FieldName, job.NewName, BatchSize)
+	case entity.JobDropField:
+		return r.stocks.RemoveDataKey(ctx, job.FormID, job.FieldName, false, BatchSize)
+	case entity.JobArchiveField:
+		return r.stocks.RemoveDataKey(ctx, job.FormID, job.FieldName, true, BatchSize)
+	}
+	return 0, errors.New("unknown job kind " + string(job.Kind))
+}
+
+// fail records why a job stopped and returns the error
+func (r *Runner) fail(job *entity.Job, cause error) error {
+	finished := time.Now()
+	job.Status = entity.JobFailed
+	job.Error = cause.Error()
+	job.FinishedAt = &finished
+	if err := r.jobs.UpdateJob(*job); err != nil {
+		return err
+	}
+	return cause
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kbc0/DynamicStockManager/config"
+	"github.com/kbc0/DynamicStockManager/migration"
+)
+
+// runMigrateCommand runs the migrate subcommand and returns the exit code:
+//
+//	migrate up            apply all pending migrations
+//	migrate list          list all migrations and whether they were applied
+//	migrate down [count]  roll back the latest count applied migrations, 1 by default
+func runMigrateCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		args = []string{"up"}
+	}
+	count := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fmt.Fprintln(os.Stderr, "migrate down: count must be a positive number")
+			return 2
+		}
+		count = n
+	case len(args) != 1 || (args[0] != "up" && args[0] != "list" && args[0] != "down"):
+		fmt.Fprintln(os.Stderr, "usage: migrate up|list|down [count]")
+		return 2
+	}
+
+	client, err := connectMongo(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer client.Disconnect(context.TODO())
+
+	runner, err := migration.NewRunner(client.Database(cfg.Mongo.Database), migration.All)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "list":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, applied, status.Description)
+		}
+		w.Flush()
+		return 0
+	case "up":
+		applied, err := runner.Up(ctx)
+		report("applied", applied)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return 0
+	default:
+		rolledBack, err := runner.Down(ctx, count)
+		report("rolled back", rolledBack)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("no applied migrations")
+		}
+		return 0
+	}
+}
+
+func report(action string, migrations []migration.Migration) {
+	for _, m := range migrations {
+		fmt.Printf("%s %d: %s\n", action, m.Version, m.Description)
+	}
+}
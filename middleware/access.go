@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Keys used to share the resolved entities with the route handlers
+const (
+	formLocalKey  = "form"
+	fieldLocalKey = "field"
+	stockLocalKey = "stock"
+)
+
+// FormAccess authorizes requests on form scoped routes. It resolves the form in
+// the :_id route parameter and makes sure it belongs to the authenticated user,
+// and that :field_id and :stock_id belong to that form.
+type FormAccess struct {
+	forms  formRepo.FormStore
+	fields fieldRepo.FieldStore
+	stocks stockRepo.StockStore
+}
+
+func NewFormAccess(forms formRepo.FormStore, fields fieldRepo.FieldStore, stocks stockRepo.StockStore) *FormAccess {
+	return &FormAccess{
+		forms:  forms,
+		fields: fields,
+		stocks: stocks,
+	}
+}
+
+// RequireForm only lets the owner of the form in :_id through
+func (a *FormAccess) RequireForm(c *fiber.Ctx) error {
+	formID, err := uuid.Parse(c.Params("_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid form ID format"})
+	}
+
+	userID, err := utils.ExtractUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	form, err := a.forms.GetFormByID(formID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Form not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if form.UserID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You do not have access to this form"})
+	}
+
+	c.Locals(formLocalKey, form)
+	return c.Next()
+}
+
+// RequireField makes sure the field in :field_id belongs to the form resolved
+// by RequireForm, which must run before it
+func (a *FormAccess) RequireField(c *fiber.Ctx) error {
+	form := FormFromContext(c)
+	fieldID, err := uuid.Parse(c.Params("field_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid field ID format"})
+	}
+
+	field, err := a.fields.GetFieldByID(fieldID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Field not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if form == nil || field.FormID != form.ID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Field not found"})
+	}
+
+	c.Locals(fieldLocalKey, field)
+	return c.Next()
+}
+
+// RequireStock makes sure the stock in :stock_id belongs to the form resolved
+// by RequireForm, which must run before it
+func (a *FormAccess) RequireStock(c *fiber.Ctx) error {
+	form := FormFromContext(c)
+	stockID, err := uuid.Parse(c.Params("stock_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid stock ID format"})
+	}
+
+	stock, err := a.stocks.GetStockById(stockID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if form == nil || stock.FormID != form.ID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stock not found"})
+	}
+
+	c.Locals(stockLocalKey, stock)
+	return c.Next()
+}
+
+// FormFromContext returns the form resolved by RequireForm, or nil
+func FormFromContext(c *fiber.Ctx) *entity.Form {
+	form, _ := c.Locals(formLocalKey).(*entity.Form)
+	return form
+}
+
+// FieldFromContext returns the field resolved by RequireField, or nil
+func FieldFromContext(c *fiber.Ctx) *entity.Field {
+	field, _ := c.Locals(fieldLocalKey).(*entity.Field)
+	return field
+}
+
+// StockFromContext returns the stock resolved by RequireStock, or nil
+func StockFromContext(c *fiber.Ctx) *entity.Stock {
+	stock, _ := c.Locals(stockLocalKey).(*entity.Stock)
+	return stock
+}
@@ -3,19 +3,27 @@ package middleware
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	jwtware "github.com/gofiber/jwt/v2"
 )
 
-func RegisterMiddleware(app *fiber.App) {
+// RegisterMiddleware installs the application wide middleware, protecting every
+// route except login and register with tokens signed by jwtSecret
+func RegisterMiddleware(app *fiber.App, jwtSecret string) {
+	// Tag every request with an ID, taken from X-Request-ID when the client sends one
+	app.Use(requestid.New(requestid.Config{ContextKey: requestIDLocalKey}))
+
 	// Apply CORS settings for all routes, adjust as per your requirements
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH",
+		// Browsers only let clients read these headers when exposed
+		ExposeHeaders: "ETag, X-Total-Count",
 	}))
 
 	// JWT Middleware for protected routes
 	app.Use(jwtware.New(jwtware.Config{
-		SigningKey: []byte("secret"), 
+		SigningKey: []byte(jwtSecret),
 		Filter: func(ctx *fiber.Ctx) bool {
 			// List of routes that don't require authentication
 			unprotectedPaths := []string{
@@ -35,3 +43,12 @@ func RegisterMiddleware(app *fiber.App) {
 		},
 	}))
 }
+
+const requestIDLocalKey = "requestid"
+
+// RequestIDFromContext returns the ID of the request, which is also sent back
+// in the X-Request-ID header
+func RequestIDFromContext(c *fiber.Ctx) string {
+	requestID, _ := c.Locals(requestIDLocalKey).(string)
+	return requestID
+}
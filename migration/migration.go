@@ -0,0 +1,213 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName is the collection recording the applied migrations
+const CollectionName = "migrations"
+
+// ErrIrreversible is returned when rolling back a migration without a Down step
+var ErrIrreversible = errors.New("migration cannot be rolled back")
+
+// Migration is one versioned change to the database schema or its data.
+// Versions are applied in ascending order and must never be reused.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error // nil if the migration is irreversible
+}
+
+// Record is the document stored for every applied migration
+type Record struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Status reports whether a known migration has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Runner applies and rolls back migrations against a database
+type Runner struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+	migrations []Migration
+}
+
+// NewRunner creates a runner for the given migrations, which are sorted by version
+func NewRunner(db *mongo.Database, migrations []Migration) (*Runner, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Up == nil {
+			return nil, fmt.Errorf("migration %d: a positive version and an Up step are required", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migration %d is registered twice", m.Version)
+		}
+	}
+	return &Runner{
+		db:         db,
+		collection: db.Collection(CollectionName),
+		migrations: sorted,
+	}, nil
+}
+
+// applied returns the records of the applied migrations by version
+func (r *Runner) applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Status lists every known migration in version order and whether it was applied
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(r.migrations))
+	for i, m := range r.migrations {
+		record, ok := applied[m.Version]
+		statuses[i] = Status{Migration: m, Applied: ok, AppliedAt: record.AppliedAt}
+	}
+	return statuses, nil
+}
+
+// Up applies all pending migrations in version order and returns the ones it
+// applied. It stops at the first failing migration, leaving it pending. Every
+// step is idempotent, so a migration that another process applies at the same
+// time is simply applied twice and recorded once.
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range r.migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := m.Up(ctx, r.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		record := Record{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}
+		if _, err := r.collection.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down rolls back the given number of most recently applied migrations, newest
+// first, and returns the ones it rolled back. It refuses to roll back past an
+// irreversible migration.
+func (r *Runner) Down(ctx context.Context, count int) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(r.migrations) - 1; i >= 0 && len(done) < count; i-- {
+		m := r.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, ErrIrreversible)
+		}
+		if err := m.Down(ctx, r.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		if _, err := r.collection.DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// steps returns a step running the given steps in order
+func steps(all ...func(context.Context, *mongo.Database) error) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for _, step := range all {
+			if err := step(ctx, db); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// createIndexes returns an Up step creating indexes on a collection
+func createIndexes(collection string, models ...mongo.IndexModel) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+		return err
+	}
+}
+
+// dropIndexes returns a Down step dropping indexes by name, ignoring the ones
+// that do not exist
+func dropIndexes(collection string, names ...string) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for _, name := range names {
+			if err := dropIndex(ctx, db.Collection(collection), name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && (commandErr.Name == "IndexNotFound" || commandErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	return err
+}
+
+// index describes a named index over the given keys, in order
+func index(name string, keys ...string) mongo.IndexModel {
+	d := bson.D{}
+	for _, key := range keys {
+		d = append(d, bson.E{Key: key, Value: 1})
+	}
+	return mongo.IndexModel{Keys: d, Options: options.Index().SetName(name)}
+}
+
+// uniqueIndex describes a named unique index over the given keys, in order
+func uniqueIndex(name string, keys ...string) mongo.IndexModel {
+	model := index(name, keys...)
+	model.Options.SetUnique(true)
+	return model
+}
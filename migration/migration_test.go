@@ -0,0 +1,77 @@
+package migration
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB returns a database handle without a server behind it, the driver
+// only connects once a command is sent
+func testDB(t *testing.T) *mongo.Database {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client.Database("test")
+}
+
+func noop(context.Context, *mongo.Database) error { return nil }
+
+func TestNewRunner(t *testing.T) {
+	db := testDB(t)
+	runner, err := NewRunner(db, []Migration{{Version: 3, Up: noop}, {Version: 1, Up: noop}, {Version: 2, Up: noop}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range runner.migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migrations not sorted: %+v", runner.migrations)
+		}
+	}
+
+	invalid := map[string][]Migration{
+		"twice":    {{Version: 1, Up: noop}, {Version: 1, Up: noop}},
+		"positive": {{Version: 0, Up: noop}},
+		"Up step":  {{Version: 1}},
+	}
+	for message, migrations := range invalid {
+		if _, err := NewRunner(db, migrations); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("got %v, want an error mentioning %q", err, message)
+		}
+	}
+}
+
+// TestAll checks the registered migrations, whose versions are never reused or
+// left out
+func TestAll(t *testing.T) {
+	if _, err := NewRunner(testDB(t), All); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range All {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d registered as number %d", m.Version, i+1)
+		}
+		if m.Description == "" {
+			t.Errorf("migration %d has no description", m.Version)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	model := uniqueIndex("form_name", "userId", "name")
+	if keys, ok := model.Keys.(bson.D); !ok || len(keys) != 2 || keys[0].Key != "userId" || keys[1].Key != "name" {
+		t.Fatalf("unexpected keys %v", model.Keys)
+	}
+	if *model.Options.Name != "form_name" || model.Options.Unique == nil || !*model.Options.Unique {
+		t.Fatalf("unexpected options %+v", model.Options)
+	}
+	if index("a", "b").Options.Unique != nil {
+		t.Fatal("plain index is unique")
+	}
+}
@@ -0,0 +1,253 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All lists the migrations of the application. Append new migrations with the
+// next version, never change or remove one that may have been applied.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "unique indexes on usernames and name-surname combinations",
+		Up: createIndexes("users",
+			uniqueIndex("unique_username", "username"),
+			uniqueIndex("unique_name_surname", "name", "surname"),
+		),
+		Down: dropIndexes("users", "unique_username", "unique_name_surname"),
+	},
+	{
+		Version:     2,
+		Description: "unique index on form names per user, also used to list a user's forms",
+		Up:          createIndexes("forms", uniqueIndex("unique_user_name", "userId", "name")),
+		Down:        dropIndexes("forms", "unique_user_name"),
+	},
+	{
+		Version:     3,
+		Description: "unique index on field names per form and index on the field order",
+		Up: createIndexes("fields",
+			uniqueIndex("unique_form_name", "formId", "name"),
+			index("form_order", "formId", "order"),
+		),
+		Down: dropIndexes("fields", "unique_form_name", "form_order"),
+	},
+	{
+		Version:     4,
+		Description: "indexes on the stocks of a form in creation order and on stock data",
+		Up: createIndexes("stocks",
+			index("form_created", "formId", "createdAt", "_id"),
+			mongo.IndexModel{
+				// Covers filters on any data.<field> without an index per field
+				Keys:    bson.D{{Key: "data.$**", Value: 1}},
+				Options: options.Index().SetName("data_wildcard"),
+			},
+		),
+		Down: dropIndexes("stocks", "form_created", "data_wildcard"),
+	},
+	{
+		// Unique fields were once enforced by an index per field on the stocks,
+		// which never shipped. Migration 12 claims their values instead.
+		Version:     5,
+		Description: "no longer used, unique field values are claimed by migration 12",
+		Up:          func(ctx context.Context, db *mongo.Database) error { return nil },
+		Down:        func(ctx context.Context, db *mongo.Database) error { return nil },
+	},
+	{
+		Version:     6,
+		Description: "indexes on movements, locations, stock revisions and audit entries",
+		Up: steps(
+			createIndexes("movements", index("stock_created", "stockId", "createdAt"), index("form", "formId")),
+			createIndexes("locations", index("user_parent_name", "userId", "parentId", "name"), index("path", "path")),
+			createIndexes("stock_revisions", index("stock_revision", "stockId", "revision"), index("form", "formId")),
+			createIndexes("audit", index("owner_created", "ownerId", "createdAt")),
+		),
+		Down: steps(
+			dropIndexes("movements", "stock_created", "form"),
+			dropIndexes("locations", "user_parent_name", "path"),
+			dropIndexes("stock_revisions", "stock_revision", "form"),
+			dropIndexes("audit", "owner_created"),
+		),
+	},
+	{
+		Version:     7,
+		Description: "backfill version of forms, fields and stocks and on-hand quantity of stocks",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{"forms", "fields", "stocks"} {
+				if err := backfill(ctx, db.Collection(name), "version", int64(1)); err != nil {
+					return err
+				}
+			}
+			return backfill(ctx, db.Collection("stocks"), "onHand", int64(0))
+		},
+		// The backfilled values are what older code assumed for missing ones,
+		// so rolling back leaves them in place
+		Down: func(ctx context.Context, db *mongo.Database) error { return nil },
+	},
+	{
+		Version:     8,
+		Description: "indexes on the deletion time of forms, fields and stocks in the trash",
+		Up: steps(
+			createIndexes("forms", trashIndex()),
+			createIndexes("fields", trashIndex()),
+			createIndexes("stocks", trashIndex()),
+		),
+		Down: steps(
+			dropIndexes("forms", "trash"),
+			dropIndexes("fields", "trash"),
+			dropIndexes("stocks", "trash"),
+		),
+	},
+	{
+		Version:     9,
+		Description: "indexes on the jobs of a form and on unfinished jobs",
+		Up: createIndexes("jobs",
+			index("form_created", "formId", "createdAt"),
+			index("status_created", "status", "createdAt"),
+		),
+		Down: dropIndexes("jobs", "form_created", "status_created"),
+	},
+	{
+		Version:     10,
+		Description: "index on the target form of reference fields",
+		Up:          createIndexes("fields", index("target_form", "targetFormId")),
+		Down:        dropIndexes("fields", "target_form"),
+	},
+	{
+		Version:     11,
+		Description: "numberDecimal values of stocks and their revisions stored as decimal128 instead of double",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return eachDecimalValue(ctx, db, func(collection *mongo.Collection, field entity.Field) error {
+				return toDecimal128(ctx, collection, field)
+			})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return eachDecimalValue(ctx, db, func(collection *mongo.Collection, field entity.Field) error {
+				key := "data." + field.Name
+				_, err := collection.UpdateMany(ctx,
+					bson.M{"formId": field.FormID, key: bson.M{"$type": "decimal"}},
+					mongo.Pipeline{{{Key: "$set", Value: bson.M{key: bson.M{"$toDouble": "$" + key}}}}},
+				)
+				return err
+			})
+		},
+	},
+	{
+		Version:     12,
+		Description: "unique field values of stocks claimed in the unique_values collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			values := db.Collection(stockRepo.UniqueValuesCollection)
+			if _, err := values.Indexes().CreateMany(ctx, stockRepo.UniqueValueIndexes()); err != nil {
+				return err
+			}
+			fields, err := uniqueFields(ctx, db)
+			if err != nil {
+				return err
+			}
+			for _, field := range fields {
+				// Claims of an earlier attempt are made again
+				if _, err := values.DeleteMany(ctx, bson.M{"fieldId": field.ID}); err != nil {
+					return err
+				}
+				if err := stockRepo.ClaimUniqueValues(ctx, db.Collection("stocks"), values, field, field.Name); err != nil {
+					return fmt.Errorf("form %s: %w", field.FormID, err)
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(stockRepo.UniqueValuesCollection).Drop(ctx)
+		},
+	},
+}
+
+// trashIndex only covers documents in the trash, which the purge job looks up
+// by their deletion time
+func trashIndex() mongo.IndexModel {
+	model := index("trash", "deletedAt")
+	model.Options.SetPartialFilterExpression(bson.M{"deletedAt": bson.M{"$exists": true}})
+	return model
+}
+
+// uniqueFields returns all fields marked unique
+func uniqueFields(ctx context.Context, db *mongo.Database) ([]entity.Field, error) {
+	cursor, err := db.Collection("fields").Find(ctx, bson.M{"isUnique": true})
+	if err != nil {
+		return nil, err
+	}
+	var fields []entity.Field
+	if err := cursor.All(ctx, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// backfill sets a field on the documents of a collection that do not have it
+func backfill(ctx context.Context, collection *mongo.Collection, key string, value interface{}) error {
+	_, err := collection.UpdateMany(ctx,
+		bson.M{key: bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{key: value}},
+	)
+	return err
+}
+
+// eachDecimalValue calls convert for the stocks and the stock revisions of
+// every numberDecimal field, including the fields in the trash
+func eachDecimalValue(ctx context.Context, db *mongo.Database, convert func(collection *mongo.Collection, field entity.Field) error) error {
+	cursor, err := db.Collection("fields").Find(ctx, bson.M{"type": entity.NumberDecimal})
+	if err != nil {
+		return err
+	}
+	var fields []entity.Field
+	if err := cursor.All(ctx, &fields); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		for _, name := range []string{"stocks", "stock_revisions"} {
+			if err := convert(db.Collection(name), field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// toDecimal128 stores the numeric values of a numberDecimal field as decimals,
+// each as the shortest text that reads back as its double, so 0.1 becomes
+// exactly 0.1 rather than the binary fraction a $toDecimal would give
+func toDecimal128(ctx context.Context, collection *mongo.Collection, field entity.Field) error {
+	key := "data." + field.Name
+	cursor, err := collection.Find(ctx,
+		bson.M{"formId": field.FormID, key: bson.M{"$type": bson.A{"double", "int", "long"}}},
+		options.Find().SetProjection(bson.M{key: 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID   interface{}            `bson:"_id"`
+			Data map[string]interface{} `bson:"data"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		value, err := decimal.Parse(doc.Data[field.Name])
+		if err != nil {
+			// NaN and infinite doubles have no decimal, they are left as they are
+			continue
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{key: value}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,463 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/schema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Metadata attributes of stock records that can be filtered and sorted on
+// besides the form fields. Form fields with the same name take precedence.
+const (
+	FieldCreatedAt = "createdAt"
+	FieldUpdatedAt = "updatedAt"
+	FieldOnHand    = "onHand"
+)
+
+type Operator string
+
+const (
+	Equal          Operator = "=="
+	NotEqual       Operator = "!="
+	Greater        Operator = ">"
+	GreaterOrEqual Operator = ">="
+	Less           Operator = "<"
+	LessOrEqual    Operator = "<="
+)
+
+var mongoOperators = map[Operator]string{
+	Equal:          "$eq",
+	NotEqual:       "$ne",
+	Greater:        "$gt",
+	GreaterOrEqual: "$gte",
+	Less:           "$lt",
+	LessOrEqual:    "$lte",
+}
+
+// Expr is a validated filter expression over the stocks of a form
+type Expr interface {
+	// BSON translates the expression into a MongoDB query document
+	BSON() bson.M
+	// Match evaluates the expression against a stock the way MongoDB would
+	Match(stock entity.Stock) bool
+}
+
+type And struct{ Left, Right Expr }
+
+type Or struct{ Left, Right Expr }
+
+type Not struct{ Expr Expr }
+
+// Comparison compares an attribute of the stock with a literal value
+type Comparison struct {
+	Field string // Name as written in the filter
+	Path  string // Path of the attribute in the stock document
+	Op    Operator
+	Value interface{} // int64, primitive.Decimal128, string, bool, time.Time or nil
+}
+
+// Contains matches stocks whose multiselect or reference value holds any or
+// all of the given options or stock IDs
+type Contains struct {
+	Field  string
+	Path   string
+	All    bool
+	Values []string
+}
+
+func (e And) BSON() bson.M {
+	return bson.M{"$and": []bson.M{e.Left.BSON(), e.Right.BSON()}}
+}
+
+func (e And) Match(stock entity.Stock) bool {
+	return e.Left.Match(stock) && e.Right.Match(stock)
+}
+
+func (e Or) BSON() bson.M {
+	return bson.M{"$or": []bson.M{e.Left.BSON(), e.Right.BSON()}}
+}
+
+func (e Or) Match(stock entity.Stock) bool {
+	return e.Left.Match(stock) || e.Right.Match(stock)
+}
+
+func (e Not) BSON() bson.M {
+	return bson.M{"$nor": []bson.M{e.Expr.BSON()}}
+}
+
+func (e Not) Match(stock entity.Stock) bool {
+	return !e.Expr.Match(stock)
+}
+
+func (e Comparison) BSON() bson.M {
+	return bson.M{e.Path: bson.M{mongoOperators[e.Op]: e.Value}}
+}
+
+func (e Comparison) Match(stock entity.Stock) bool {
+	actual, exists := valueAt(stock, e.Path)
+	if !exists {
+		actual = nil
+	}
+	switch e.Op {
+	case Equal:
+		return compareValues(actual, e.Value) == 0
+	case NotEqual:
+		return compareValues(actual, e.Value) != 0
+	}
+
+	// Like MongoDB, ordering comparisons only match values of the same type
+	if typeRank(actual) != typeRank(e.Value) || e.Value == nil {
+		return false
+	}
+	order := compareValues(actual, e.Value)
+	switch e.Op {
+	case Greater:
+		return order > 0
+	case GreaterOrEqual:
+		return order >= 0
+	case Less:
+		return order < 0
+	case LessOrEqual:
+		return order <= 0
+	}
+	return false
+}
+
+func (e Contains) BSON() bson.M {
+	if e.All {
+		return bson.M{e.Path: bson.M{"$all": e.Values}}
+	}
+	return bson.M{e.Path: bson.M{"$in": e.Values}}
+}
+
+func (e Contains) Match(stock entity.Stock) bool {
+	actual, _ := valueAt(stock, e.Path)
+	selection, ok := schema.Selection(actual)
+	if !ok {
+		return false
+	}
+	for _, value := range e.Values {
+		selected := containsString(selection, value)
+		if selected && !e.All {
+			return true
+		}
+		if !selected && e.All {
+			return false
+		}
+	}
+	return e.All
+}
+
+// ParseFilter parses a filter such as `price > 10 AND category == "tools"` and
+// validates it against the fields of the form. Comparisons can be combined
+// with AND, OR, NOT and parentheses. Multiselect and reference fields are
+// filtered with `tags CONTAINS ANY ("a", "b")` or `CONTAINS ALL`, and
+// `tags CONTAINS "a"` for a single value. An empty filter returns a nil Expr.
+func ParseFilter(input string, fields []entity.Field) (Expr, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, nil
+	}
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, attributes: newAttributes(fields)}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", next.text, next.pos)
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens     []token
+	pos        int
+	attributes attributes
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && !t.quote && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.keyword("NOT") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+	if p.peek().kind == tokenLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ) at position %d", t.pos)
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	name := p.next()
+	if name.kind != tokenIdent {
+		return nil, fmt.Errorf("expected field name at position %d", name.pos)
+	}
+	attribute, ok := p.attributes.lookup(name.text)
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", name.text)
+	}
+
+	if p.keyword("CONTAINS") {
+		return p.parseContains(name.text, attribute)
+	}
+
+	op := p.next()
+	if op.kind != tokenOperator {
+		return nil, fmt.Errorf("expected comparison operator after %q at position %d", name.text, op.pos)
+	}
+
+	literal := p.next()
+	value, err := attribute.convert(literal)
+	if err != nil {
+		return nil, err
+	}
+	if err := attribute.allows(Operator(op.text), value); err != nil {
+		return nil, err
+	}
+
+	return Comparison{Field: name.text, Path: attribute.path, Op: Operator(op.text), Value: value}, nil
+}
+
+// parseContains parses the options or stock IDs after CONTAINS, optionally
+// preceded by ANY or ALL: a single quoted value or a parenthesized list of them
+func (p *parser) parseContains(name string, attribute attribute) (Expr, error) {
+	if attribute.kind != entity.Multiselect && attribute.kind != entity.Reference {
+		return nil, fmt.Errorf("CONTAINS only applies to multiselect and reference fields, %s is a %s", name, attribute.kind)
+	}
+	all := false
+	if p.keyword("ALL") {
+		all = true
+	} else {
+		p.keyword("ANY")
+	}
+
+	var literals []token
+	if p.peek().kind == tokenLParen {
+		p.next()
+		for {
+			literals = append(literals, p.next())
+			if t := p.next(); t.kind == tokenRParen {
+				break
+			} else if t.kind != tokenComma {
+				return nil, fmt.Errorf("expected , or ) at position %d", t.pos)
+			}
+		}
+	} else {
+		literals = append(literals, p.next())
+	}
+
+	values := make([]string, len(literals))
+	for i, literal := range literals {
+		if literal.kind != tokenString {
+			return nil, fmt.Errorf("%s expects quoted options, got %q at position %d", name, literal.text, literal.pos)
+		}
+		if attribute.kind == entity.Reference {
+			id, err := uuid.Parse(literal.text)
+			if err != nil {
+				return nil, fmt.Errorf("%s expects quoted stock IDs, got %q", name, literal.text)
+			}
+			values[i] = id.String()
+			continue
+		}
+		if attribute.field != nil && !containsString(attribute.field.Options, literal.text) {
+			return nil, fmt.Errorf("%q is not an option of %s", literal.text, name)
+		}
+		values[i] = literal.text
+	}
+	return Contains{Field: name, Path: attribute.path, All: all, Values: values}, nil
+}
+
+// attribute describes something of a stock that can be filtered and sorted on
+type attribute struct {
+	name  string
+	path  string
+	field *entity.Field // nil for metadata attributes
+	kind  entity.FieldType
+}
+
+type attributes map[string]attribute
+
+// timeKind is used for metadata timestamps, which are not a form field type
+const timeKind entity.FieldType = "timestamp"
+
+func newAttributes(fields []entity.Field) attributes {
+	attrs := attributes{
+		FieldCreatedAt: {name: FieldCreatedAt, path: FieldCreatedAt, kind: timeKind},
+		FieldUpdatedAt: {name: FieldUpdatedAt, path: FieldUpdatedAt, kind: timeKind},
+		FieldOnHand:    {name: FieldOnHand, path: FieldOnHand, kind: entity.Number},
+	}
+	for i := range fields {
+		field := fields[i]
+		kind := field.Type
+		if kind == entity.Formula {
+			// Results of formulas are stored like values of their result type
+			kind = field.ResultType
+		}
+		attrs[field.Name] = attribute{name: field.Name, path: "data." + field.Name, field: &field, kind: kind}
+	}
+	return attrs
+}
+
+func (a attributes) lookup(name string) (attribute, bool) {
+	attr, ok := a[name]
+	return attr, ok
+}
+
+// convert turns a literal into the value stored for the attribute type
+func (a attribute) convert(literal token) (interface{}, error) {
+	if literal.kind == tokenIdent && !literal.quote && literal.text == "null" {
+		return nil, nil
+	}
+
+	switch a.kind {
+	case entity.Number:
+		if literal.kind == tokenNumber {
+			if value, err := strconv.ParseInt(literal.text, 10, 64); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%s expects an integer, got %q", a.name, literal.text)
+	case entity.NumberDecimal:
+		if literal.kind == tokenNumber {
+			if value, err := decimal.Parse(literal.text); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%s expects a number, got %q", a.name, literal.text)
+	case entity.Checkbox:
+		if literal.kind == tokenIdent && !literal.quote && (literal.text == "true" || literal.text == "false") {
+			return literal.text == "true", nil
+		}
+		return nil, fmt.Errorf("%s expects true or false, got %q", a.name, literal.text)
+	case entity.Text, entity.Combobox:
+		if literal.kind == tokenString {
+			return literal.text, nil
+		}
+		return nil, fmt.Errorf("%s expects a quoted string, got %q", a.name, literal.text)
+	case entity.Date, entity.DateTime, entity.Time:
+		if literal.kind == tokenString {
+			if value, err := schema.ParseTime(literal.text, a.kind, time.Now()); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%s expects a quoted ISO-8601 %s or a relative time such as \"today\", got %q", a.name, a.kind, literal.text)
+	case entity.Multiselect, entity.Reference:
+		return nil, fmt.Errorf("%s can only be compared with null, filter its values with CONTAINS ANY or CONTAINS ALL", a.name)
+	case entity.Attachment:
+		return nil, fmt.Errorf("%s can only be compared with null", a.name)
+	case timeKind:
+		if literal.kind == tokenString {
+			if value, err := parseTime(literal.text); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%s expects a quoted RFC 3339 timestamp or date, got %q", a.name, literal.text)
+	}
+	return nil, fmt.Errorf("field %s of type %s cannot be filtered", a.name, a.kind)
+}
+
+// allows checks that the operator makes sense for the attribute and value
+func (a attribute) allows(op Operator, value interface{}) error {
+	if _, ok := mongoOperators[op]; !ok {
+		return fmt.Errorf("unknown operator %q", op)
+	}
+	ordering := op != Equal && op != NotEqual
+	if ordering && value == nil {
+		return fmt.Errorf("null can only be compared with == or !=")
+	}
+	if ordering && (a.kind == entity.Checkbox || a.kind == entity.Combobox) {
+		return fmt.Errorf("%s only supports == and !=", a.name)
+	}
+	if a.kind == entity.Combobox && value != nil && a.field != nil && !containsString(a.field.Options, value.(string)) {
+		return fmt.Errorf("%q is not an option of %s", value, a.name)
+	}
+	return nil
+}
+
+func parseTime(text string) (time.Time, error) {
+	if value, err := time.Parse(time.RFC3339, text); err == nil {
+		return value, nil
+	}
+	return time.Parse("2006-01-02", text)
+}
+
+func containsString(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testFields = []entity.Field{
+	{Name: "qty", Type: entity.Number},
+	{Name: "price", Type: entity.NumberDecimal},
+	{Name: "name", Type: entity.Text},
+	{Name: "size", Type: entity.Combobox, Options: []string{"S", "M"}},
+	{Name: "active", Type: entity.Checkbox},
+	{Name: "tags", Type: entity.Multiselect, Options: []string{"a", "b", "c"}},
+	{Name: "due", Type: entity.Date},
+	{Name: "total", Type: entity.Formula, ResultType: entity.NumberDecimal},
+	{Name: "unit cost", Type: entity.Number},
+	{Name: "parent", Type: entity.Reference},
+	{Name: "photo", Type: entity.Attachment},
+}
+
+func qty(op Operator, value int64) Comparison {
+	return Comparison{Field: "qty", Path: "data.qty", Op: op, Value: value}
+}
+
+func TestParseFilterPrecedence(t *testing.T) {
+	a, b, c := qty(Equal, 1), qty(Equal, 2), qty(Equal, 3)
+	tests := []struct {
+		filter string
+		want   Expr
+	}{
+		{"", nil},
+		{"qty == 1", a},
+		{"qty == 1 OR qty == 2 AND qty == 3", Or{a, And{b, c}}},
+		{"qty == 1 AND qty == 2 OR qty == 3", Or{And{a, b}, c}},
+		{"(qty == 1 OR qty == 2) AND qty == 3", And{Or{a, b}, c}},
+		{"qty == 1 AND qty == 2 AND qty == 3", And{And{a, b}, c}},
+		{"NOT qty == 1 AND qty == 2", And{Not{a}, b}},
+		{"NOT (qty == 1 AND qty == 2)", Not{And{a, b}}},
+		{"not qty == 1 or qty == 2", Or{Not{a}, b}},
+		{"qty>=1", qty(GreaterOrEqual, 1)},
+		{"`unit cost` < 3", Comparison{Field: "unit cost", Path: "data.unit cost", Op: Less, Value: int64(3)}},
+		{"tags CONTAINS \"a\"", Contains{Field: "tags", Path: "data.tags", Values: []string{"a"}}},
+		{"tags CONTAINS ALL (\"a\", 'b')", Contains{Field: "tags", Path: "data.tags", All: true, Values: []string{"a", "b"}}},
+		{"tags contains any (\"c\")", Contains{Field: "tags", Path: "data.tags", Values: []string{"c"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			got, err := ParseFilter(test.filter, testFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterValues(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		filter string
+		want   interface{}
+	}{
+		{"qty == -5", int64(-5)},
+		{"qty == null", nil},
+		{"price > 1.50", "1.50"}, // Compared as the text of the decimal
+		{"price > 2", "2"},
+		{"price < 1e3", "1000"},
+		{"total >= 0.5", "0.5"},
+		{"name == \"bolt \\\"M8\\\"\"", "bolt \"M8\""},
+		{"name != 'it\\'s'", "it's"},
+		{"size == \"M\"", "M"},
+		{"active == true", true},
+		{"active != false", false},
+		{"createdAt > \"2024-01-02T03:04:05Z\"", created},
+		{"updatedAt < \"2024-01-02\"", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"onHand <= 10", int64(10)},
+		{"tags == null", nil},
+		{"photo != null", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			expr, err := ParseFilter(test.filter, testFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value := expr.(Comparison).Value
+			if d, ok := value.(primitive.Decimal128); ok {
+				value = decimal.Text(d)
+			}
+			if !reflect.DeepEqual(value, test.want) {
+				t.Fatalf("got %#v, want %#v", value, test.want)
+			}
+		})
+	}
+
+	expr, err := ParseFilter("due == \"2024-03-01\"", testFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due, ok := expr.(Comparison).Value.(time.Time); !ok || due.Format("2006-01-02") != "2024-03-01" {
+		t.Fatalf("got %#v", expr.(Comparison).Value)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{"qty = 1", "=="},
+		{"qty == 1.5", "integer"},
+		{"qty == \"1\"", "integer"},
+		{"price == \"1\"", "number"},
+		{"name == bolt", "quoted"},
+		{"active == 1", "true or false"},
+		{"active > true", "== and !="},
+		{"size == \"XL\"", "option"},
+		{"size < \"M\"", "== and !="},
+		{"qty > null", "null"},
+		{"due == \"soon\"", "ISO-8601"},
+		{"createdAt > 5", "RFC 3339"},
+		{"tags == \"a\"", "CONTAINS"},
+		{"tags CONTAINS \"d\"", "option"},
+		{"tags CONTAINS ANY (a)", "quoted"},
+		{"tags CONTAINS (\"a\" \"b\")", "expected , or )"},
+		{"parent CONTAINS \"x\"", "stock IDs"},
+		{"qty CONTAINS \"1\"", "CONTAINS only"},
+		{"photo == \"x\"", "null"},
+		{"unknown == 1", "unknown field"},
+		{"qty == 1 AND", "field name"},
+		{"(qty == 1", "expected )"},
+		{"qty == 1)", "unexpected"},
+		{"qty 1", "operator"},
+		{"name == \"open", "unterminated string"},
+		{"`unit cost == 1", "unterminated field name"},
+		{"qty == 1 & qty == 2", "unexpected character"},
+	}
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			_, err := ParseFilter(test.filter, testFields)
+			if err == nil {
+				t.Fatal("parsed")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got %q, want it to mention %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	price, _ := decimal.Parse("2.50")
+	parent := uuid.New()
+	stock := entity.Stock{
+		Data: map[string]interface{}{
+			"qty":    int64(4),
+			"price":  price,
+			"name":   "bolt",
+			"active": true,
+			"tags":   []string{"a", "b"},
+			"parent": []string{parent.String()},
+		},
+		OnHand: 7,
+	}
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"qty == 4", true},
+		{"qty > 3 AND qty < 5", true},
+		{"price == 2.5", true}, // Decimals compare by value
+		{"price > 2.49", true},
+		{"name >= \"bolt\"", true},
+		{"name < \"a\"", false},
+		{"active == true", true},
+		{"size == null", true}, // Missing values equal null
+		{"size != null", false},
+		{"name == null", false},
+		{"qty > 3 OR qty > 10", true},
+		{"NOT qty == 4", false},
+		{"onHand == 7", true},
+		{"tags CONTAINS \"a\"", true},
+		{"tags CONTAINS ANY (\"c\", \"b\")", true},
+		{"tags CONTAINS ALL (\"a\", \"c\")", false},
+		{"tags CONTAINS ALL (\"a\", \"b\")", true},
+		{"parent CONTAINS \"" + parent.String() + "\"", true},
+		{"due < \"2100-01-01\"", false}, // Ordering never matches a missing value
+	}
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			expr, err := ParseFilter(test.filter, testFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.Match(stock); got != test.match {
+				t.Fatalf("got %v, want %v", got, test.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SortKey orders stocks by one attribute
+type SortKey struct {
+	Field      string
+	Path       string
+	Descending bool
+}
+
+// ParseSort parses a comma separated list of attributes such as
+// `-createdAt,name`, where a leading - sorts in descending order
+func ParseSort(input string, fields []entity.Field) ([]SortKey, error) {
+	var keys []SortKey
+	if strings.TrimSpace(input) == "" {
+		return keys, nil
+	}
+
+	attrs := newAttributes(fields)
+	for _, part := range strings.Split(input, ",") {
+		name := strings.TrimSpace(part)
+		descending := false
+		if strings.HasPrefix(name, "-") {
+			descending = true
+			name = strings.TrimSpace(name[1:])
+		} else if strings.HasPrefix(name, "+") {
+			name = strings.TrimSpace(name[1:])
+		}
+		attribute, ok := attrs.lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %q", name)
+		}
+		if attribute.kind == entity.Multiselect || attribute.kind == entity.Reference || attribute.kind == entity.Attachment {
+			return nil, fmt.Errorf("%s field %q cannot be sorted on", attribute.kind, name)
+		}
+		keys = append(keys, SortKey{Field: name, Path: attribute.path, Descending: descending})
+	}
+	return keys, nil
+}
+
+// StockQuery selects, orders and pages the stocks of a form
+type StockQuery struct {
+	Filter    Expr // nil matches every stock
+	Sort      []SortKey
+	Locations []uuid.UUID // When set, only stocks holding a quantity at one of these locations match
+	Limit     int64       // Zero means no limit
+	Offset    int64
+}
+
+// BSONFilter returns the MongoDB filter selecting the matching stocks of the form
+func (q StockQuery) BSONFilter(formID uuid.UUID) bson.M {
+	conditions := []bson.M{{"formId": formID}}
+	if q.Filter != nil {
+		conditions = append(conditions, q.Filter.BSON())
+	}
+	if q.Locations != nil {
+		// An empty $or is invalid, so start with a condition no stock satisfies
+		atLocation := []bson.M{{"_id": bson.M{"$exists": false}}}
+		for _, locationID := range q.Locations {
+			atLocation = append(atLocation, bson.M{"locations." + locationID.String(): bson.M{"$exists": true, "$ne": 0}})
+		}
+		conditions = append(conditions, bson.M{"$or": atLocation})
+	}
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return bson.M{"$and": conditions}
+}
+
+// defaultSort lists stocks in the order they were created
+var defaultSort = []SortKey{{Field: FieldCreatedAt, Path: FieldCreatedAt}}
+
+func (q StockQuery) sortKeys() []SortKey {
+	if len(q.Sort) == 0 {
+		return defaultSort
+	}
+	return q.Sort
+}
+
+// BSONSort returns the MongoDB sort document. The ID is always used as the
+// last key so pages stay stable when sorted values are equal.
+func (q StockQuery) BSONSort() bson.D {
+	sort := bson.D{}
+	for _, key := range q.sortKeys() {
+		direction := 1
+		if key.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: key.Path, Value: direction})
+	}
+	return append(sort, bson.E{Key: "_id", Value: 1})
+}
+
+// Match evaluates the query conditions against a stock of the form
+func (q StockQuery) Match(stock entity.Stock) bool {
+	if q.Filter != nil && !q.Filter.Match(stock) {
+		return false
+	}
+	if q.Locations != nil {
+		for _, locationID := range q.Locations {
+			if stock.Locations[locationID.String()] != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Less orders two stocks following the sort keys and then their IDs
+func (q StockQuery) Less(a, b entity.Stock) bool {
+	for _, key := range q.sortKeys() {
+		valueA, _ := valueAt(a, key.Path)
+		valueB, _ := valueAt(b, key.Path)
+		order := compareValues(valueA, valueB)
+		if key.Descending {
+			order = -order
+		}
+		if order != 0 {
+			return order < 0
+		}
+	}
+	return a.ID.String() < b.ID.String()
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseSort(t *testing.T) {
+	keys, err := ParseSort(" -qty, +name,createdAt", testFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortKey{
+		{Field: "qty", Path: "data.qty", Descending: true},
+		{Field: "name", Path: "data.name"},
+		{Field: "createdAt", Path: "createdAt"},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %+v", keys)
+	}
+	sort := StockQuery{Sort: keys}.BSONSort()
+	if len(sort) != 4 || sort[0].Value != -1 || sort[3].Key != "_id" {
+		t.Fatalf("got %v, want the ID as the last key", sort)
+	}
+
+	for _, input := range []string{"unknown", "tags", "parent", "photo", "-"} {
+		if _, err := ParseSort(input, testFields); err == nil {
+			t.Errorf("%s: sorted", input)
+		}
+	}
+}
+
+func TestLess(t *testing.T) {
+	q := StockQuery{Sort: []SortKey{{Field: "qty", Path: "data.qty", Descending: true}}}
+	stock := func(qty interface{}) entity.Stock {
+		data := map[string]interface{}{}
+		if qty != nil {
+			data["qty"] = qty
+		}
+		return entity.Stock{ID: uuid.New(), Data: data}
+	}
+	// Missing values sort before numbers, and so last in descending order
+	if !q.Less(stock(int64(2)), stock(1.5)) || !q.Less(stock(int64(1)), stock(nil)) || q.Less(stock(nil), stock(int64(1))) {
+		t.Fatal("descending order")
+	}
+	a, b := stock(int64(1)), stock(int64(1))
+	if q.Less(a, b) == q.Less(b, a) {
+		t.Fatal("equal values are not ordered by ID")
+	}
+}
+
+func TestBSONFilter(t *testing.T) {
+	formID, locationID := uuid.New(), uuid.New()
+	if filter := (StockQuery{}).BSONFilter(formID); !reflect.DeepEqual(filter, bson.M{"formId": formID}) {
+		t.Fatalf("got %v", filter)
+	}
+
+	filter, err := ParseFilter("qty > 1", testFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := StockQuery{Filter: filter, Locations: []uuid.UUID{locationID}}
+	conditions := q.BSONFilter(formID)["$and"].([]bson.M)
+	if len(conditions) != 3 || !reflect.DeepEqual(conditions[1], bson.M{"data.qty": bson.M{"$gt": int64(1)}}) {
+		t.Fatalf("got %v", conditions)
+	}
+
+	located := entity.Stock{Data: map[string]interface{}{"qty": int64(2)}, Locations: map[string]int64{locationID.String(): 1}}
+	if !q.Match(located) {
+		t.Fatal("stock at the location not matched")
+	}
+	located.Locations = nil
+	if q.Match(located) {
+		t.Fatal("stock elsewhere matched")
+	}
+	if (StockQuery{Locations: []uuid.UUID{}}).Match(located) {
+		t.Fatal("an empty list of locations matched")
+	}
+}
@@ -0,0 +1,95 @@
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// valueAt reads the attribute at a document path of a stock
+func valueAt(stock entity.Stock, path string) (interface{}, bool) {
+	switch path {
+	case FieldCreatedAt:
+		return stock.CreatedAt, true
+	case FieldUpdatedAt:
+		return stock.UpdatedAt, true
+	case FieldOnHand:
+		return stock.OnHand, true
+	}
+	if name, ok := strings.CutPrefix(path, "data."); ok {
+		value, exists := stock.Data[name]
+		return value, exists
+	}
+	return nil, false
+}
+
+// typeRank orders values of different types following the BSON comparison order
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case int, int32, int64, float32, float64, primitive.Decimal128:
+		return 1
+	case string:
+		return 2
+	case bool:
+		return 3
+	case time.Time, primitive.DateTime:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// compareValues returns -1, 0 or 1 comparing two values like MongoDB sorts them
+func compareValues(a, b interface{}) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+	if rankA != rankB {
+		return compareInts(rankA, rankB)
+	}
+
+	switch rankA {
+	case 0:
+		return 0
+	case 1:
+		order, _ := decimal.Compare(a, b)
+		return order
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 3:
+		x, y := a.(bool), b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case 4:
+		return toTime(a).Compare(toTime(b))
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func toTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case primitive.DateTime:
+		return v.Time()
+	}
+	return time.Time{}
+}
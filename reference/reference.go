@@ -0,0 +1,362 @@
+// Package reference resolves reference fields, which link the stocks of a form
+// to stocks of another form of the same owner
+package reference
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/query"
+	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	"github.com/kbc0/DynamicStockManager/schema"
+)
+
+var (
+	// ErrInvalid is wrapped by errors about references that do not resolve,
+	// which are problems with the request rather than storage failures
+	ErrInvalid = errors.New("invalid reference")
+	// ErrReferenced is wrapped by errors refusing to delete a stock or form that
+	// is still referenced
+	ErrReferenced = errors.New("still referenced")
+)
+
+type referenceError struct {
+	message string
+	cause   error
+}
+
+func (e referenceError) Error() string { return e.message }
+func (e referenceError) Unwrap() error { return e.cause }
+
+func invalid(message string) error {
+	return referenceError{message: message, cause: ErrInvalid}
+}
+
+func referenced(message string) error {
+	return referenceError{message: message, cause: ErrReferenced}
+}
+
+// Resolver checks, expands and maintains the references between stocks
+type Resolver struct {
+	forms        formRepo.FormStore
+	fields       fieldRepo.FieldStore
+	stocks       stockRepo.StockStore
+	transactions transaction.Transactor
+}
+
+func NewResolver(forms formRepo.FormStore, fields fieldRepo.FieldStore, stocks stockRepo.StockStore, transactions transaction.Transactor) *Resolver {
+	return &Resolver{
+		forms:        forms,
+		fields:       fields,
+		stocks:       stocks,
+		transactions: transactions,
+	}
+}
+
+// CheckTarget checks that the target form of a reference field exists and has
+// the owner of the form of the field
+func (r *Resolver) CheckTarget(field entity.Field) error {
+	_, err := r.target(field)
+	return err
+}
+
+// target returns the target form of a reference field, checking its owner
+func (r *Resolver) target(field entity.Field) (*entity.Form, error) {
+	if field.TargetFormID == nil {
+		return nil, invalid(field.Name + " has no target form")
+	}
+	form, err := r.forms.GetFormByID(field.FormID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := r.forms.GetFormByID(*field.TargetFormID)
+	if err != nil || target.UserID != form.UserID {
+		return nil, invalid("Target form " + field.TargetFormID.String() + " of " + field.Name + " not found")
+	}
+	return target, nil
+}
+
+// Check checks that the stock IDs of a reference value, as returned by
+// schema.ParseValue, are stocks of the target form of the field
+func (r *Resolver) Check(field entity.Field, value interface{}) error {
+	if _, err := r.target(field); err != nil {
+		return err
+	}
+	ids, err := stockIDs(value)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	found, err := r.stocks.GetStocksByIDs(*field.TargetFormID, ids)
+	if err != nil {
+		return err
+	}
+	if len(found) == len(ids) {
+		return nil
+	}
+	existing := make(map[uuid.UUID]bool, len(found))
+	for _, stock := range found {
+		existing[stock.ID] = true
+	}
+	var missing []string
+	for _, id := range ids {
+		if !existing[id] {
+			missing = append(missing, id.String())
+		}
+	}
+	return invalid("Referenced stocks not found in the target form of " + field.Name + ": " + strings.Join(missing, ", "))
+}
+
+// Expand returns copies of the stocks with the values of the named reference
+// fields replaced by the referenced stocks, one level deep. References to
+// stocks that no longer exist are expanded to null.
+func (r *Resolver) Expand(stocks []entity.Stock, fields []entity.Field, names []string) ([]entity.Stock, error) {
+	expanded := make([]entity.Stock, len(stocks))
+	for i, stock := range stocks {
+		stock.Data = copyData(stock.Data)
+		expanded[i] = stock
+	}
+
+	for _, name := range names {
+		field, ok := findField(fields, name)
+		if !ok || field.Type != entity.Reference {
+			return nil, invalid("Cannot expand " + name + ", it is not a reference field")
+		}
+
+		var ids []uuid.UUID
+		for _, stock := range expanded {
+			referencedIDs, _ := stockIDs(stock.Data[field.Name])
+			ids = append(ids, referencedIDs...)
+		}
+		byID := make(map[uuid.UUID]entity.Stock)
+		if _, err := r.target(field); err == nil && len(ids) > 0 {
+			found, err := r.stocks.GetStocksByIDs(*field.TargetFormID, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, stock := range found {
+				byID[stock.ID] = stock
+			}
+		}
+
+		for _, stock := range expanded {
+			referencedIDs, err := stockIDs(stock.Data[field.Name])
+			if err != nil {
+				continue
+			}
+			records := make([]interface{}, len(referencedIDs))
+			for j, id := range referencedIDs {
+				if record, ok := byID[id]; ok {
+					records[j] = record
+				}
+			}
+			stock.Data[field.Name] = records
+		}
+	}
+	return expanded, nil
+}
+
+// CheckFormDeletion refuses to delete a form whose stocks are referenced by
+// reference fields of other forms
+func (r *Resolver) CheckFormDeletion(formID uuid.UUID) error {
+	fields, err := r.fields.GetReferencingFields(formID)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if field.FormID != formID {
+			return referenced("The form is referenced by field " + field.Name + " of form " + field.FormID.String())
+		}
+	}
+	return nil
+}
+
+// Deletion is the outcome of deleting a stock
+type Deletion struct {
+	Trashed []entity.Stock // The stock and the stocks deleted along with it, as they were before
+	Updated []StockUpdate  // Stocks whose references to trashed stocks were removed
+}
+
+// StockUpdate is a stock before and after removing references from its data
+type StockUpdate struct {
+	Before entity.Stock
+	After  entity.Stock
+}
+
+// DeleteStock moves a stock to the trash and applies the on-delete action of
+// every reference field referencing it: cascade moves the referencing stocks
+// to the trash as well, following their own references in turn, nullify
+// removes the reference, and restrict refuses the deletion with an error
+// wrapping ErrReferenced. All changes are made together or not at all. Stocks
+// in the trash keep their references.
+func (r *Resolver) DeleteStock(ctx context.Context, stock entity.Stock, deletedBy uuid.UUID, deletedAt time.Time) (*Deletion, error) {
+	plan := &planner{resolver: r, referencing: make(map[uuid.UUID][]entity.Field), trashed: map[uuid.UUID]bool{stock.ID: true}}
+	deletion, err := plan.run(stock)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range deletion.Updated {
+		after := &deletion.Updated[i].After
+		after.Version++
+		after.UpdatedAt = deletedAt
+	}
+	err = r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, trashed := range deletion.Trashed {
+			if err := r.stocks.TrashStock(ctx, trashed.ID, deletedBy, deletedAt); err != nil {
+				return err
+			}
+		}
+		for _, update := range deletion.Updated {
+			if err := r.stocks.UpdateStock(ctx, update.After); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// planner works out which stocks a deletion trashes and updates
+type planner struct {
+	resolver    *Resolver
+	referencing map[uuid.UUID][]entity.Field // Reference fields by target form
+	trashed     map[uuid.UUID]bool
+}
+
+// edge is a stock referencing a stock being deleted through a field
+type edge struct {
+	field entity.Field
+	from  entity.Stock
+	to    uuid.UUID
+}
+
+func (p *planner) run(stock entity.Stock) (*Deletion, error) {
+	deletion := &Deletion{Trashed: []entity.Stock{stock}, Updated: []StockUpdate{}}
+
+	// Cascades first, so that restrict and nullify only apply to stocks that
+	// are not deleted anyway
+	var edges []edge
+	for i := 0; i < len(deletion.Trashed); i++ {
+		incoming, err := p.incoming(deletion.Trashed[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range incoming {
+			if e.field.OnDelete == entity.OnDeleteCascade && !p.trashed[e.from.ID] {
+				p.trashed[e.from.ID] = true
+				deletion.Trashed = append(deletion.Trashed, e.from)
+			}
+		}
+		edges = append(edges, incoming...)
+	}
+
+	updates := make(map[uuid.UUID]int)
+	for _, e := range edges {
+		if p.trashed[e.from.ID] {
+			continue
+		}
+		switch e.field.OnDelete {
+		case entity.OnDeleteNullify:
+			i, ok := updates[e.from.ID]
+			if !ok {
+				i = len(deletion.Updated)
+				updates[e.from.ID] = i
+				deletion.Updated = append(deletion.Updated, StockUpdate{Before: e.from, After: withData(e.from)})
+			}
+			after := &deletion.Updated[i].After
+			ids, _ := schema.References(after.Data[e.field.Name])
+			after.Data[e.field.Name] = without(ids, e.to.String())
+		case entity.OnDeleteCascade:
+		default:
+			return nil, referenced("Stock " + e.to.String() + " is referenced by stock " + e.from.ID.String() + " through field " + e.field.Name)
+		}
+	}
+	return deletion, nil
+}
+
+// incoming finds the stocks that are not in the trash and reference the stock
+func (p *planner) incoming(stock entity.Stock) ([]edge, error) {
+	fields, ok := p.referencing[stock.FormID]
+	if !ok {
+		var err error
+		if fields, err = p.resolver.fields.GetReferencingFields(stock.FormID); err != nil {
+			return nil, err
+		}
+		p.referencing[stock.FormID] = fields
+	}
+
+	var edges []edge
+	for _, field := range fields {
+		q := query.StockQuery{Filter: query.Contains{Field: field.Name, Path: "data." + field.Name, Values: []string{stock.ID.String()}}}
+		stocks, err := p.resolver.stocks.FindStocks(field.FormID, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, from := range stocks {
+			edges = append(edges, edge{field: field, from: from, to: stock.ID})
+		}
+	}
+	return edges, nil
+}
+
+// stockIDs reads the stock IDs of a reference value
+func stockIDs(value interface{}) ([]uuid.UUID, error) {
+	if value == nil {
+		return nil, nil
+	}
+	references, err := schema.References(value)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, len(references))
+	for i, reference := range references {
+		ids[i] = uuid.MustParse(reference)
+	}
+	return ids, nil
+}
+
+func findField(fields []entity.Field, name string) (entity.Field, bool) {
+	for _, field := range fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return entity.Field{}, false
+}
+
+func without(ids []string, removed string) []string {
+	kept := []string{}
+	for _, id := range ids {
+		if id != removed {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+// withData returns the stock with a copy of its data, safe to change
+func withData(stock entity.Stock) entity.Stock {
+	stock.Data = copyData(stock.Data)
+	return stock
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditFilter selects audit entries, zero values match anything
+type AuditFilter struct {
+	OwnerID    uuid.UUID
+	UserID     uuid.UUID
+	EntityType entity.AuditEntityType
+	EntityID   uuid.UUID
+	FormID     uuid.UUID
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
+}
+
+// Match reports whether the entry is selected by the filter
+func (f AuditFilter) Match(entry entity.AuditEntry) bool {
+	return (f.OwnerID == uuid.Nil || entry.OwnerID == f.OwnerID) &&
+		(f.UserID == uuid.Nil || entry.UserID == f.UserID) &&
+		(f.EntityType == "" || entry.EntityType == f.EntityType) &&
+		(f.EntityID == uuid.Nil || entry.EntityID == f.EntityID) &&
+		(f.FormID == uuid.Nil || entry.FormID == f.FormID) &&
+		(f.From.IsZero() || !entry.CreatedAt.Before(f.From)) &&
+		(f.To.IsZero() || entry.CreatedAt.Before(f.To))
+}
+
+func (f AuditFilter) bson() bson.M {
+	filter := bson.M{}
+	if f.OwnerID != uuid.Nil {
+		filter["ownerId"] = f.OwnerID
+	}
+	if f.UserID != uuid.Nil {
+		filter["userId"] = f.UserID
+	}
+	if f.EntityType != "" {
+		filter["entityType"] = f.EntityType
+	}
+	if f.EntityID != uuid.Nil {
+		filter["entityId"] = f.EntityID
+	}
+	if f.FormID != uuid.Nil {
+		filter["formId"] = f.FormID
+	}
+	createdAt := bson.M{}
+	if !f.From.IsZero() {
+		createdAt["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		createdAt["$lt"] = f.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+	return filter
+}
+
+// AuditStore describes the storage operations available for the audit log,
+// which is append-only
+type AuditStore interface {
+	AppendEntry(entry entity.AuditEntry) error
+	// FindEntries retrieves the entries matching the filter, newest first
+	FindEntries(filter AuditFilter, limit int64, offset int64) ([]entity.AuditEntry, error)
+}
+
+// AuditRepository is the MongoDB backed AuditStore
+type AuditRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAuditRepository(db *mongo.Database) *AuditRepository {
+	// Snapshots are free-form documents, decode them as maps rather than bson.D
+	opts := options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+	return &AuditRepository{
+		collection: db.Collection("audit", opts),
+	}
+}
+
+func (r *AuditRepository) AppendEntry(entry entity.AuditEntry) error {
+	_, err := r.collection.InsertOne(context.Background(), entry)
+	return err
+}
+
+func (r *AuditRepository) FindEntries(filter AuditFilter, limit int64, offset int64) ([]entity.AuditEntry, error) {
+	entries := []entity.AuditEntry{}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := r.collection.Find(context.Background(), filter.bson(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
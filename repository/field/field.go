@@ -3,45 +3,92 @@ package repository
 import (
     "context"
     "errors"
+    "time"
+
     "github.com/google/uuid"
     "github.com/kbc0/DynamicStockManager/entity"
+    stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+    transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+    "github.com/kbc0/DynamicStockManager/utils"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// FieldStore describes the storage operations available for form fields
+type FieldStore interface {
+    CreateField(field entity.Field) error
+    GetFieldsByFormID(formID uuid.UUID) ([]entity.Field, error)
+    GetFieldByID(id uuid.UUID) (*entity.Field, error)
+    // GetReferencingFields lists the reference fields of any form whose stocks
+    // reference stocks of the target form, except the ones in the trash
+    GetReferencingFields(targetFormID uuid.UUID) ([]entity.Field, error)
+    UpdateField(field entity.Field) error
+    // ReplaceField stores the field as given, clearing the attributes it leaves
+    // empty, where UpdateField keeps them. It must carry the next version of the
+    // stored field and keep its name and uniqueness, which claimed values depend on.
+    ReplaceField(ctx context.Context, field entity.Field) error
+    DeleteField(ctx context.Context, id uuid.UUID) error
+    DeleteFieldsByFormID(ctx context.Context, formID uuid.UUID) error
+    // TrashField moves a field to the trash, which hides it from all other reads.
+    // A unique field stays enforced until it is deleted for good.
+    TrashField(id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error
+    // RestoreField takes a field out of the trash
+    RestoreField(id uuid.UUID) error
+    GetTrashedFieldByID(id uuid.UUID) (*entity.Field, error)
+    // GetTrashedFields lists the fields in the trash, most recently deleted
+    // first. A nil formIDs matches every form and a zero before every deletion time.
+    GetTrashedFields(formIDs []uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Field, error)
+}
+
+// FieldRepository is the MongoDB backed FieldStore. It also claims the values
+// stocks hold for a field when it becomes unique, and releases them when it
+// stops being unique.
 type FieldRepository struct {
-    collection *mongo.Collection
+    collection   *mongo.Collection
+    stocks       *mongo.Collection
+    uniqueValues *mongo.Collection
+    transactions *transaction.TransactionRepository
 }
 
 func NewFieldRepository(db *mongo.Database) *FieldRepository {
     return &FieldRepository{
-        collection: db.Collection("fields"),
+        collection:   db.Collection("fields"),
+        stocks:       db.Collection("stocks"),
+        uniqueValues: db.Collection(stockRepo.UniqueValuesCollection),
+        transactions: transaction.NewTransactionRepository(db),
     }
 }
 
 // CreateField inserts a new field into the database, ensuring field name uniqueness within a form
 func (r *FieldRepository) CreateField(field entity.Field) error {
-    // Check for unique field name within the form
+    // Check for unique field name within the form, fields in the trash keep their name
     filter := bson.M{"formId": field.FormID, "name": field.Name}
     count, err := r.collection.CountDocuments(context.TODO(), filter)
     if err != nil {
         return err
     }
     if count > 0 {
-        return errors.New("field name must be unique within the form")
+        return utils.DuplicateError("field name must be unique within the form")
     }
 
-    // Insert the field
-    _, err = r.collection.InsertOne(context.TODO(), field)
-    return err
+    // Insert the field, a unique one along with the values stocks already hold
+    return r.transactions.WithTransaction(context.TODO(), func(ctx context.Context) error {
+        if _, err := r.collection.InsertOne(ctx, field); err != nil {
+            return utils.MapDuplicateKey(err, "field name must be unique within the form")
+        }
+        if field.IsUnique {
+            return stockRepo.ClaimUniqueValues(ctx, r.stocks, r.uniqueValues, field, field.Name)
+        }
+        return nil
+    })
 }
 
 // GetFieldsByFormID retrieves all fields for a specific form, sorted by the field order
 func (r *FieldRepository) GetFieldsByFormID(formID uuid.UUID) ([]entity.Field, error) {
     var fields []entity.Field
     opts := options.Find().SetSort(bson.M{"order": 1}) // Sort by field order
-    cursor, err := r.collection.Find(context.TODO(), bson.M{"formId": formID}, opts)
+    cursor, err := r.collection.Find(context.TODO(), utils.NotDeleted(bson.M{"formId": formID}), opts)
     if err != nil {
         return nil, err
     }
@@ -59,36 +106,139 @@ func (r *FieldRepository) GetFieldsByFormID(formID uuid.UUID) ([]entity.Field, e
 // GetFieldByID retrieves a single field by ID
 func (r *FieldRepository) GetFieldByID(id uuid.UUID) (*entity.Field, error) {
     var field entity.Field
-    if err := r.collection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&field); err != nil {
+    if err := r.collection.FindOne(context.TODO(), utils.NotDeleted(bson.M{"_id": id})).Decode(&field); err != nil {
         return nil, err
     }
     return &field, nil
 }
 
-// UpdateField updates an existing field
+func (r *FieldRepository) GetReferencingFields(targetFormID uuid.UUID) ([]entity.Field, error) {
+    var fields []entity.Field
+    cursor, err := r.collection.Find(context.TODO(), utils.NotDeleted(bson.M{"type": entity.Reference, "targetFormId": targetFormID}))
+    if err != nil {
+        return nil, err
+    }
+    if err := cursor.All(context.TODO(), &fields); err != nil {
+        return nil, err
+    }
+    return fields, nil
+}
+
+// UpdateField stores the field, which must carry the next version of the stored
+// one, and claims the values of its stocks when it becomes unique or releases
+// them when it stops being unique
 func (r *FieldRepository) UpdateField(field entity.Field) error {
-    result, err := r.collection.UpdateOne(
-        context.TODO(),
-        bson.M{"_id": field.ID},
-        bson.M{"$set": field},
-    )
+    previous, err := r.GetFieldByID(field.ID)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return errors.New("no changes applied or field not found")
+        }
+        return err
+    }
+
+    return r.transactions.WithTransaction(context.TODO(), func(ctx context.Context) error {
+        result, err := r.collection.UpdateOne(
+            ctx,
+            bson.M{"_id": field.ID, "version": utils.VersionFilter(field.Version - 1)},
+            bson.M{"$set": field},
+        )
+        if err == nil && result.MatchedCount == 0 {
+            err = utils.ErrVersionConflict
+        }
+        if err != nil {
+            return utils.MapDuplicateKey(err, "field name must be unique within the form")
+        }
+
+        switch {
+        case field.IsUnique && !previous.IsUnique:
+            // The job renaming the stock data of a renamed field has not run yet
+            return stockRepo.ClaimUniqueValues(ctx, r.stocks, r.uniqueValues, field, previous.Name)
+        case previous.IsUnique && !field.IsUnique:
+            _, err := r.uniqueValues.DeleteMany(ctx, bson.M{"fieldId": field.ID})
+            return err
+        }
+        return nil
+    })
+}
+
+func (r *FieldRepository) ReplaceField(ctx context.Context, field entity.Field) error {
+    filter := utils.NotDeleted(bson.M{"_id": field.ID, "version": utils.VersionFilter(field.Version - 1)})
+    result, err := r.collection.ReplaceOne(ctx, filter, field)
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return utils.ErrVersionConflict
+    }
+    return nil
+}
+
+// DeleteField deletes a field along with the values claimed for a unique field
+func (r *FieldRepository) DeleteField(ctx context.Context, id uuid.UUID) error {
+    return r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+        if _, err := r.collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+            return err
+        }
+        _, err := r.uniqueValues.DeleteMany(ctx, bson.M{"fieldId": id})
+        return err
+    })
+}
+
+// DeleteFieldsByFormID deletes all fields associated with a specific form ID,
+// along with the values claimed for its unique fields
+func (r *FieldRepository) DeleteFieldsByFormID(ctx context.Context, formID uuid.UUID) error {
+    return r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+        if _, err := r.collection.DeleteMany(ctx, bson.M{"formId": formID}); err != nil {
+            return err
+        }
+        _, err := r.uniqueValues.DeleteMany(ctx, bson.M{"formId": formID})
+        return err
+    })
+}
+
+func (r *FieldRepository) TrashField(id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error {
+    result, err := r.collection.UpdateOne(context.TODO(), utils.NotDeleted(bson.M{"_id": id}), utils.TrashUpdate(deletedBy, deletedAt))
     if err != nil {
         return err
     }
-    if result.ModifiedCount == 0 {
-        return errors.New("no changes applied or field not found")
+    if result.MatchedCount == 0 {
+        return mongo.ErrNoDocuments
     }
     return nil
 }
 
-// DeleteField deletes a field
-func (r *FieldRepository) DeleteField(id uuid.UUID) error {
-    _, err := r.collection.DeleteOne(context.TODO(), bson.M{"_id": id})
-    return err
+func (r *FieldRepository) RestoreField(id uuid.UUID) error {
+    result, err := r.collection.UpdateOne(context.TODO(), utils.InTrash(bson.M{"_id": id}, time.Time{}), utils.RestoreUpdate())
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return mongo.ErrNoDocuments
+    }
+    return nil
+}
+
+func (r *FieldRepository) GetTrashedFieldByID(id uuid.UUID) (*entity.Field, error) {
+    var field entity.Field
+    if err := r.collection.FindOne(context.TODO(), utils.InTrash(bson.M{"_id": id}, time.Time{})).Decode(&field); err != nil {
+        return nil, err
+    }
+    return &field, nil
 }
 
-// DeleteFieldsByFormID deletes all fields associated with a specific form ID
-func (r *FieldRepository) DeleteFieldsByFormID(formID uuid.UUID) error {
-    _, err := r.collection.DeleteMany(context.TODO(), bson.M{"formId": formID})
-    return err
+func (r *FieldRepository) GetTrashedFields(formIDs []uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Field, error) {
+    filter := bson.M{}
+    if formIDs != nil {
+        filter["formId"] = bson.M{"$in": formIDs}
+    }
+    opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}, {Key: "_id", Value: 1}}).SetLimit(limit).SetSkip(offset)
+    cursor, err := r.collection.Find(context.TODO(), utils.InTrash(filter, before), opts)
+    if err != nil {
+        return nil, err
+    }
+    fields := []entity.Field{}
+    if err := cursor.All(context.TODO(), &fields); err != nil {
+        return nil, err
+    }
+    return fields, nil
 }
@@ -3,14 +3,34 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// FormStore describes the storage operations available for forms
+type FormStore interface {
+	CreateForm(form entity.Form) error
+	GetFormsByUserID(userID uuid.UUID, limit int64, offset int64) ([]entity.Form, error)
+	GetFormByID(id uuid.UUID) (*entity.Form, error)
+	UpdateForm(form entity.Form) error
+	DeleteForm(ctx context.Context, id uuid.UUID) error
+	// TrashForm moves a form to the trash, which hides it from all other reads
+	TrashForm(id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error
+	// RestoreForm takes a form out of the trash
+	RestoreForm(id uuid.UUID) error
+	GetTrashedFormByID(id uuid.UUID) (*entity.Form, error)
+	// GetTrashedForms lists the forms in the trash, most recently deleted first.
+	// A nil userID matches every user and a zero before every deletion time.
+	GetTrashedForms(userID uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Form, error)
+}
+
+// FormRepository is the MongoDB backed FormStore
 type FormRepository struct {
 	collection *mongo.Collection
 }
@@ -23,26 +43,26 @@ func NewFormRepository(db *mongo.Database) *FormRepository {
 
 // CreateForm inserts a new form into the database, ensuring the form name is unique per user
 func (r *FormRepository) CreateForm(form entity.Form) error {
-	// Check for unique form name for the user
+	// Check for unique form name for the user, forms in the trash keep their name
 	filter := bson.M{"userId": form.UserID, "name": form.Name}
 	count, err := r.collection.CountDocuments(context.TODO(), filter)
 	if err != nil {
 		return err
 	}
 	if count > 0 {
-		return errors.New("form name must be unique per user")
+		return utils.DuplicateError("form name must be unique per user")
 	}
 
-	// Insert the form
+	// Insert the form, the unique index catches a concurrent insert of the same name
 	_, err = r.collection.InsertOne(context.TODO(), form)
-	return err
+	return utils.MapDuplicateKey(err, "form name must be unique per user")
 }
 
 // GetFormsByUserID retrieves all forms for a specific user
 func (r *FormRepository) GetFormsByUserID(userID uuid.UUID, limit int64, offset int64) ([]entity.Form, error) {
 	var forms []entity.Form
 	opts := options.Find().SetLimit(limit).SetSkip(offset)
-	cursor, err := r.collection.Find(context.TODO(), bson.M{"userId": userID}, opts)
+	cursor, err := r.collection.Find(context.TODO(), utils.NotDeleted(bson.M{"userId": userID}), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -60,26 +80,81 @@ func (r *FormRepository) GetFormsByUserID(userID uuid.UUID, limit int64, offset
 // GetFormByID retrieves a single form by ID
 func (r *FormRepository) GetFormByID(id uuid.UUID) (*entity.Form, error) {
 	var form entity.Form
-	if err := r.collection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&form); err != nil {
+	if err := r.collection.FindOne(context.TODO(), utils.NotDeleted(bson.M{"_id": id})).Decode(&form); err != nil {
 		return nil, err
 	}
 	return &form, nil
 }
 
-// UpdateForm updates an existing form
+// UpdateForm stores the form, which must carry the next version of the stored one
 func (r *FormRepository) UpdateForm(form entity.Form) error {
-	result, err := r.collection.UpdateOne(context.TODO(), bson.M{"_id": form.ID}, bson.M{"$set": form})
+	filter := bson.M{"_id": form.ID, "version": utils.VersionFilter(form.Version - 1)}
+	result, err := r.collection.UpdateOne(context.TODO(), filter, bson.M{"$set": form})
 	if err != nil {
-		return err
+		return utils.MapDuplicateKey(err, "form name must be unique per user")
 	}
-	if result.ModifiedCount == 0 {
+	if result.MatchedCount == 0 {
+		count, err := r.collection.CountDocuments(context.TODO(), bson.M{"_id": form.ID})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return utils.ErrVersionConflict
+		}
 		return errors.New("no changes applied or form not found")
 	}
 	return nil
 }
 
 // DeleteForm deletes a form
-func (r *FormRepository) DeleteForm(id uuid.UUID) error {
-	_, err := r.collection.DeleteOne(context.TODO(), bson.M{"_id": id})
+func (r *FormRepository) DeleteForm(ctx context.Context, id uuid.UUID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	return err
 }
+
+func (r *FormRepository) TrashForm(id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error {
+	result, err := r.collection.UpdateOne(context.TODO(), utils.NotDeleted(bson.M{"_id": id}), utils.TrashUpdate(deletedBy, deletedAt))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *FormRepository) RestoreForm(id uuid.UUID) error {
+	result, err := r.collection.UpdateOne(context.TODO(), utils.InTrash(bson.M{"_id": id}, time.Time{}), utils.RestoreUpdate())
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *FormRepository) GetTrashedFormByID(id uuid.UUID) (*entity.Form, error) {
+	var form entity.Form
+	if err := r.collection.FindOne(context.TODO(), utils.InTrash(bson.M{"_id": id}, time.Time{})).Decode(&form); err != nil {
+		return nil, err
+	}
+	return &form, nil
+}
+
+func (r *FormRepository) GetTrashedForms(userID uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Form, error) {
+	filter := bson.M{}
+	if userID != uuid.Nil {
+		filter["userId"] = userID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}, {Key: "_id", Value: 1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := r.collection.Find(context.TODO(), utils.InTrash(filter, before), opts)
+	if err != nil {
+		return nil, err
+	}
+	forms := []entity.Form{}
+	if err := cursor.All(context.TODO(), &forms); err != nil {
+		return nil, err
+	}
+	return forms, nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobStore describes the storage operations available for background jobs
+type JobStore interface {
+	CreateJob(job entity.Job) error
+	GetJobByID(id uuid.UUID) (*entity.Job, error)
+	// GetJobsByFormID retrieves the jobs of a form, newest first
+	GetJobsByFormID(formID uuid.UUID, limit int64, offset int64) ([]entity.Job, error)
+	// GetUnfinishedJobs retrieves the pending and running jobs of every form,
+	// oldest first
+	GetUnfinishedJobs() ([]entity.Job, error)
+	// UpdateJob replaces the stored job, which records its status and progress
+	UpdateJob(job entity.Job) error
+}
+
+// JobRepository is the MongoDB backed JobStore
+type JobRepository struct {
+	collection *mongo.Collection
+}
+
+func NewJobRepository(db *mongo.Database) *JobRepository {
+	return &JobRepository{
+		collection: db.Collection("jobs"),
+	}
+}
+
+func (r *JobRepository) CreateJob(job entity.Job) error {
+	_, err := r.collection.InsertOne(context.Background(), job)
+	return err
+}
+
+func (r *JobRepository) GetJobByID(id uuid.UUID) (*entity.Job, error) {
+	var job entity.Job
+	if err := r.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *JobRepository) GetJobsByFormID(formID uuid.UUID, limit int64, offset int64) ([]entity.Job, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit).SetSkip(offset)
+	return r.find(bson.M{"formId": formID}, opts)
+}
+
+func (r *JobRepository) GetUnfinishedJobs() ([]entity.Job, error) {
+	filter := bson.M{"status": bson.M{"$in": []entity.JobStatus{entity.JobPending, entity.JobRunning}}}
+	return r.find(filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+}
+
+func (r *JobRepository) UpdateJob(job entity.Job) error {
+	result, err := r.collection.ReplaceOne(context.Background(), bson.M{"_id": job.ID}, job)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *JobRepository) find(filter bson.M, opts *options.FindOptions) ([]entity.Job, error) {
+	jobs := []entity.Job{}
+	cursor, err := r.collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LocationStore describes the storage operations available for locations
+type LocationStore interface {
+	CreateLocation(location entity.Location) error
+	GetLocationByID(id uuid.UUID) (*entity.Location, error)
+	GetLocationsByUserID(userID uuid.UUID, limit int64, offset int64) ([]entity.Location, error)
+	// GetLocationSubtree returns the location with the given ID followed by all of its descendants
+	GetLocationSubtree(id uuid.UUID) ([]entity.Location, error)
+	UpdateLocation(location entity.Location) error
+	DeleteLocation(id uuid.UUID) error
+}
+
+// LocationRepository is the MongoDB backed LocationStore
+type LocationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewLocationRepository(db *mongo.Database) *LocationRepository {
+	return &LocationRepository{
+		collection: db.Collection("locations"),
+	}
+}
+
+// CreateLocation inserts a new location, ensuring its name is unique among its siblings
+func (r *LocationRepository) CreateLocation(location entity.Location) error {
+	filter := bson.M{"userId": location.UserID, "parentId": location.ParentID, "name": location.Name}
+	count, err := r.collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("location name must be unique within its parent")
+	}
+
+	_, err = r.collection.InsertOne(context.TODO(), location)
+	return err
+}
+
+// GetLocationByID retrieves a single location by ID
+func (r *LocationRepository) GetLocationByID(id uuid.UUID) (*entity.Location, error) {
+	var location entity.Location
+	if err := r.collection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&location); err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// GetLocationsByUserID retrieves all locations of a user
+func (r *LocationRepository) GetLocationsByUserID(userID uuid.UUID, limit int64, offset int64) ([]entity.Location, error) {
+	opts := options.Find().SetLimit(limit).SetSkip(offset)
+	return r.find(bson.M{"userId": userID}, opts)
+}
+
+// GetLocationSubtree relies on the materialized ancestor path to find every descendant at once
+func (r *LocationRepository) GetLocationSubtree(id uuid.UUID) ([]entity.Location, error) {
+	root, err := r.GetLocationByID(id)
+	if err != nil {
+		return nil, err
+	}
+	descendants, err := r.find(bson.M{"path": id}, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	return append([]entity.Location{*root}, descendants...), nil
+}
+
+// UpdateLocation updates an existing location
+func (r *LocationRepository) UpdateLocation(location entity.Location) error {
+	filter := bson.M{
+		"userId":   location.UserID,
+		"parentId": location.ParentID,
+		"name":     location.Name,
+		"_id":      bson.M{"$ne": location.ID},
+	}
+	count, err := r.collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("location name must be unique within its parent")
+	}
+
+	result, err := r.collection.UpdateOne(context.TODO(), bson.M{"_id": location.ID}, bson.M{"$set": location})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteLocation deletes a location
+func (r *LocationRepository) DeleteLocation(id uuid.UUID) error {
+	_, err := r.collection.DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+func (r *LocationRepository) find(filter bson.M, opts *options.FindOptions) ([]entity.Location, error) {
+	var locations []entity.Location
+	cursor, err := r.collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var location entity.Location
+		if err := cursor.Decode(&location); err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	return locations, cursor.Err()
+}
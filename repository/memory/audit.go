@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"github.com/kbc0/DynamicStockManager/entity"
+	auditRepo "github.com/kbc0/DynamicStockManager/repository/audit"
+)
+
+// AuditRepository is the in-memory AuditStore
+type AuditRepository struct {
+	store *Store
+}
+
+func NewAuditRepository(store *Store) *AuditRepository {
+	return &AuditRepository{store: store}
+}
+
+func (r *AuditRepository) AppendEntry(entry entity.AuditEntry) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	entry.Before = cloneData(entry.Before)
+	entry.After = cloneData(entry.After)
+	r.store.audit = append(r.store.audit, entry)
+	return nil
+}
+
+// FindEntries walks the log backwards, which is newest first as entries are appended
+func (r *AuditRepository) FindEntries(filter auditRepo.AuditFilter, limit int64, offset int64) ([]entity.AuditEntry, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var entries []entity.AuditEntry
+	for i := len(r.store.audit) - 1; i >= 0; i-- {
+		entry := r.store.audit[i]
+		if filter.Match(entry) {
+			entry.Before = cloneData(entry.Before)
+			entry.After = cloneData(entry.After)
+			entries = append(entries, entry)
+		}
+	}
+	if entries = paginate(entries, limit, offset); entries == nil {
+		entries = []entity.AuditEntry{}
+	}
+	return entries, nil
+}
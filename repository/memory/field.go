@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FieldRepository is the in-memory FieldStore
+type FieldRepository struct {
+	store *Store
+}
+
+func NewFieldRepository(store *Store) *FieldRepository {
+	return &FieldRepository{store: store}
+}
+
+// CreateField inserts a new field, ensuring field name uniqueness within a form
+func (r *FieldRepository) CreateField(field entity.Field) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	duplicate := false
+	r.store.fields.each(func(existing entity.Field) {
+		if existing.FormID == field.FormID && existing.Name == field.Name {
+			duplicate = true
+		}
+	})
+	if duplicate {
+		return utils.DuplicateError("field name must be unique within the form")
+	}
+	if _, exists := r.store.fields.get(field.ID); exists {
+		return errors.New("field already exists")
+	}
+	if field.IsUnique && r.store.duplicateValues(field.FormID, field.Name) {
+		return utils.DuplicateError("Existing stocks hold duplicate values for " + field.Name)
+	}
+
+	r.store.fields.put(field.ID, cloneField(field))
+	return nil
+}
+
+// GetFieldsByFormID retrieves all fields for a specific form, sorted by the field order
+func (r *FieldRepository) GetFieldsByFormID(formID uuid.UUID) ([]entity.Field, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var fields []entity.Field
+	r.store.fields.each(func(field entity.Field) {
+		if field.FormID == formID && field.DeletedAt == nil {
+			fields = append(fields, cloneField(field))
+		}
+	})
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].Order < fields[j].Order
+	})
+	return fields, nil
+}
+
+// GetFieldByID retrieves a single field by ID
+func (r *FieldRepository) GetFieldByID(id uuid.UUID) (*entity.Field, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	field, ok := r.store.fields.get(id)
+	if !ok || field.DeletedAt != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	field = cloneField(field)
+	return &field, nil
+}
+
+func (r *FieldRepository) GetReferencingFields(targetFormID uuid.UUID) ([]entity.Field, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var fields []entity.Field
+	r.store.fields.each(func(field entity.Field) {
+		if field.Type == entity.Reference && field.TargetFormID != nil && *field.TargetFormID == targetFormID && field.DeletedAt == nil {
+			fields = append(fields, cloneField(field))
+		}
+	})
+	return fields, nil
+}
+
+// UpdateField updates an existing field. Like a MongoDB $set of the struct,
+// empty optional attributes keep their stored values.
+func (r *FieldRepository) UpdateField(field entity.Field) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.fields.get(field.ID)
+	if !ok {
+		return errors.New("no changes applied or field not found")
+	}
+	if existing.Version != field.Version-1 {
+		return utils.ErrVersionConflict
+	}
+
+	updated := cloneField(field)
+	if len(updated.Options) == 0 {
+		updated.Options = existing.Options
+	}
+	if updated.MinValue == nil {
+		updated.MinValue = existing.MinValue
+	}
+	if updated.MaxValue == nil {
+		updated.MaxValue = existing.MaxValue
+	}
+	if updated.DefaultValue == nil {
+		updated.DefaultValue = existing.DefaultValue
+	}
+	if updated.DeletedAt == nil {
+		updated.DeletedAt, updated.DeletedBy = existing.DeletedAt, existing.DeletedBy
+	}
+	if reflect.DeepEqual(existing, updated) {
+		return errors.New("no changes applied or field not found")
+	}
+
+	renamed := existing.Name != updated.Name
+	if renamed {
+		duplicate := false
+		r.store.fields.each(func(other entity.Field) {
+			if other.FormID == updated.FormID && other.ID != updated.ID && other.Name == updated.Name {
+				duplicate = true
+			}
+		})
+		if duplicate {
+			return utils.DuplicateError("field name must be unique within the form")
+		}
+	}
+	if updated.IsUnique && (!existing.IsUnique || renamed) && r.store.duplicateValues(updated.FormID, updated.Name) {
+		return utils.DuplicateError("Existing stocks hold duplicate values for " + updated.Name)
+	}
+
+	r.store.fields.put(field.ID, updated)
+	return nil
+}
+
+func (r *FieldRepository) ReplaceField(ctx context.Context, field entity.Field) error {
+	defer r.store.lock(ctx)()
+
+	existing, ok := r.store.fields.get(field.ID)
+	// Like the MongoDB filter, a missing field is reported as a conflict
+	if !ok || existing.DeletedAt != nil || existing.Version != field.Version-1 {
+		return utils.ErrVersionConflict
+	}
+	r.store.fields.put(field.ID, cloneField(field))
+	return nil
+}
+
+// DeleteField deletes a field
+func (r *FieldRepository) DeleteField(ctx context.Context, id uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.fields.remove(id)
+	return nil
+}
+
+// DeleteFieldsByFormID deletes all fields associated with a specific form ID
+func (r *FieldRepository) DeleteFieldsByFormID(ctx context.Context, formID uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.fields.removeWhere(func(field entity.Field) bool {
+		return field.FormID == formID
+	})
+	return nil
+}
+
+func (r *FieldRepository) TrashField(id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	field, ok := r.store.fields.get(id)
+	if !ok || field.DeletedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+	field = cloneField(field)
+	field.DeletedAt, field.DeletedBy = &deletedAt, &deletedBy
+	field.Version++
+	r.store.fields.put(id, field)
+	return nil
+}
+
+func (r *FieldRepository) RestoreField(id uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	field, ok := r.store.fields.get(id)
+	if !ok || field.DeletedAt == nil {
+		return mongo.ErrNoDocuments
+	}
+	field = cloneField(field)
+	field.DeletedAt, field.DeletedBy = nil, nil
+	field.Version++
+	r.store.fields.put(id, field)
+	return nil
+}
+
+func (r *FieldRepository) GetTrashedFieldByID(id uuid.UUID) (*entity.Field, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	field, ok := r.store.fields.get(id)
+	if !ok || field.DeletedAt == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	field = cloneField(field)
+	return &field, nil
+}
+
+func (r *FieldRepository) GetTrashedFields(formIDs []uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Field, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var fields []entity.Field
+	r.store.fields.each(func(field entity.Field) {
+		if containsID(formIDs, field.FormID) && inTrash(field.DeletedAt, before) {
+			fields = append(fields, cloneField(field))
+		}
+	})
+	return trashPage(fields, func(field entity.Field) time.Time { return *field.DeletedAt }, limit, offset), nil
+}
+
+// cloneField copies the reference typed attributes of a field
+func cloneField(field entity.Field) entity.Field {
+	if field.Options != nil {
+		field.Options = append([]string(nil), field.Options...)
+	}
+	if field.MinValue != nil {
+		minValue := *field.MinValue
+		field.MinValue = &minValue
+	}
+	if field.MaxValue != nil {
+		maxValue := *field.MaxValue
+		field.MaxValue = &maxValue
+	}
+	if field.TargetFormID != nil {
+		targetFormID := *field.TargetFormID
+		field.TargetFormID = &targetFormID
+	}
+	field.DefaultValue = cloneValue(field.DefaultValue)
+	return field
+}
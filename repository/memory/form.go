@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FormRepository is the in-memory FormStore
+type FormRepository struct {
+	store *Store
+}
+
+func NewFormRepository(store *Store) *FormRepository {
+	return &FormRepository{store: store}
+}
+
+// CreateForm inserts a new form, ensuring the form name is unique per user
+func (r *FormRepository) CreateForm(form entity.Form) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	duplicate := false
+	r.store.forms.each(func(existing entity.Form) {
+		if existing.UserID == form.UserID && existing.Name == form.Name {
+			duplicate = true
+		}
+	})
+	if duplicate {
+		return utils.DuplicateError("form name must be unique per user")
+	}
+	if _, exists := r.store.forms.get(form.ID); exists {
+		return errors.New("form already exists")
+	}
+
+	r.store.forms.put(form.ID, form)
+	return nil
+}
+
+// GetFormsByUserID retrieves all forms for a specific user
+func (r *FormRepository) GetFormsByUserID(userID uuid.UUID, limit int64, offset int64) ([]entity.Form, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var forms []entity.Form
+	r.store.forms.each(func(form entity.Form) {
+		if form.UserID == userID && form.DeletedAt == nil {
+			forms = append(forms, form)
+		}
+	})
+	return paginate(forms, limit, offset), nil
+}
+
+// GetFormByID retrieves a single form by ID
+func (r *FormRepository) GetFormByID(id uuid.UUID) (*entity.Form, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	form, ok := r.store.forms.get(id)
+	if !ok || form.DeletedAt != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &form, nil
+}
+
+// UpdateForm updates an existing form
+func (r *FormRepository) UpdateForm(form entity.Form) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.forms.get(form.ID)
+	if !ok {
+		return errors.New("no changes applied or form not found")
+	}
+	if existing.Version != form.Version-1 {
+		return utils.ErrVersionConflict
+	}
+	duplicate := false
+	r.store.forms.each(func(other entity.Form) {
+		if other.ID != form.ID && other.UserID == form.UserID && other.Name == form.Name {
+			duplicate = true
+		}
+	})
+	if duplicate {
+		return utils.DuplicateError("form name must be unique per user")
+	}
+	form.DeletedAt, form.DeletedBy = existing.DeletedAt, existing.DeletedBy
+	r.store.forms.put(form.ID, form)
+	return nil
+}
+
+// DeleteForm deletes a form
+func (r *FormRepository) DeleteForm(ctx context.Context, id uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.forms.remove(id)
+	return nil
+}
+
+func (r *FormRepository) TrashForm(id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	form, ok := r.store.forms.get(id)
+	if !ok || form.DeletedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+	form.DeletedAt, form.DeletedBy = &deletedAt, &deletedBy
+	form.Version++
+	r.store.forms.put(id, form)
+	return nil
+}
+
+func (r *FormRepository) RestoreForm(id uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	form, ok := r.store.forms.get(id)
+	if !ok || form.DeletedAt == nil {
+		return mongo.ErrNoDocuments
+	}
+	form.DeletedAt, form.DeletedBy = nil, nil
+	form.Version++
+	r.store.forms.put(id, form)
+	return nil
+}
+
+func (r *FormRepository) GetTrashedFormByID(id uuid.UUID) (*entity.Form, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	form, ok := r.store.forms.get(id)
+	if !ok || form.DeletedAt == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &form, nil
+}
+
+func (r *FormRepository) GetTrashedForms(userID uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Form, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var forms []entity.Form
+	r.store.forms.each(func(form entity.Form) {
+		if (userID == uuid.Nil || form.UserID == userID) && inTrash(form.DeletedAt, before) {
+			forms = append(forms, form)
+		}
+	})
+	return trashPage(forms, func(form entity.Form) time.Time { return *form.DeletedAt }, limit, offset), nil
+}
+
+// paginate applies MongoDB style skip and limit options, where a zero limit
+// means no limit and a negative limit behaves like its absolute value
+func paginate[T any](docs []T, limit int64, offset int64) []T {
+	if offset > 0 {
+		if offset >= int64(len(docs)) {
+			return nil
+		}
+		docs = docs[offset:]
+	}
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+	return docs
+}
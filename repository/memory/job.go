@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JobRepository is the in-memory JobStore
+type JobRepository struct {
+	store *Store
+}
+
+func NewJobRepository(store *Store) *JobRepository {
+	return &JobRepository{store: store}
+}
+
+func (r *JobRepository) CreateJob(job entity.Job) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.jobs.put(job.ID, job)
+	return nil
+}
+
+func (r *JobRepository) GetJobByID(id uuid.UUID) (*entity.Job, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	job, ok := r.store.jobs.get(id)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &job, nil
+}
+
+func (r *JobRepository) GetJobsByFormID(formID uuid.UUID, limit int64, offset int64) ([]entity.Job, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var jobs []entity.Job
+	r.store.jobs.each(func(job entity.Job) {
+		if job.FormID == formID {
+			jobs = append(jobs, job)
+		}
+	})
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	if jobs = paginate(jobs, limit, offset); jobs == nil {
+		jobs = []entity.Job{}
+	}
+	return jobs, nil
+}
+
+func (r *JobRepository) GetUnfinishedJobs() ([]entity.Job, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	jobs := []entity.Job{}
+	r.store.jobs.each(func(job entity.Job) {
+		if !job.Finished() {
+			jobs = append(jobs, job)
+		}
+	})
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+func (r *JobRepository) UpdateJob(job entity.Job) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.jobs.get(job.ID); !ok {
+		return mongo.ErrNoDocuments
+	}
+	r.store.jobs.put(job.ID, job)
+	return nil
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LocationRepository is the in-memory LocationStore
+type LocationRepository struct {
+	store *Store
+}
+
+func NewLocationRepository(store *Store) *LocationRepository {
+	return &LocationRepository{store: store}
+}
+
+// CreateLocation inserts a new location, ensuring its name is unique among its siblings
+func (r *LocationRepository) CreateLocation(location entity.Location) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if r.hasSibling(location) {
+		return errors.New("location name must be unique within its parent")
+	}
+	if _, exists := r.store.locations.get(location.ID); exists {
+		return errors.New("location already exists")
+	}
+	r.store.locations.put(location.ID, cloneLocation(location))
+	return nil
+}
+
+// GetLocationByID retrieves a single location by ID
+func (r *LocationRepository) GetLocationByID(id uuid.UUID) (*entity.Location, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	location, ok := r.store.locations.get(id)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	location = cloneLocation(location)
+	return &location, nil
+}
+
+// GetLocationsByUserID retrieves all locations of a user
+func (r *LocationRepository) GetLocationsByUserID(userID uuid.UUID, limit int64, offset int64) ([]entity.Location, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var locations []entity.Location
+	r.store.locations.each(func(location entity.Location) {
+		if location.UserID == userID {
+			locations = append(locations, cloneLocation(location))
+		}
+	})
+	return paginate(locations, limit, offset), nil
+}
+
+// GetLocationSubtree returns the location followed by all of its descendants
+func (r *LocationRepository) GetLocationSubtree(id uuid.UUID) ([]entity.Location, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	root, ok := r.store.locations.get(id)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	locations := []entity.Location{cloneLocation(root)}
+	r.store.locations.each(func(location entity.Location) {
+		for _, ancestor := range location.Path {
+			if ancestor == id {
+				locations = append(locations, cloneLocation(location))
+				return
+			}
+		}
+	})
+	return locations, nil
+}
+
+// UpdateLocation updates an existing location
+func (r *LocationRepository) UpdateLocation(location entity.Location) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.locations.get(location.ID); !ok {
+		return mongo.ErrNoDocuments
+	}
+	if r.hasSibling(location) {
+		return errors.New("location name must be unique within its parent")
+	}
+	r.store.locations.put(location.ID, cloneLocation(location))
+	return nil
+}
+
+// DeleteLocation deletes a location
+func (r *LocationRepository) DeleteLocation(id uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.locations.remove(id)
+	return nil
+}
+
+// hasSibling reports whether another location of the same parent uses the name
+func (r *LocationRepository) hasSibling(location entity.Location) bool {
+	found := false
+	r.store.locations.each(func(existing entity.Location) {
+		if existing.ID != location.ID && existing.UserID == location.UserID &&
+			sameParent(existing.ParentID, location.ParentID) && existing.Name == location.Name {
+			found = true
+		}
+	})
+	return found
+}
+
+func sameParent(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func cloneLocation(location entity.Location) entity.Location {
+	if location.ParentID != nil {
+		parentID := *location.ParentID
+		location.ParentID = &parentID
+	}
+	location.Path = append([]uuid.UUID{}, location.Path...)
+	return location
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	auditRepo "github.com/kbc0/DynamicStockManager/repository/audit"
+	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	jobRepo "github.com/kbc0/DynamicStockManager/repository/job"
+	locationRepo "github.com/kbc0/DynamicStockManager/repository/location"
+	movementRepo "github.com/kbc0/DynamicStockManager/repository/movement"
+	revisionRepo "github.com/kbc0/DynamicStockManager/repository/revision"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	userRepo "github.com/kbc0/DynamicStockManager/repository/user"
+	"github.com/kbc0/DynamicStockManager/utils"
+)
+
+// The in-memory repositories must stay interchangeable with the MongoDB ones
+var (
+	_ formRepo.FormStore         = (*FormRepository)(nil)
+	_ fieldRepo.FieldStore       = (*FieldRepository)(nil)
+	_ stockRepo.StockStore       = (*StockRepository)(nil)
+	_ userRepo.UserStore         = (*UserRepository)(nil)
+	_ movementRepo.MovementStore = (*MovementRepository)(nil)
+	_ locationRepo.LocationStore = (*LocationRepository)(nil)
+	_ auditRepo.AuditStore       = (*AuditRepository)(nil)
+	_ revisionRepo.RevisionStore = (*RevisionRepository)(nil)
+	_ transaction.Transactor     = (*TransactionRepository)(nil)
+	_ jobRepo.JobStore           = (*JobRepository)(nil)
+)
+
+// Store holds every in-memory collection behind a single lock so that the
+// repositories built on top of it behave like one consistent database
+type Store struct {
+	mu        sync.RWMutex
+	forms     *collection[entity.Form]
+	fields    *collection[entity.Field]
+	stocks    *collection[entity.Stock]
+	users     []userRecord
+	movements *collection[entity.Movement]
+	locations *collection[entity.Location]
+	audit     []entity.AuditEntry
+	revisions *collection[entity.StockRevision]
+	jobs      *collection[entity.Job]
+}
+
+// NewStore creates an empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		forms:     newCollection[entity.Form](),
+		fields:    newCollection[entity.Field](),
+		stocks:    newCollection[entity.Stock](),
+		movements: newCollection[entity.Movement](),
+		locations: newCollection[entity.Location](),
+		revisions: newCollection[entity.StockRevision](),
+		jobs:      newCollection[entity.Job](),
+	}
+}
+
+// collection keeps documents by ID while remembering insertion order, which is
+// the natural order MongoDB returns documents in when no sort is given
+type collection[T any] struct {
+	docs  map[uuid.UUID]T
+	order []uuid.UUID
+}
+
+func newCollection[T any]() *collection[T] {
+	return &collection[T]{docs: make(map[uuid.UUID]T)}
+}
+
+func (c *collection[T]) get(id uuid.UUID) (T, bool) {
+	doc, ok := c.docs[id]
+	return doc, ok
+}
+
+func (c *collection[T]) put(id uuid.UUID, doc T) {
+	if _, exists := c.docs[id]; !exists {
+		c.order = append(c.order, id)
+	}
+	c.docs[id] = doc
+}
+
+func (c *collection[T]) remove(id uuid.UUID) bool {
+	if _, exists := c.docs[id]; !exists {
+		return false
+	}
+	delete(c.docs, id)
+	for i, existing := range c.order {
+		if existing == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// clone copies the collection, sharing the documents themselves
+func (c *collection[T]) clone() *collection[T] {
+	docs := make(map[uuid.UUID]T, len(c.docs))
+	for id, doc := range c.docs {
+		docs[id] = doc
+	}
+	return &collection[T]{docs: docs, order: append([]uuid.UUID(nil), c.order...)}
+}
+
+// each calls fn for every document in insertion order
+func (c *collection[T]) each(fn func(doc T)) {
+	for _, id := range c.order {
+		fn(c.docs[id])
+	}
+}
+
+// removeWhere deletes every document matching the predicate
+func (c *collection[T]) removeWhere(match func(doc T) bool) {
+	kept := c.order[:0]
+	for _, id := range c.order {
+		if match(c.docs[id]) {
+			delete(c.docs, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	c.order = kept
+}
+
+// cloneValue deep copies the maps and slices found in dynamic stock data so
+// callers can never mutate stored documents through shared references
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return cloneData(v)
+	case []interface{}:
+		cloned := make([]interface{}, len(v))
+		for i, item := range v {
+			cloned[i] = cloneValue(item)
+		}
+		return cloned
+	case []string:
+		return append([]string(nil), v...)
+	case []entity.StockAttachment:
+		return append([]entity.StockAttachment(nil), v...)
+	default:
+		return v
+	}
+}
+
+func cloneData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	cloned := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		cloned[key] = cloneValue(value)
+	}
+	return cloned
+}
+
+// valuesEqual compares two dynamic values the way MongoDB does for equality
+// matches, treating all numeric types as comparable numbers
+func valuesEqual(a, b interface{}) bool {
+	if _, ok := decimal.Rat(a); ok {
+		order, ok := decimal.Compare(a, b)
+		return ok && order == 0
+	}
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// valuesCollide reports whether two values of a unique field conflict the way
+// they do in a MongoDB unique index: arrays, which are indexed by element,
+// conflict when they share an element, and empty arrays conflict with each
+// other
+func valuesCollide(a, b interface{}) bool {
+	x, arrayA := stringSlice(a)
+	y, arrayB := stringSlice(b)
+	if !arrayA || !arrayB {
+		return valuesEqual(a, b)
+	}
+	if len(x) == 0 && len(y) == 0 {
+		return true
+	}
+	for _, item := range x {
+		for _, other := range y {
+			if item == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stringSlice(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if text, ok := item.(string); ok {
+				items = append(items, text)
+			}
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// uniqueViolation returns a duplicate error when the stock holds a value of a
+// unique field that another stock of its form holds too, which is what the
+// unique field indexes prevent in MongoDB. The caller must hold the lock.
+func (s *Store) uniqueViolation(stock entity.Stock) error {
+	var violation error
+	s.fields.each(func(field entity.Field) {
+		if violation != nil || field.FormID != stock.FormID || !field.IsUnique {
+			return
+		}
+		value, ok := stock.Data[field.Name]
+		if !ok {
+			return
+		}
+		s.stocks.each(func(other entity.Stock) {
+			if other.FormID != stock.FormID || other.ID == stock.ID {
+				return
+			}
+			if stored, ok := other.Data[field.Name]; ok && valuesCollide(stored, value) {
+				violation = utils.DuplicateError("Value for " + field.Name + " must be unique")
+			}
+		})
+	})
+	return violation
+}
+
+// duplicateValues reports whether two stocks of the form hold the same value
+// for the field, which keeps it from becoming unique. The caller must hold the
+// lock.
+func (s *Store) duplicateValues(formID uuid.UUID, fieldName string) bool {
+	var values []interface{}
+	duplicate := false
+	s.stocks.each(func(stock entity.Stock) {
+		value, ok := stock.Data[fieldName]
+		if duplicate || stock.FormID != formID || !ok {
+			return
+		}
+		for _, seen := range values {
+			if valuesCollide(seen, value) {
+				duplicate = true
+				return
+			}
+		}
+		values = append(values, value)
+	})
+	return duplicate
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	"github.com/kbc0/DynamicStockManager/utils"
+)
+
+// newForm stores a form with the given fields and returns its ID
+func newForm(t *testing.T, store *Store, fields ...entity.Field) uuid.UUID {
+	t.Helper()
+	form := entity.Form{ID: uuid.New(), UserID: uuid.New(), Name: "form " + uuid.NewString(), Version: 1}
+	if err := NewFormRepository(store).CreateForm(form); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range fields {
+		field.ID = uuid.New()
+		field.FormID = form.ID
+		field.Version = 1
+		if err := NewFieldRepository(store).CreateField(field); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return form.ID
+}
+
+func newStock(formID uuid.UUID, data map[string]interface{}) entity.Stock {
+	return entity.Stock{ID: uuid.New(), FormID: formID, Data: data, Version: 1}
+}
+
+func TestTransactionRestoresSnapshot(t *testing.T) {
+	store := NewStore()
+	stocks := NewStockRepository(store)
+	transactions := NewTransactionRepository(store)
+	formID := newForm(t, store, entity.Field{Name: "name", Type: entity.Text})
+	stock := newStock(formID, map[string]interface{}{"name": "bolt"})
+	if err := stocks.CreateStock(stock); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := errors.New("fail")
+	err := transactions.WithTransaction(context.Background(), func(ctx context.Context) error {
+		updated := stock
+		updated.Version = 2
+		updated.Data = map[string]interface{}{"name": "nut"}
+		if err := stocks.UpdateStock(ctx, updated); err != nil {
+			return err
+		}
+		if err := stocks.DeleteStock(ctx, stock.ID); err != nil {
+			return err
+		}
+		return fail
+	})
+	if err != fail {
+		t.Fatalf("got %v, want the error of the transaction", err)
+	}
+	restored, err := stocks.GetStockById(stock.ID)
+	if err != nil {
+		t.Fatalf("stock missing after rollback: %v", err)
+	}
+	if restored.Data["name"] != "bolt" || restored.Version != 1 {
+		t.Fatalf("rollback kept changes: %+v", restored)
+	}
+
+	err = transactions.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return stocks.DeleteStock(ctx, stock.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stocks.GetStockById(stock.ID); err == nil {
+		t.Fatal("committed delete was not applied")
+	}
+}
+
+func TestTransactionRunsHooksAfterCommit(t *testing.T) {
+	store := NewStore()
+	transactions := NewTransactionRepository(store)
+	ran := 0
+	err := transactions.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return transactions.WithTransaction(ctx, func(ctx context.Context) error {
+			return transaction.AfterCommit(ctx, func() error { ran++; return nil })
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran != 1 {
+		t.Fatalf("hook ran %d times, want once", ran)
+	}
+
+	ran = 0
+	transactions.WithTransaction(context.Background(), func(ctx context.Context) error {
+		transaction.AfterCommit(ctx, func() error { ran++; return nil })
+		return errors.New("fail")
+	})
+	if ran != 0 {
+		t.Fatal("hook ran for a failed transaction")
+	}
+}
+
+func TestUniqueViolation(t *testing.T) {
+	store := NewStore()
+	stocks := NewStockRepository(store)
+	formID := newForm(t, store,
+		entity.Field{Name: "sku", Type: entity.Text, IsUnique: true},
+		entity.Field{Name: "price", Type: entity.Number, IsUnique: true},
+		entity.Field{Name: "tags", Type: entity.Multiselect, Options: []string{"a", "b", "c"}, IsUnique: true},
+		entity.Field{Name: "note", Type: entity.Text},
+	)
+	first := newStock(formID, map[string]interface{}{"sku": "A", "price": 1.5, "tags": []string{"a", "b"}, "note": "x"})
+	if err := stocks.CreateStock(first); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		data      map[string]interface{}
+		duplicate bool
+	}{
+		{"same text", map[string]interface{}{"sku": "A"}, true},
+		{"other text", map[string]interface{}{"sku": "B"}, false},
+		{"equal number of another type", map[string]interface{}{"price": float32(1.5)}, true},
+		{"other number", map[string]interface{}{"price": int64(1)}, false},
+		{"shared option", map[string]interface{}{"tags": []string{"c", "b"}}, true},
+		{"other options", map[string]interface{}{"tags": []string{"c"}}, false},
+		{"field that is not unique", map[string]interface{}{"note": "x"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := stocks.CreateStock(newStock(formID, test.data))
+			if test.duplicate != errors.Is(err, utils.ErrDuplicate) {
+				t.Fatalf("got %v, duplicate %v", err, test.duplicate)
+			}
+		})
+	}
+
+	// Values of other forms and of the stock itself never collide
+	other := newForm(t, store, entity.Field{Name: "sku", Type: entity.Text, IsUnique: true})
+	if err := stocks.CreateStock(newStock(other, map[string]interface{}{"sku": "A"})); err != nil {
+		t.Fatal(err)
+	}
+	first.Version = 2
+	if err := stocks.UpdateStock(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	price, err := decimal.Parse("1.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stocks.CreateStock(newStock(formID, map[string]interface{}{"price": price})); !errors.Is(err, utils.ErrDuplicate) {
+		t.Fatalf("decimal equal to a stored float: got %v", err)
+	}
+}
+
+func TestUniqueFieldWithDuplicateValues(t *testing.T) {
+	store := NewStore()
+	stocks := NewStockRepository(store)
+	fields := NewFieldRepository(store)
+	formID := newForm(t, store)
+	for _, sku := range []string{"A", "A"} {
+		if err := stocks.CreateStock(newStock(formID, map[string]interface{}{"sku": sku})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	field := entity.Field{ID: uuid.New(), FormID: formID, Name: "sku", Type: entity.Text, IsUnique: true, Version: 1}
+	if err := fields.CreateField(field); !errors.Is(err, utils.ErrDuplicate) {
+		t.Fatalf("got %v, want a duplicate error", err)
+	}
+	field.IsUnique = false
+	if err := fields.CreateField(field); err != nil {
+		t.Fatal(err)
+	}
+	field.IsUnique = true
+	field.Version = 2
+	if err := fields.UpdateField(field); !errors.Is(err, utils.ErrDuplicate) {
+		t.Fatalf("got %v, want a duplicate error", err)
+	}
+}
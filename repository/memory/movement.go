@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	movementRepo "github.com/kbc0/DynamicStockManager/repository/movement"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MovementRepository is the in-memory MovementStore
+type MovementRepository struct {
+	store *Store
+}
+
+func NewMovementRepository(store *Store) *MovementRepository {
+	return &MovementRepository{store: store}
+}
+
+// RecordMovement applies the quantity and appends the ledger entry while
+// holding the store lock, so both always change together
+func (r *MovementRepository) RecordMovement(ctx context.Context, movement entity.Movement, allowNegative bool) (*entity.Movement, error) {
+	defer r.store.lock(ctx)()
+
+	stock, ok := r.store.stocks.get(movement.StockID)
+	if !ok || stock.FormID != movement.FormID {
+		return nil, mongo.ErrNoDocuments
+	}
+	stock = cloneStock(stock)
+
+	if !allowNegative && movement.Quantity < 0 {
+		// Movements without a location take the quantity held at none
+		available := stock.OnHand
+		if movement.LocationID != nil {
+			available = stock.Locations[movement.LocationID.String()]
+		} else {
+			for _, quantity := range stock.Locations {
+				available -= quantity
+			}
+		}
+		if stock.OnHand+movement.Quantity < 0 || available+movement.Quantity < 0 {
+			return nil, movementRepo.ErrInsufficientStock
+		}
+	}
+
+	stock.OnHand += movement.Quantity
+	addLocationQuantity(&stock, movement)
+	setBalances(&movement, stock)
+	r.store.stocks.put(stock.ID, stock)
+	r.store.movements.put(movement.ID, movement)
+	return &movement, nil
+}
+
+// RecordTransfer moves the quantity between the locations of a stock while
+// holding the store lock, so the transfer is atomic
+func (r *MovementRepository) RecordTransfer(ctx context.Context, out entity.Movement, in entity.Movement, allowNegative bool) ([]entity.Movement, error) {
+	if out.LocationID == nil || in.LocationID == nil {
+		return nil, errors.New("transfers need a source and a destination location")
+	}
+
+	defer r.store.lock(ctx)()
+
+	stock, ok := r.store.stocks.get(out.StockID)
+	if !ok || stock.FormID != out.FormID {
+		return nil, mongo.ErrNoDocuments
+	}
+	stock = cloneStock(stock)
+
+	if !allowNegative && stock.Locations[out.LocationID.String()]+out.Quantity < 0 {
+		return nil, movementRepo.ErrInsufficientStock
+	}
+
+	addLocationQuantity(&stock, out)
+	addLocationQuantity(&stock, in)
+	setBalances(&out, stock)
+	setBalances(&in, stock)
+	r.store.stocks.put(stock.ID, stock)
+	r.store.movements.put(out.ID, out)
+	r.store.movements.put(in.ID, in)
+	return []entity.Movement{out, in}, nil
+}
+
+func addLocationQuantity(stock *entity.Stock, movement entity.Movement) {
+	if movement.LocationID == nil {
+		return
+	}
+	if stock.Locations == nil {
+		stock.Locations = make(map[string]int64)
+	}
+	stock.Locations[movement.LocationID.String()] += movement.Quantity
+}
+
+// setBalances copies the resulting quantities of the stock onto the movement
+func setBalances(movement *entity.Movement, stock entity.Stock) {
+	movement.BalanceAfter = stock.OnHand
+	if movement.LocationID != nil {
+		balance := stock.Locations[movement.LocationID.String()]
+		movement.LocationBalanceAfter = &balance
+	}
+}
+
+// GetMovementsByStockID retrieves the ledger of a stock, newest first
+func (r *MovementRepository) GetMovementsByStockID(stockID uuid.UUID, limit int64, offset int64) ([]entity.Movement, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var movements []entity.Movement
+	r.store.movements.each(func(movement entity.Movement) {
+		if movement.StockID == stockID {
+			movements = append([]entity.Movement{movement}, movements...)
+		}
+	})
+	return paginate(movements, limit, offset), nil
+}
+
+// DeleteMovementsByStockID deletes the ledger of a stock
+func (r *MovementRepository) DeleteMovementsByStockID(ctx context.Context, stockID uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.movements.removeWhere(func(movement entity.Movement) bool {
+		return movement.StockID == stockID
+	})
+	return nil
+}
+
+// DeleteMovementsByFormID deletes the ledgers of every stock of a form
+func (r *MovementRepository) DeleteMovementsByFormID(ctx context.Context, formID uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.movements.removeWhere(func(movement entity.Movement) bool {
+		return movement.FormID == formID
+	})
+	return nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	movementRepo "github.com/kbc0/DynamicStockManager/repository/movement"
+)
+
+func newMovement(stock entity.Stock, quantity int64, location *uuid.UUID) entity.Movement {
+	return entity.Movement{ID: uuid.New(), FormID: stock.FormID, StockID: stock.ID, Type: entity.Adjustment, Quantity: quantity, LocationID: location}
+}
+
+func TestMovementInFailedTransaction(t *testing.T) {
+	store := NewStore()
+	stocks := NewStockRepository(store)
+	movements := NewMovementRepository(store)
+	transactions := NewTransactionRepository(store)
+	stock := newStock(newForm(t, store), nil)
+	if err := stocks.CreateStock(stock); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := errors.New("fail")
+	err := transactions.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := movements.RecordMovement(ctx, newMovement(stock, 5, nil), false); err != nil {
+			return err
+		}
+		return fail
+	})
+	if err != fail {
+		t.Fatal(err)
+	}
+	stored, _ := stocks.GetStockById(stock.ID)
+	ledger, _ := movements.GetMovementsByStockID(stock.ID, 10, 0)
+	if stored.OnHand != 0 || len(ledger) != 0 {
+		t.Fatalf("rolled back movement kept: onHand %d, ledger %v", stored.OnHand, ledger)
+	}
+}
+
+func TestMovementGuard(t *testing.T) {
+	warehouse, shelf := uuid.New(), uuid.New()
+	tests := []struct {
+		name     string
+		before   []entity.Movement // Quantities and locations only
+		movement entity.Movement
+		allowed  bool
+	}{
+		{"issue of the quantity on hand", []entity.Movement{{Quantity: 5}}, entity.Movement{Quantity: -5}, true},
+		{"issue of more than on hand", []entity.Movement{{Quantity: 5}}, entity.Movement{Quantity: -6}, false},
+		{"issue held at the location", []entity.Movement{{Quantity: 5, LocationID: &warehouse}}, entity.Movement{Quantity: -5, LocationID: &warehouse}, true},
+		{"issue held at another location", []entity.Movement{{Quantity: 5, LocationID: &warehouse}}, entity.Movement{Quantity: -1, LocationID: &shelf}, false},
+		{"unlocated issue of located quantity", []entity.Movement{{Quantity: 5, LocationID: &warehouse}}, entity.Movement{Quantity: -5}, false},
+		{"unlocated issue of unlocated quantity", []entity.Movement{{Quantity: 5, LocationID: &warehouse}, {Quantity: 2}}, entity.Movement{Quantity: -2}, true},
+		{"unlocated issue past unlocated quantity", []entity.Movement{{Quantity: 5, LocationID: &warehouse}, {Quantity: 2}}, entity.Movement{Quantity: -3}, false},
+		{"located issue past on hand", []entity.Movement{{Quantity: 5}, {Quantity: 5, LocationID: &warehouse}, {Quantity: -5}}, entity.Movement{Quantity: -5, LocationID: &warehouse}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewStore()
+			stocks := NewStockRepository(store)
+			movements := NewMovementRepository(store)
+			stock := newStock(newForm(t, store), nil)
+			if err := stocks.CreateStock(stock); err != nil {
+				t.Fatal(err)
+			}
+			for _, before := range test.before {
+				if _, err := movements.RecordMovement(context.Background(), newMovement(stock, before.Quantity, before.LocationID), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := movements.RecordMovement(context.Background(), newMovement(stock, test.movement.Quantity, test.movement.LocationID), false)
+			if test.allowed != (err == nil) {
+				t.Fatalf("got %v, allowed %v", err, test.allowed)
+			}
+			if !test.allowed && !errors.Is(err, movementRepo.ErrInsufficientStock) {
+				t.Fatalf("got %v, want insufficient stock", err)
+			}
+
+			// The same movement always passes on forms allowing negative stock
+			if _, err := movements.RecordMovement(context.Background(), newMovement(stock, test.movement.Quantity, test.movement.LocationID), true); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
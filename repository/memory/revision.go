@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	revisionRepo "github.com/kbc0/DynamicStockManager/repository/revision"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RevisionRepository is the in-memory RevisionStore
+type RevisionRepository struct {
+	store *Store
+}
+
+func NewRevisionRepository(store *Store) *RevisionRepository {
+	return &RevisionRepository{store: store}
+}
+
+func (r *RevisionRepository) CreateRevision(revision entity.StockRevision) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, exists := r.find(revision.StockID, revision.Revision); exists {
+		return revisionRepo.ErrRevisionExists
+	}
+	revision.Data = cloneData(revision.Data)
+	r.store.revisions.put(revision.ID, revision)
+	return nil
+}
+
+func (r *RevisionRepository) GetRevisionsByStockID(stockID uuid.UUID, limit int64, offset int64) ([]entity.StockRevision, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var revisions []entity.StockRevision
+	r.store.revisions.each(func(revision entity.StockRevision) {
+		if revision.StockID == stockID {
+			revision.Data = cloneData(revision.Data)
+			revisions = append(revisions, revision)
+		}
+	})
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision > revisions[j].Revision
+	})
+	if revisions = paginate(revisions, limit, offset); revisions == nil {
+		revisions = []entity.StockRevision{}
+	}
+	return revisions, nil
+}
+
+func (r *RevisionRepository) GetRevision(stockID uuid.UUID, number int) (*entity.StockRevision, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	revision, ok := r.find(stockID, number)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	revision.Data = cloneData(revision.Data)
+	return &revision, nil
+}
+
+func (r *RevisionRepository) DeleteRevisionsByStockID(ctx context.Context, stockID uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.revisions.removeWhere(func(revision entity.StockRevision) bool {
+		return revision.StockID == stockID
+	})
+	return nil
+}
+
+func (r *RevisionRepository) DeleteRevisionsByFormID(ctx context.Context, formID uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.revisions.removeWhere(func(revision entity.StockRevision) bool {
+		return revision.FormID == formID
+	})
+	return nil
+}
+
+// find looks up a revision of a stock, the caller must hold the store lock
+func (r *RevisionRepository) find(stockID uuid.UUID, number int) (entity.StockRevision, bool) {
+	var found entity.StockRevision
+	exists := false
+	r.store.revisions.each(func(revision entity.StockRevision) {
+		if revision.StockID == stockID && revision.Revision == number {
+			found, exists = revision, true
+		}
+	})
+	return found, exists
+}
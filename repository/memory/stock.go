@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/query"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// StockRepository is the in-memory StockStore
+type StockRepository struct {
+	store *Store
+}
+
+func NewStockRepository(store *Store) *StockRepository {
+	return &StockRepository{store: store}
+}
+
+func (r *StockRepository) CreateStock(stock entity.Stock) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, exists := r.store.stocks.get(stock.ID); exists {
+		return errors.New("stock already exists")
+	}
+	if err := r.store.uniqueViolation(stock); err != nil {
+		return err
+	}
+	r.store.stocks.put(stock.ID, cloneStock(stock))
+	return nil
+}
+
+func (r *StockRepository) GetStockById(id uuid.UUID) (*entity.Stock, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stock, ok := r.store.stocks.get(id)
+	if !ok || stock.DeletedAt != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	stock = cloneStock(stock)
+	return &stock, nil
+}
+
+func (r *StockRepository) GetAllStocksByFormId(formId uuid.UUID) ([]entity.Stock, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var stocks []entity.Stock
+	r.store.stocks.each(func(stock entity.Stock) {
+		if stock.FormID == formId && stock.DeletedAt == nil {
+			stocks = append(stocks, cloneStock(stock))
+		}
+	})
+	return stocks, nil
+}
+
+func (r *StockRepository) GetStocksByIDs(formID uuid.UUID, ids []uuid.UUID) ([]entity.Stock, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var stocks []entity.Stock
+	for _, id := range ids {
+		if stock, ok := r.store.stocks.get(id); ok && stock.FormID == formID && stock.DeletedAt == nil {
+			stocks = append(stocks, cloneStock(stock))
+		}
+	}
+	return stocks, nil
+}
+
+// UpdateStock replaces the stored stock, except for the on-hand quantity which
+// only the movement ledger may change. The stock must carry the next version of
+// the stored one.
+func (r *StockRepository) UpdateStock(ctx context.Context, stock entity.Stock) error {
+	defer r.store.lock(ctx)()
+
+	existing, ok := r.store.stocks.get(stock.ID)
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	if existing.Version != stock.Version-1 {
+		return utils.ErrVersionConflict
+	}
+	if err := r.store.uniqueViolation(stock); err != nil {
+		return err
+	}
+	stock.OnHand = existing.OnHand
+	stock.Locations = existing.Locations
+	stock.DeletedAt, stock.DeletedBy = existing.DeletedAt, existing.DeletedBy
+	stock.Archived = existing.Archived
+	r.store.stocks.put(stock.ID, cloneStock(stock))
+	return nil
+}
+
+func (r *StockRepository) DeleteStock(ctx context.Context, id uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.stocks.remove(id)
+	return nil
+}
+
+func (r *StockRepository) CheckUniqueField(formID uuid.UUID, fieldName string, value interface{}, excludeID uuid.UUID) (bool, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	exists := false
+	r.store.stocks.each(func(stock entity.Stock) {
+		if stock.FormID != formID || stock.ID == excludeID {
+			return
+		}
+		if stored, ok := stock.Data[fieldName]; ok && valuesCollide(stored, value) {
+			exists = true
+		}
+	})
+	return exists, nil
+}
+
+// DeleteStocksByFormID deletes all stocks associated with a specific form ID
+func (r *StockRepository) DeleteStocksByFormID(ctx context.Context, formID uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	r.store.stocks.removeWhere(func(stock entity.Stock) bool {
+		return stock.FormID == formID
+	})
+	return nil
+}
+
+func (r *StockRepository) FindStocks(formID uuid.UUID, q query.StockQuery) ([]entity.Stock, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stocks := []entity.Stock{}
+	r.store.stocks.each(func(stock entity.Stock) {
+		if stock.FormID == formID && stock.DeletedAt == nil && q.Match(stock) {
+			stocks = append(stocks, cloneStock(stock))
+		}
+	})
+	sort.SliceStable(stocks, func(i, j int) bool {
+		return q.Less(stocks[i], stocks[j])
+	})
+	if stocks = paginate(stocks, q.Limit, q.Offset); stocks == nil {
+		stocks = []entity.Stock{}
+	}
+	return stocks, nil
+}
+
+// EachStock works on a snapshot of the matching stocks, so fn may take its
+// time without blocking writers
+func (r *StockRepository) EachStock(formID uuid.UUID, q query.StockQuery, fn func(entity.Stock) error) error {
+	stocks, err := r.FindStocks(formID, q)
+	if err != nil {
+		return err
+	}
+	for _, stock := range stocks {
+		if err := fn(stock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *StockRepository) CountStocks(formID uuid.UUID, q query.StockQuery) (int64, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	count := int64(0)
+	r.store.stocks.each(func(stock entity.Stock) {
+		if stock.FormID == formID && stock.DeletedAt == nil && q.Match(stock) {
+			count++
+		}
+	})
+	return count, nil
+}
+
+func (r *StockRepository) CountStocksAtLocation(locationID uuid.UUID) (int64, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	count := int64(0)
+	r.store.stocks.each(func(stock entity.Stock) {
+		if stock.Locations[locationID.String()] != 0 {
+			count++
+		}
+	})
+	return count, nil
+}
+
+func (r *StockRepository) TrashStock(ctx context.Context, id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error {
+	defer r.store.lock(ctx)()
+
+	stock, ok := r.store.stocks.get(id)
+	if !ok || stock.DeletedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+	stock = cloneStock(stock)
+	stock.DeletedAt, stock.DeletedBy = &deletedAt, &deletedBy
+	stock.Version++
+	r.store.stocks.put(id, stock)
+	return nil
+}
+
+func (r *StockRepository) RestoreStock(id uuid.UUID) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stock, ok := r.store.stocks.get(id)
+	if !ok || stock.DeletedAt == nil {
+		return mongo.ErrNoDocuments
+	}
+	stock = cloneStock(stock)
+	stock.DeletedAt, stock.DeletedBy = nil, nil
+	stock.Version++
+	r.store.stocks.put(id, stock)
+	return nil
+}
+
+func (r *StockRepository) GetTrashedStockByID(id uuid.UUID) (*entity.Stock, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stock, ok := r.store.stocks.get(id)
+	if !ok || stock.DeletedAt == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	stock = cloneStock(stock)
+	return &stock, nil
+}
+
+func (r *StockRepository) GetTrashedStocks(formIDs []uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Stock, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var stocks []entity.Stock
+	r.store.stocks.each(func(stock entity.Stock) {
+		if containsID(formIDs, stock.FormID) && inTrash(stock.DeletedAt, before) {
+			stocks = append(stocks, cloneStock(stock))
+		}
+	})
+	return trashPage(stocks, func(stock entity.Stock) time.Time { return *stock.DeletedAt }, limit, offset), nil
+}
+
+func (r *StockRepository) CountStocksWithDataKey(formID uuid.UUID, key string) (int64, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	count := int64(0)
+	r.store.stocks.each(func(stock entity.Stock) {
+		if _, ok := stock.Data[key]; ok && stock.FormID == formID {
+			count++
+		}
+	})
+	return count, nil
+}
+
+func (r *StockRepository) RenameDataKey(ctx context.Context, formID uuid.UUID, from string, to string, limit int64) (int64, error) {
+	return r.migrateDataKey(ctx, formID, from, limit, func(stock *entity.Stock) {
+		if _, exists := stock.Data[to]; !exists {
+			stock.Data[to] = stock.Data[from]
+		}
+		delete(stock.Data, from)
+	})
+}
+
+func (r *StockRepository) RemoveDataKey(ctx context.Context, formID uuid.UUID, key string, archive bool, limit int64) (int64, error) {
+	return r.migrateDataKey(ctx, formID, key, limit, func(stock *entity.Stock) {
+		if archive {
+			if stock.Archived == nil {
+				stock.Archived = make(map[string]interface{})
+			}
+			stock.Archived[key] = stock.Data[key]
+		}
+		delete(stock.Data, key)
+	})
+}
+
+// migrateDataKey applies change to at most limit stocks of a form whose data
+// holds the key and returns how many it changed
+func (r *StockRepository) migrateDataKey(ctx context.Context, formID uuid.UUID, key string, limit int64, change func(stock *entity.Stock)) (int64, error) {
+	defer r.store.lock(ctx)()
+
+	var matched []entity.Stock
+	r.store.stocks.each(func(stock entity.Stock) {
+		if _, ok := stock.Data[key]; ok && stock.FormID == formID && (limit <= 0 || int64(len(matched)) < limit) {
+			matched = append(matched, stock)
+		}
+	})
+	for _, stock := range matched {
+		stock = cloneStock(stock)
+		change(&stock)
+		stock.Version++
+		r.store.stocks.put(stock.ID, stock)
+	}
+	return int64(len(matched)), nil
+}
+
+func cloneStock(stock entity.Stock) entity.Stock {
+	stock.Data = cloneData(stock.Data)
+	stock.Archived = cloneData(stock.Archived)
+	if stock.Locations != nil {
+		locations := make(map[string]int64, len(stock.Locations))
+		for locationID, quantity := range stock.Locations {
+			locations[locationID] = quantity
+		}
+		stock.Locations = locations
+	}
+	return stock
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+)
+
+// TransactionRepository runs transactions against the in-memory store. A
+// transaction holds the store lock for its whole duration, so other requests
+// never see its partial changes, and restores a snapshot of the store when it
+// fails.
+type TransactionRepository struct {
+	store *Store
+}
+
+func NewTransactionRepository(store *Store) *TransactionRepository {
+	return &TransactionRepository{store: store}
+}
+
+// txKey marks a context whose transaction holds the lock of the store
+type txKey struct{}
+
+func (r *TransactionRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if r.store.inTransaction(ctx) {
+		// Nested transactions are part of the outer one
+		return fn(ctx)
+	}
+
+	r.store.mu.Lock()
+	saved := r.store.snapshot()
+	txCtx := transaction.WithHooks(context.WithValue(ctx, txKey{}, r.store))
+	err := fn(txCtx)
+	if err != nil {
+		r.store.restore(saved)
+	}
+	r.store.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return transaction.RunHooks(txCtx)
+}
+
+// inTransaction reports whether ctx belongs to a transaction on this store
+func (s *Store) inTransaction(ctx context.Context) bool {
+	store, _ := ctx.Value(txKey{}).(*Store)
+	return store == s
+}
+
+// lock takes the write lock of the store unless ctx belongs to a transaction,
+// which holds it already, and returns the function releasing it
+func (s *Store) lock(ctx context.Context) func() {
+	if s.inTransaction(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// snapshot copies the collections of the store. Stored documents are never
+// modified in place, so copying the collections themselves is enough. The
+// caller must hold the lock.
+func (s *Store) snapshot() *Store {
+	return &Store{
+		forms:     s.forms.clone(),
+		fields:    s.fields.clone(),
+		stocks:    s.stocks.clone(),
+		users:     append([]userRecord(nil), s.users...),
+		movements: s.movements.clone(),
+		locations: s.locations.clone(),
+		audit:     append([]entity.AuditEntry(nil), s.audit...),
+		revisions: s.revisions.clone(),
+		jobs:      s.jobs.clone(),
+	}
+}
+
+// restore puts back the collections of a snapshot. The caller must hold the
+// lock.
+func (s *Store) restore(saved *Store) {
+	s.forms = saved.forms
+	s.fields = saved.fields
+	s.stocks = saved.stocks
+	s.users = saved.users
+	s.movements = saved.movements
+	s.locations = saved.locations
+	s.audit = saved.audit
+	s.revisions = saved.revisions
+	s.jobs = saved.jobs
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// inTrash reports whether a document with the deletion time is in the trash,
+// and was deleted before the given time unless it is zero
+func inTrash(deletedAt *time.Time, before time.Time) bool {
+	return deletedAt != nil && (before.IsZero() || deletedAt.Before(before))
+}
+
+// trashPage sorts documents in the trash most recently deleted first and
+// applies the page
+func trashPage[T any](docs []T, deletedAt func(T) time.Time, limit int64, offset int64) []T {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return deletedAt(docs[i]).After(deletedAt(docs[j]))
+	})
+	if docs = paginate(docs, limit, offset); docs == nil {
+		docs = []T{}
+	}
+	return docs
+}
+
+// containsID reports whether ids contains id, where nil ids contains every ID
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	if ids == nil {
+		return true
+	}
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
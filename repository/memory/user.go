@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userRecord pairs a user with the document ID MongoDB would have generated
+type userRecord struct {
+	oid  primitive.ObjectID
+	user entity.User
+}
+
+// UserRepository is the in-memory UserStore
+type UserRepository struct {
+	store *Store
+}
+
+func NewUserRepository(store *Store) *UserRepository {
+	return &UserRepository{store: store}
+}
+
+// CreateUser inserts a new user into the store
+func (r *UserRepository) CreateUser(user entity.User) (primitive.ObjectID, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, record := range r.store.users {
+		existing := record.user
+		if existing.Username == user.Username || (existing.Name == user.Name && existing.Surname == user.Surname) {
+			return primitive.NilObjectID, utils.DuplicateError("username or name-surname combination already exists")
+		}
+	}
+
+	oid := primitive.NewObjectID()
+	r.store.users = append(r.store.users, userRecord{oid: oid, user: user})
+	return oid, nil
+}
+
+// GetUserByUsername retrieves a user by username from the store
+func (r *UserRepository) GetUserByUsername(username string) (*entity.User, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	for _, record := range r.store.users {
+		if record.user.Username == username {
+			user := record.user
+			return &user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
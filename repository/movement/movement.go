@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInsufficientStock is returned when a movement would take the on-hand
+// quantity of a stock below zero on a form that does not allow it
+var ErrInsufficientStock = errors.New("insufficient stock on hand")
+
+// MovementStore describes the storage operations available for the inventory ledger
+type MovementStore interface {
+	// RecordMovement appends the movement to the ledger and applies its quantity
+	// to the on-hand quantity of the stock. Both only change together when ctx
+	// belongs to a transaction. The returned movement carries the resulting
+	// balance.
+	RecordMovement(ctx context.Context, movement entity.Movement, allowNegative bool) (*entity.Movement, error)
+	// RecordTransfer moves quantity between two locations of a stock. The out
+	// movement carries the negative and the in movement the positive quantity;
+	// both are written along with the location quantities, together when ctx
+	// belongs to a transaction.
+	RecordTransfer(ctx context.Context, out entity.Movement, in entity.Movement, allowNegative bool) ([]entity.Movement, error)
+	GetMovementsByStockID(stockID uuid.UUID, limit int64, offset int64) ([]entity.Movement, error)
+	DeleteMovementsByStockID(ctx context.Context, stockID uuid.UUID) error
+	DeleteMovementsByFormID(ctx context.Context, formID uuid.UUID) error
+}
+
+// MovementRepository is the MongoDB backed MovementStore
+type MovementRepository struct {
+	collection *mongo.Collection
+	stocks     *mongo.Collection
+}
+
+func NewMovementRepository(db *mongo.Database) *MovementRepository {
+	return &MovementRepository{
+		collection: db.Collection("movements"),
+		stocks:     db.Collection("stocks"),
+	}
+}
+
+// RecordMovement increments the on-hand quantity with a conditional update, so
+// concurrent issues can never overdraw a stock, and then writes the ledger entry.
+// Movements at a location also change, and are checked against, the quantity
+// held at that location. Movements without one can only take the quantity that
+// is not held at any location.
+func (r *MovementRepository) RecordMovement(ctx context.Context, movement entity.Movement, allowNegative bool) (*entity.Movement, error) {
+	filter := bson.M{"_id": movement.StockID, "formId": movement.FormID}
+	inc := bson.M{"onHand": movement.Quantity}
+	conditional := !allowNegative && movement.Quantity < 0
+	if conditional {
+		filter["onHand"] = bson.M{"$gte": -movement.Quantity}
+	}
+	if movement.LocationID != nil {
+		key := locationKey(*movement.LocationID)
+		inc[key] = movement.Quantity
+		if conditional {
+			filter[key] = bson.M{"$gte": -movement.Quantity}
+		}
+	} else if conditional {
+		filter["$expr"] = bson.M{"$gte": bson.A{unlocatedQuantity, -movement.Quantity}}
+	}
+
+	stock, err := r.applyQuantities(ctx, movement, filter, inc, conditional)
+	if err != nil {
+		return nil, err
+	}
+
+	setBalances(&movement, stock)
+	if _, err := r.collection.InsertOne(ctx, movement); err != nil {
+		return nil, err
+	}
+	return &movement, nil
+}
+
+// RecordTransfer moves the quantity between the locations of one stock document
+// with a single conditional update, which keeps the transfer atomic
+func (r *MovementRepository) RecordTransfer(ctx context.Context, out entity.Movement, in entity.Movement, allowNegative bool) ([]entity.Movement, error) {
+	if out.LocationID == nil || in.LocationID == nil {
+		return nil, errors.New("transfers need a source and a destination location")
+	}
+	from := locationKey(*out.LocationID)
+	to := locationKey(*in.LocationID)
+
+	filter := bson.M{"_id": out.StockID, "formId": out.FormID}
+	if !allowNegative {
+		filter[from] = bson.M{"$gte": -out.Quantity}
+	}
+	inc := bson.M{from: out.Quantity, to: in.Quantity}
+
+	stock, err := r.applyQuantities(ctx, out, filter, inc, !allowNegative)
+	if err != nil {
+		return nil, err
+	}
+
+	setBalances(&out, stock)
+	setBalances(&in, stock)
+	if _, err := r.collection.InsertMany(ctx, []interface{}{out, in}); err != nil {
+		return nil, err
+	}
+	return []entity.Movement{out, in}, nil
+}
+
+// applyQuantities runs the increment and returns the updated stock. A
+// conditional filter also requires enough quantity to be available.
+func (r *MovementRepository) applyQuantities(ctx context.Context, movement entity.Movement, filter bson.M, inc bson.M, conditional bool) (*entity.Stock, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var stock entity.Stock
+	err := r.stocks.FindOneAndUpdate(ctx, filter, bson.M{"$inc": inc}, opts).Decode(&stock)
+	if err != nil {
+		if err == mongo.ErrNoDocuments && conditional {
+			// Tell a missing stock apart from one without enough quantity
+			count, countErr := r.stocks.CountDocuments(ctx, bson.M{"_id": movement.StockID, "formId": movement.FormID})
+			if countErr == nil && count > 0 {
+				return nil, ErrInsufficientStock
+			}
+		}
+		return nil, err
+	}
+	return &stock, nil
+}
+
+// setBalances copies the resulting quantities of the stock onto the movement
+func setBalances(movement *entity.Movement, stock *entity.Stock) {
+	movement.BalanceAfter = stock.OnHand
+	if movement.LocationID != nil {
+		balance := stock.Locations[movement.LocationID.String()]
+		movement.LocationBalanceAfter = &balance
+	}
+}
+
+// unlocatedQuantity is the aggregation expression of the on-hand quantity of a
+// stock that is not held at any location
+var unlocatedQuantity = bson.M{"$subtract": bson.A{"$onHand", bson.M{"$sum": bson.M{"$map": bson.M{
+	"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$locations", bson.M{}}}},
+	"in":    "$$this.v",
+}}}}}
+
+func locationKey(locationID uuid.UUID) string {
+	return "locations." + locationID.String()
+}
+
+// GetMovementsByStockID retrieves the ledger of a stock, newest first
+func (r *MovementRepository) GetMovementsByStockID(stockID uuid.UUID, limit int64, offset int64) ([]entity.Movement, error) {
+	var movements []entity.Movement
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := r.collection.Find(context.TODO(), bson.M{"stockId": stockID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var movement entity.Movement
+		if err := cursor.Decode(&movement); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, cursor.Err()
+}
+
+// DeleteMovementsByStockID deletes the ledger of a stock
+func (r *MovementRepository) DeleteMovementsByStockID(ctx context.Context, stockID uuid.UUID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"stockId": stockID})
+	return err
+}
+
+// DeleteMovementsByFormID deletes the ledgers of every stock of a form
+func (r *MovementRepository) DeleteMovementsByFormID(ctx context.Context, formID uuid.UUID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"formId": formID})
+	return err
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRevisionExists is returned when a stock already has a revision with the number
+var ErrRevisionExists = errors.New("revision already exists")
+
+// RevisionStore describes the storage operations available for stock revisions
+type RevisionStore interface {
+	CreateRevision(revision entity.StockRevision) error
+	// GetRevisionsByStockID retrieves the revisions of a stock, newest first
+	GetRevisionsByStockID(stockID uuid.UUID, limit int64, offset int64) ([]entity.StockRevision, error)
+	GetRevision(stockID uuid.UUID, number int) (*entity.StockRevision, error)
+	DeleteRevisionsByStockID(ctx context.Context, stockID uuid.UUID) error
+	DeleteRevisionsByFormID(ctx context.Context, formID uuid.UUID) error
+}
+
+// RevisionRepository is the MongoDB backed RevisionStore
+type RevisionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRevisionRepository(db *mongo.Database) *RevisionRepository {
+	return &RevisionRepository{
+		collection: db.Collection("stock_revisions"),
+	}
+}
+
+func (r *RevisionRepository) CreateRevision(revision entity.StockRevision) error {
+	filter := bson.M{"stockId": revision.StockID, "revision": revision.Revision}
+	count, err := r.collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrRevisionExists
+	}
+	_, err = r.collection.InsertOne(context.Background(), revision)
+	return err
+}
+
+func (r *RevisionRepository) GetRevisionsByStockID(stockID uuid.UUID, limit int64, offset int64) ([]entity.StockRevision, error) {
+	revisions := []entity.StockRevision{}
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: -1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := r.collection.Find(context.Background(), bson.M{"stockId": stockID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err := cursor.All(context.Background(), &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *RevisionRepository) GetRevision(stockID uuid.UUID, number int) (*entity.StockRevision, error) {
+	var revision entity.StockRevision
+	err := r.collection.FindOne(context.Background(), bson.M{"stockId": stockID, "revision": number}).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (r *RevisionRepository) DeleteRevisionsByStockID(ctx context.Context, stockID uuid.UUID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"stockId": stockID})
+	return err
+}
+
+func (r *RevisionRepository) DeleteRevisionsByFormID(ctx context.Context, formID uuid.UUID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"formId": formID})
+	return err
+}
@@ -2,31 +2,95 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/query"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	"github.com/kbc0/DynamicStockManager/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// StockStore describes the storage operations available for stock records
+type StockStore interface {
+	CreateStock(stock entity.Stock) error
+	GetStockById(id uuid.UUID) (*entity.Stock, error)
+	GetAllStocksByFormId(formId uuid.UUID) ([]entity.Stock, error)
+	// GetStocksByIDs retrieves the stocks of a form with the given IDs, leaving
+	// out the ones that do not exist or are in the trash
+	GetStocksByIDs(formID uuid.UUID, ids []uuid.UUID) ([]entity.Stock, error)
+	UpdateStock(ctx context.Context, stock entity.Stock) error
+	DeleteStock(ctx context.Context, id uuid.UUID) error
+	// CheckUniqueField reports whether another stock of the form than the one
+	// with excludeID, which may be uuid.Nil, holds the value for the field, or
+	// for a multiselect any of its options
+	CheckUniqueField(formID uuid.UUID, fieldName string, value interface{}, excludeID uuid.UUID) (bool, error)
+	DeleteStocksByFormID(ctx context.Context, formID uuid.UUID) error
+	// FindStocks retrieves the stocks of a form matching the query, in its order and page
+	FindStocks(formID uuid.UUID, q query.StockQuery) ([]entity.Stock, error)
+	// CountStocks counts the stocks of a form matching the query, ignoring its page
+	CountStocks(formID uuid.UUID, q query.StockQuery) (int64, error)
+	// EachStock calls fn for every stock of a form matching the query, in its
+	// order, without loading them all at once. It stops at the first error of fn.
+	EachStock(formID uuid.UUID, q query.StockQuery, fn func(entity.Stock) error) error
+	// CountStocksAtLocation counts the stocks of any form holding a quantity at
+	// the location, including the ones in the trash
+	CountStocksAtLocation(locationID uuid.UUID) (int64, error)
+	// TrashStock moves a stock to the trash, which hides it from all other reads.
+	// Its unique values stay taken until it is deleted for good.
+	TrashStock(ctx context.Context, id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error
+	// RestoreStock takes a stock out of the trash
+	RestoreStock(id uuid.UUID) error
+	GetTrashedStockByID(id uuid.UUID) (*entity.Stock, error)
+	// GetTrashedStocks lists the stocks in the trash, most recently deleted
+	// first. A nil formIDs matches every form and a zero before every deletion time.
+	GetTrashedStocks(formIDs []uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Stock, error)
+	// CountStocksWithDataKey counts the stocks of a form whose data holds the
+	// key, including the ones in the trash
+	CountStocksWithDataKey(formID uuid.UUID, key string) (int64, error)
+	// RenameDataKey moves the value of a data key to a new key on at most limit
+	// stocks of a form, including the ones in the trash, and returns how many it
+	// changed. A value already held under the new key is kept over the old one.
+	RenameDataKey(ctx context.Context, formID uuid.UUID, from string, to string, limit int64) (int64, error)
+	// RemoveDataKey removes a data key from at most limit stocks of a form,
+	// including the ones in the trash, and returns how many it changed. With
+	// archive set the value is moved to the archived data of the stock instead.
+	RemoveDataKey(ctx context.Context, formID uuid.UUID, key string, archive bool, limit int64) (int64, error)
+}
+
+// StockRepository is the MongoDB backed StockStore. Writes of a stock also
+// claim its values for unique fields, in the same transaction.
 type StockRepository struct {
-	collection *mongo.Collection
+	collection   *mongo.Collection
+	fields       *mongo.Collection
+	uniqueValues *mongo.Collection
+	transactions *transaction.TransactionRepository
 }
 
 func NewStockRepository(db *mongo.Database) *StockRepository {
 	return &StockRepository{
-		collection: db.Collection("stocks"),
+		collection:   db.Collection("stocks"),
+		fields:       db.Collection("fields"),
+		uniqueValues: db.Collection(UniqueValuesCollection),
+		transactions: transaction.NewTransactionRepository(db),
 	}
 }
 
 func (r *StockRepository) CreateStock(stock entity.Stock) error {
-	_, err := r.collection.InsertOne(context.Background(), stock)
-	return err
+	return r.transactions.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := r.collection.InsertOne(ctx, stock); err != nil {
+			return err
+		}
+		return r.claimUniqueValues(ctx, stock)
+	})
 }
 
 func (r *StockRepository) GetStockById(id uuid.UUID) (*entity.Stock, error) {
 	var stock entity.Stock
-	err := r.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&stock)
+	err := r.collection.FindOne(context.Background(), utils.NotDeleted(bson.M{"_id": id})).Decode(&stock)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +98,7 @@ func (r *StockRepository) GetStockById(id uuid.UUID) (*entity.Stock, error) {
 }
 func (r *StockRepository) GetAllStocksByFormId(formId uuid.UUID) ([]entity.Stock, error) {
 	var stocks []entity.Stock
-	filter := bson.M{"formId": formId}
+	filter := utils.NotDeleted(bson.M{"formId": formId})
 	cursor, err := r.collection.Find(context.Background(), filter)
 	if err != nil {
 		return nil, err
@@ -53,24 +117,78 @@ func (r *StockRepository) GetAllStocksByFormId(formId uuid.UUID) ([]entity.Stock
 	return stocks, nil
 }
 
-func (r *StockRepository) UpdateStock(stock entity.Stock) error {
-	filter := bson.M{"_id": stock.ID}
-	update := bson.M{"$set": stock}
-	_, err := r.collection.UpdateOne(context.Background(), filter, update)
-	return err
+func (r *StockRepository) GetStocksByIDs(formID uuid.UUID, ids []uuid.UUID) ([]entity.Stock, error) {
+	var stocks []entity.Stock
+	cursor, err := r.collection.Find(context.Background(), utils.NotDeleted(bson.M{"formId": formID, "_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(context.Background(), &stocks); err != nil {
+		return nil, err
+	}
+	return stocks, nil
+}
+
+// UpdateStock replaces the stored stock, except for the on-hand quantity which
+// only the movement ledger may change. The stock must carry the next version of
+// the stored one, so that concurrent updates cannot overwrite each other.
+func (r *StockRepository) UpdateStock(ctx context.Context, stock entity.Stock) error {
+	return r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		return r.updateStock(ctx, stock)
+	})
+}
+
+func (r *StockRepository) updateStock(ctx context.Context, stock entity.Stock) error {
+	filter := bson.M{"_id": stock.ID, "version": utils.VersionFilter(stock.Version - 1)}
+	update := bson.M{"$set": bson.M{
+		"formId":    stock.FormID,
+		"data":      stock.Data,
+		"revision":  stock.Revision,
+		"version":   stock.Version,
+		"createdAt": stock.CreatedAt,
+		"updatedAt": stock.UpdatedAt,
+	}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := r.collection.CountDocuments(ctx, bson.M{"_id": stock.ID})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return utils.ErrVersionConflict
+		}
+		return mongo.ErrNoDocuments
+	}
+	return r.claimUniqueValues(ctx, stock)
 }
 
-func (r *StockRepository) DeleteStock(id uuid.UUID) error {
-	_, err := r.collection.DeleteOne(context.Background(), bson.M{"_id": id})
-	return err
+func (r *StockRepository) DeleteStock(ctx context.Context, id uuid.UUID) error {
+	return r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+			return err
+		}
+		_, err := r.uniqueValues.DeleteMany(ctx, bson.M{"stockId": id})
+		return err
+	})
 }
 
-func (r *StockRepository) CheckUniqueField(formID uuid.UUID, fieldName string, value interface{}) (bool, error) {
-	// Construct the query to check if any stock exists with the given field having the specific value within the same form
+func (r *StockRepository) CheckUniqueField(formID uuid.UUID, fieldName string, value interface{}, excludeID uuid.UUID) (bool, error) {
+	// Construct the query to check if any stock exists with the given field having the specific value within the same form.
+	// Stocks in the trash keep their values, which stay claimed.
 	query := bson.M{
 		"formId":            formID,
 		"data." + fieldName: value,
 	}
+	if selection, ok := value.([]string); ok {
+		// Every selected option of a multiselect is claimed on its own
+		query["data."+fieldName] = bson.M{"$in": selection}
+	}
+	if excludeID != uuid.Nil {
+		query["_id"] = bson.M{"$ne": excludeID}
+	}
 
 	// Perform a count operation to determine if any documents match the query
 	count, err := r.collection.CountDocuments(context.Background(), query)
@@ -83,8 +201,336 @@ func (r *StockRepository) CheckUniqueField(formID uuid.UUID, fieldName string, v
 }
 
 // DeleteStocksByFormID deletes all stocks associated with a specific form ID
-func (r *StockRepository) DeleteStocksByFormID(formID uuid.UUID) error {
-    _, err := r.collection.DeleteMany(context.TODO(), bson.M{"formId": formID})
-    return err
+func (r *StockRepository) DeleteStocksByFormID(ctx context.Context, formID uuid.UUID) error {
+    return r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+        if _, err := r.collection.DeleteMany(ctx, bson.M{"formId": formID}); err != nil {
+            return err
+        }
+        _, err := r.uniqueValues.DeleteMany(ctx, bson.M{"formId": formID})
+        return err
+    })
 }
 
+func (r *StockRepository) FindStocks(formID uuid.UUID, q query.StockQuery) ([]entity.Stock, error) {
+	stocks := []entity.Stock{}
+	opts := options.Find().SetSort(q.BSONSort()).SetSkip(q.Offset)
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+
+	cursor, err := r.collection.Find(context.Background(), utils.NotDeleted(q.BSONFilter(formID)), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		var stock entity.Stock
+		if err := cursor.Decode(&stock); err != nil {
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return stocks, nil
+}
+
+// EachStock decodes the stocks one by one from a cursor
+func (r *StockRepository) EachStock(formID uuid.UUID, q query.StockQuery, fn func(entity.Stock) error) error {
+	opts := options.Find().SetSort(q.BSONSort()).SetSkip(q.Offset)
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+
+	cursor, err := r.collection.Find(context.Background(), utils.NotDeleted(q.BSONFilter(formID)), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		var stock entity.Stock
+		if err := cursor.Decode(&stock); err != nil {
+			return err
+		}
+		if err := fn(stock); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r *StockRepository) CountStocks(formID uuid.UUID, q query.StockQuery) (int64, error) {
+	return r.collection.CountDocuments(context.Background(), utils.NotDeleted(q.BSONFilter(formID)))
+}
+
+func (r *StockRepository) CountStocksAtLocation(locationID uuid.UUID) (int64, error) {
+	return r.collection.CountDocuments(context.Background(), locationQuantityFilter(locationID))
+}
+
+func (r *StockRepository) TrashStock(ctx context.Context, id uuid.UUID, deletedBy uuid.UUID, deletedAt time.Time) error {
+	result, err := r.collection.UpdateOne(ctx, utils.NotDeleted(bson.M{"_id": id}), utils.TrashUpdate(deletedBy, deletedAt))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+func (r *StockRepository) RestoreStock(id uuid.UUID) error {
+	result, err := r.collection.UpdateOne(context.Background(), utils.InTrash(bson.M{"_id": id}, time.Time{}), utils.RestoreUpdate())
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+func (r *StockRepository) GetTrashedStockByID(id uuid.UUID) (*entity.Stock, error) {
+	var stock entity.Stock
+	err := r.collection.FindOne(context.Background(), utils.InTrash(bson.M{"_id": id}, time.Time{})).Decode(&stock)
+	if err != nil {
+		return nil, err
+	}
+	return &stock, nil
+}
+func (r *StockRepository) GetTrashedStocks(formIDs []uuid.UUID, before time.Time, limit int64, offset int64) ([]entity.Stock, error) {
+	filter := bson.M{}
+	if formIDs != nil {
+		filter["formId"] = bson.M{"$in": formIDs}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}, {Key: "_id", Value: 1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := r.collection.Find(context.Background(), utils.InTrash(filter, before), opts)
+	if err != nil {
+		return nil, err
+	}
+	stocks := []entity.Stock{}
+	if err := cursor.All(context.Background(), &stocks); err != nil {
+		return nil, err
+	}
+	return stocks, nil
+}
+
+func (r *StockRepository) CountStocksWithDataKey(formID uuid.UUID, key string) (int64, error) {
+	return r.collection.CountDocuments(context.Background(), dataKeyFilter(formID, key))
+}
+
+// RenameDataKey claims the unique values of the stocks of a batch again in the
+// same transaction, since the kept value may not be the claimed one
+func (r *StockRepository) RenameDataKey(ctx context.Context, formID uuid.UUID, from string, to string, limit int64) (int64, error) {
+	var changed int64
+	err := r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		ids, err := r.stockIDsWithDataKey(ctx, formID, from, limit)
+		if err != nil || len(ids) == 0 {
+			changed = 0
+			return err
+		}
+		filter := dataKeyFilter(formID, from)
+		filter["_id"] = bson.M{"$in": ids}
+
+		// Stocks written under the new key in the meantime keep that value
+		both := bson.M{}
+		for key, value := range filter {
+			both[key] = value
+		}
+		both["data."+to] = bson.M{"$exists": true}
+		dropped, err := r.collection.UpdateMany(ctx, both, bson.M{
+			"$unset": bson.M{"data." + from: ""},
+			"$inc":   bson.M{"version": 1},
+		})
+		if err != nil {
+			return err
+		}
+
+		renamed, err := r.collection.UpdateMany(ctx, filter, bson.M{
+			"$rename": bson.M{"data." + from: "data." + to},
+			"$inc":    bson.M{"version": 1},
+		})
+		if err != nil {
+			return err
+		}
+		changed = dropped.ModifiedCount + renamed.ModifiedCount
+		return r.reclaimUniqueValues(ctx, ids)
+	})
+	return changed, err
+}
+
+// RemoveDataKey releases the values of the removed key in the same transaction
+// as the batch, by claiming the unique values of its stocks again
+func (r *StockRepository) RemoveDataKey(ctx context.Context, formID uuid.UUID, key string, archive bool, limit int64) (int64, error) {
+	var changed int64
+	err := r.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		ids, err := r.stockIDsWithDataKey(ctx, formID, key, limit)
+		if err != nil || len(ids) == 0 {
+			changed = 0
+			return err
+		}
+		filter := dataKeyFilter(formID, key)
+		filter["_id"] = bson.M{"$in": ids}
+
+		update := bson.M{"$unset": bson.M{"data." + key: ""}, "$inc": bson.M{"version": 1}}
+		if archive {
+			update = bson.M{"$rename": bson.M{"data." + key: "archived." + key}, "$inc": bson.M{"version": 1}}
+		}
+		result, err := r.collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		changed = result.ModifiedCount
+		return r.reclaimUniqueValues(ctx, ids)
+	})
+	return changed, err
+}
+
+// reclaimUniqueValues claims the unique values of the stocks with the given IDs
+// again from their stored data
+func (r *StockRepository) reclaimUniqueValues(ctx context.Context, ids []uuid.UUID) error {
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var stock entity.Stock
+		if err := cursor.Decode(&stock); err != nil {
+			return err
+		}
+		if err := r.claimUniqueValues(ctx, stock); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// stockIDsWithDataKey returns the IDs of at most limit stocks of a form whose
+// data holds the key
+func (r *StockRepository) stockIDsWithDataKey(ctx context.Context, formID uuid.UUID, key string, limit int64) ([]uuid.UUID, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, dataKeyFilter(formID, key), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var ids []uuid.UUID
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID uuid.UUID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.ID)
+	}
+	return ids, cursor.Err()
+}
+
+// dataKeyFilter matches the stocks of a form whose data holds the key, whether
+// they are in the trash or not
+func dataKeyFilter(formID uuid.UUID, key string) bson.M {
+	return bson.M{"formId": formID, "data." + key: bson.M{"$exists": true}}
+}
+
+// locationQuantityFilter matches stocks with a non zero quantity at the location
+func locationQuantityFilter(locationID uuid.UUID) bson.M {
+	return bson.M{"locations." + locationID.String(): bson.M{"$exists": true, "$ne": 0}}
+}
+
+// UniqueValuesCollection holds a document per value a stock holds for a unique
+// field of its form, as a UniqueValue. Its unique index on the form, field and
+// value enforces unique fields for every form at once, and stock writes keep it
+// in step in the same transaction.
+const UniqueValuesCollection = "unique_values"
+
+// UniqueValue claims the value of a unique field for a stock. The field is kept
+// by ID, so renaming it leaves its values claimed. A multiselect value is kept
+// whole, and the index holds each of its options.
+type UniqueValue struct {
+	FormID  uuid.UUID   `bson:"formId"`
+	FieldID uuid.UUID   `bson:"fieldId"`
+	StockID uuid.UUID   `bson:"stockId"`
+	Value   interface{} `bson:"value"`
+}
+
+// UniqueValueIndexes describes the indexes of the unique values collection
+func UniqueValueIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "formId", Value: 1}, {Key: "fieldId", Value: 1}, {Key: "value", Value: 1}},
+			Options: options.Index().SetName("unique_form_field_value").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "stockId", Value: 1}},
+			Options: options.Index().SetName("stock"),
+		},
+	}
+}
+
+// ClaimUniqueValues claims the values that the stocks of the form of a unique
+// field hold under the data key, including the stocks in the trash. It fails
+// with a duplicate error if two of them hold the same value.
+func ClaimUniqueValues(ctx context.Context, stocks *mongo.Collection, uniqueValues *mongo.Collection, field entity.Field, key string) error {
+	cursor, err := stocks.Find(ctx, dataKeyFilter(field.FormID, key), options.Find().SetProjection(bson.M{"data." + key: 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []interface{}
+	insert := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := uniqueValues.InsertMany(ctx, batch)
+		batch = batch[:0]
+		return utils.MapDuplicateKey(err, "Existing stocks hold duplicate values for "+field.Name)
+	}
+	for cursor.Next(ctx) {
+		var stock entity.Stock
+		if err := cursor.Decode(&stock); err != nil {
+			return err
+		}
+		batch = append(batch, UniqueValue{FormID: field.FormID, FieldID: field.ID, StockID: stock.ID, Value: stock.Data[key]})
+		if len(batch) == claimBatchSize {
+			if err := insert(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return insert()
+}
+
+// claimBatchSize is the number of values ClaimUniqueValues inserts at once
+const claimBatchSize = 1000
+
+// claimUniqueValues replaces the values a stock claims with the ones its data
+// holds for the unique fields of its form, including the fields in the trash
+func (r *StockRepository) claimUniqueValues(ctx context.Context, stock entity.Stock) error {
+	cursor, err := r.fields.Find(ctx, bson.M{"formId": stock.FormID, "isUnique": true}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return err
+	}
+	var fields []entity.Field
+	if err := cursor.All(ctx, &fields); err != nil {
+		return err
+	}
+
+	if _, err := r.uniqueValues.DeleteMany(ctx, bson.M{"stockId": stock.ID}); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		value, ok := stock.Data[field.Name]
+		if !ok {
+			continue
+		}
+		_, err := r.uniqueValues.InsertOne(ctx, UniqueValue{FormID: stock.FormID, FieldID: field.ID, StockID: stock.ID, Value: value})
+		if err != nil {
+			return utils.MapDuplicateKey(err, "Value for "+field.Name+" must be unique")
+		}
+	}
+	return nil
+}
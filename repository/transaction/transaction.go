@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs a function in a transaction. Repository methods that take a
+// context join the transaction when called with the context passed to fn, so
+// their changes are committed together or not at all. fn may run more than
+// once when the transaction is retried.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// hooksKey is the context key of the functions to run after a commit
+type hooksKey struct{}
+
+type hooks struct {
+	mu  sync.Mutex
+	fns []func() error
+}
+
+// WithHooks returns a context collecting the functions registered with
+// AfterCommit, to be run by RunHooks once the transaction is committed
+func WithHooks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hooksKey{}, &hooks{})
+}
+
+// AfterCommit runs fn once the transaction of ctx is committed, or right away
+// outside a transaction. It is meant for changes that cannot be part of a
+// transaction, such as dropping indexes.
+func AfterCommit(ctx context.Context, fn func() error) error {
+	h, ok := ctx.Value(hooksKey{}).(*hooks)
+	if !ok {
+		return fn()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = append(h.fns, fn)
+	return nil
+}
+
+// RunHooks runs the functions registered on ctx with AfterCommit and returns
+// the first error. The transaction stays committed even then.
+func RunHooks(ctx context.Context) error {
+	h, ok := ctx.Value(hooksKey{}).(*hooks)
+	if !ok {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var first error
+	for _, fn := range h.fns {
+		if err := fn(); err != nil && first == nil {
+			first = err
+		}
+	}
+	h.fns = nil
+	return first
+}
+
+// TransactionRepository is the MongoDB backed Transactor. Multi-document
+// transactions require MongoDB to run as a replica set or sharded cluster.
+type TransactionRepository struct {
+	client *mongo.Client
+}
+
+func NewTransactionRepository(db *mongo.Database) *TransactionRepository {
+	return &TransactionRepository{client: db.Client()}
+}
+
+// WithTransaction runs fn in a session transaction, which the driver retries
+// on transient errors
+func (r *TransactionRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		// Nested transactions are part of the outer one
+		return fn(ctx)
+	}
+
+	session, err := r.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	var committed context.Context
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Hooks of an attempt that was rolled back must not run
+		committed = WithHooks(sc)
+		return nil, fn(committed)
+	})
+	if err != nil {
+		return err
+	}
+	return RunHooks(committed)
+}
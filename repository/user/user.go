@@ -5,11 +5,19 @@ import (
 	"errors"
 
 	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// UserStore describes the storage operations available for users
+type UserStore interface {
+	CreateUser(user entity.User) (primitive.ObjectID, error)
+	GetUserByUsername(username string) (*entity.User, error)
+}
+
+// UserRepository is the MongoDB backed UserStore
 type UserRepository struct {
 	collection *mongo.Collection
 }
@@ -28,13 +36,13 @@ func (r *UserRepository) CreateUser(user entity.User) (primitive.ObjectID, error
 		return primitive.NilObjectID, err
 	}
 	if exists {
-		return primitive.NilObjectID, errors.New("username or name-surname combination already exists")
+		return primitive.NilObjectID, utils.DuplicateError("username or name-surname combination already exists")
 	}
 
-	// Insert the user into the database
+	// Insert the user into the database, the unique indexes catch concurrent registrations
 	result, err := r.collection.InsertOne(context.TODO(), user)
 	if err != nil {
-		return primitive.NilObjectID, err
+		return primitive.NilObjectID, utils.MapDuplicateKey(err, "username or name-surname combination already exists")
 	}
 
 	oid, ok := result.InsertedID.(primitive.ObjectID)
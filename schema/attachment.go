@@ -0,0 +1,128 @@
+package schema
+
+import (
+	"errors"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errAttachmentValue refuses attachment values in stock data, files are only
+// added and removed through the attachment endpoints of a stock
+var errAttachmentValue = errors.New("files of attachment fields are uploaded, not set in stock data")
+
+// validateAttachment checks the allowed content types, size limit and file
+// count of an attachment field
+func validateAttachment(field entity.Field) error {
+	for _, contentType := range field.AllowedTypes {
+		if !validContentType(contentType) {
+			return errors.New("allowedTypes must hold content types such as image/png or image/*, got " + strconv.Quote(contentType))
+		}
+	}
+	if field.MaxSize < 0 {
+		return errors.New("maxSize cannot be negative")
+	}
+	if len(field.Options) > 0 {
+		return errors.New("attachment cannot have options")
+	}
+	if field.DefaultValue != nil {
+		return errors.New("attachment cannot have a default value")
+	}
+	if field.IsUnique {
+		return errors.New("attachment cannot be unique")
+	}
+	if field.MinValue != nil {
+		// Files are uploaded one at a time
+		return errors.New("attachment cannot have a min value")
+	}
+	return validateCounts(field)
+}
+
+// validContentType accepts a media type without parameters, with * as the
+// subtype matching any
+func validContentType(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || len(params) > 0 {
+		return false
+	}
+	kind, subtype, ok := strings.Cut(mediaType, "/")
+	return ok && kind != "*" && subtype != ""
+}
+
+// AllowsContentType checks a content type against the allowed types of an
+// attachment field
+func AllowsContentType(field entity.Field, contentType string) bool {
+	if len(field.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range field.AllowedTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAttachment checks a new file against the content types, size limit and
+// file count of an attachment field already holding the attached files
+func CheckAttachment(field entity.Field, attached []entity.StockAttachment, attachment entity.StockAttachment) error {
+	if !AllowsContentType(field, attachment.ContentType) {
+		return errors.New(field.Name + " does not accept files of type " + attachment.ContentType + ", only " + strings.Join(field.AllowedTypes, ", "))
+	}
+	if field.MaxSize > 0 && attachment.Size > field.MaxSize {
+		return errors.New(field.Name + " accepts files of at most " + strconv.FormatInt(field.MaxSize, 10) + " bytes")
+	}
+	return checkCount(len(attached)+1, field, "files", "attached")
+}
+
+// Attachments reads an attachment value as stored, or as decoded by the
+// MongoDB driver
+func Attachments(value interface{}) ([]entity.StockAttachment, bool) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []entity.StockAttachment:
+		return v, true
+	case []interface{}:
+		items = v
+	case primitive.A:
+		items = v
+	default:
+		return nil, false
+	}
+	attachments := make([]entity.StockAttachment, len(items))
+	for i, item := range items {
+		var doc map[string]interface{}
+		switch v := item.(type) {
+		case map[string]interface{}:
+			doc = v
+		case primitive.M:
+			doc = v
+		default:
+			return nil, false
+		}
+		attachment := entity.StockAttachment{}
+		attachment.ID, _ = doc["id"].(string)
+		attachment.Name, _ = doc["name"].(string)
+		attachment.ContentType, _ = doc["contentType"].(string)
+		attachment.Checksum, _ = doc["checksum"].(string)
+		if size, ok := toFloat(doc["size"]); ok {
+			attachment.Size = int64(size)
+		}
+		if uploadedAt, ok := asTime(doc["uploadedAt"]); ok {
+			attachment.UploadedAt = uploadedAt
+		}
+		if attachment.ID == "" {
+			return nil, false
+		}
+		attachments[i] = attachment
+	}
+	return attachments, true
+}
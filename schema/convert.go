@@ -0,0 +1,160 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Convert turns a stored value into a value of the target field and checks it
+// against the field. A value whose text is a key of the mapping is replaced by
+// the mapped value first, which is how values are mapped to new options.
+func Convert(value interface{}, target entity.Field, mapping map[string]interface{}) (interface{}, error) {
+	if text, ok := Text(value); ok {
+		if mapped, exists := mapping[text]; exists {
+			value = mapped
+		}
+	}
+
+	converted, err := convertType(value, target.Type)
+	if err != nil {
+		return nil, err
+	}
+	if target.Type == entity.NumberDecimal {
+		if converted, err = toDecimal(converted, target); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidateValue(converted, target); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+// convertType converts a value to the Go type stock values of a field type have
+func convertType(value interface{}, fieldType entity.FieldType) (interface{}, error) {
+	switch fieldType {
+	case entity.Text, entity.Combobox:
+		if text, ok := Text(value); ok {
+			return text, nil
+		}
+	case entity.NumberDecimal:
+		// Text and decimals are parsed exactly by toDecimal
+		switch value.(type) {
+		case string, primitive.Decimal128:
+			return value, nil
+		}
+		if _, ok := toFloat(value); ok {
+			return value, nil
+		}
+	case entity.Number:
+		switch v := value.(type) {
+		case string:
+			number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, errors.New("cannot parse " + strconv.Quote(v) + " as a number")
+			}
+			return number, nil
+		default:
+			if number, ok := toFloat(value); ok {
+				return number, nil
+			}
+		}
+	case entity.Multiselect:
+		if selection, ok := Selection(value); ok {
+			return selection, nil
+		}
+		if text, ok := Text(value); ok {
+			return SplitSelection(text), nil
+		}
+	case entity.Date, entity.DateTime, entity.Time:
+		switch value.(type) {
+		case string, time.Time, primitive.DateTime:
+			return toTime(value, fieldType, time.Now())
+		}
+	case entity.Checkbox:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "1":
+				return true, nil
+			case "false", "no", "0":
+				return false, nil
+			}
+			return nil, errors.New("cannot parse " + strconv.Quote(v) + " as a boolean")
+		default:
+			if number, ok := toFloat(value); ok && (number == 0 || number == 1) {
+				return number == 1, nil
+			}
+		}
+	default:
+		return nil, errors.New("unknown field type")
+	}
+	return nil, errors.New("cannot convert " + typeName(value) + " to " + string(fieldType))
+}
+
+// Text formats a text, number, boolean, date or multiselect value as text
+func Text(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	if selection, ok := Selection(value); ok {
+		return JoinSelection(selection), true
+	}
+	if stored, ok := asTime(value); ok {
+		return stored.Format(DateTimeLayout), true
+	}
+	if d, ok := value.(primitive.Decimal128); ok {
+		return decimal.Text(d), true
+	}
+	if number, ok := toFloat(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case primitive.Decimal128:
+		return decimal.Float(v), true
+	}
+	return 0, false
+}
+
+// typeName describes the JSON type of a value for error messages
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "text"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := toFloat(value); ok {
+		return "number"
+	}
+	return "value"
+}
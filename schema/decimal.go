@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// hasDecimalAttributes reports whether a field sets any attribute that only
+// numberDecimal fields take
+func hasDecimalAttributes(field entity.Field) bool {
+	return field.Scale != nil || field.Precision != nil || field.Rounding != "" || field.MinDecimal != "" || field.MaxDecimal != ""
+}
+
+// validateDecimal checks the scale, precision, rounding mode and bounds of a
+// numberDecimal field, and that its default value fits them
+func validateDecimal(field entity.Field) error {
+	if field.Precision != nil && (*field.Precision < 1 || *field.Precision > decimal.MaxPrecision) {
+		return errors.New("precision must be between 1 and " + strconv.Itoa(decimal.MaxPrecision))
+	}
+	if field.Scale != nil {
+		if *field.Scale < 0 || *field.Scale > decimal.MaxPrecision {
+			return errors.New("scale must be between 0 and " + strconv.Itoa(decimal.MaxPrecision))
+		}
+		if field.Precision != nil && *field.Scale > *field.Precision {
+			return errors.New("scale cannot be greater than precision")
+		}
+	}
+	switch field.Rounding {
+	case "", entity.RoundHalfUp, entity.RoundHalfEven, entity.RoundHalfDown, entity.RoundUp, entity.RoundDown, entity.RoundCeiling, entity.RoundFloor:
+	default:
+		return errors.New("unknown rounding " + strconv.Quote(string(field.Rounding)) + ", expected halfUp, halfEven, halfDown, up, down, ceiling or floor")
+	}
+
+	if (field.MinValue != nil && field.MinDecimal != "") || (field.MaxValue != nil && *field.MaxValue != -1 && field.MaxDecimal != "") {
+		return errors.New("use either minValue and maxValue or minDecimal and maxDecimal")
+	}
+	if field.MinValue != nil && field.MaxValue != nil && *field.MaxValue != -1 && *field.MinValue > *field.MaxValue {
+		return errors.New("min value cannot be greater than max value")
+	}
+	var bounds [2]primitive.Decimal128
+	for i, bound := range []string{field.MinDecimal, field.MaxDecimal} {
+		if bound == "" {
+			continue
+		}
+		parsed, err := decimal.Parse(bound)
+		if err != nil {
+			return errors.New([]string{"minDecimal: ", "maxDecimal: "}[i] + err.Error())
+		}
+		bounds[i] = parsed
+	}
+	if field.MinDecimal != "" && field.MaxDecimal != "" {
+		if order, _ := decimal.Compare(bounds[0], bounds[1]); order > 0 {
+			return errors.New("minDecimal cannot be greater than maxDecimal")
+		}
+	}
+
+	if field.DefaultValue != nil {
+		if _, err := ParseValue(field.DefaultValue, field); err != nil {
+			return errors.New("invalid default value: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// toDecimal converts a number, or its text, to the decimal stored for a
+// numberDecimal field: rounded to the scale of the field, and to its precision
+// as long as only digits after the decimal point are dropped
+func toDecimal(value interface{}, field entity.Field) (primitive.Decimal128, error) {
+	parsed, err := decimal.Parse(value)
+	if err != nil {
+		return primitive.Decimal128{}, err
+	}
+	rounding := field.Rounding
+	if rounding == "" {
+		rounding = entity.RoundHalfUp
+	}
+	if field.Scale != nil {
+		if parsed, err = decimal.Round(parsed, *field.Scale, rounding); err != nil {
+			return primitive.Decimal128{}, err
+		}
+	}
+	if field.Precision == nil {
+		return parsed, nil
+	}
+
+	// Rounding can carry into another digit, as 9.99 does to 10.0, so digits
+	// are dropped until the value fits
+	for {
+		precision, scale := decimal.Digits(parsed)
+		if precision <= *field.Precision {
+			return parsed, nil
+		}
+		if field.Scale != nil || precision-scale > *field.Precision {
+			return primitive.Decimal128{}, errors.New("decimal number has more than " + strconv.Itoa(*field.Precision-fieldScale(field)) + " digits before the decimal point")
+		}
+		if parsed, err = decimal.Round(parsed, scale-(precision-*field.Precision), rounding); err != nil {
+			return primitive.Decimal128{}, err
+		}
+	}
+}
+
+// fieldScale returns the scale of a numberDecimal field, 0 when it has none
+func fieldScale(field entity.Field) int {
+	if field.Scale == nil {
+		return 0
+	}
+	return *field.Scale
+}
+
+// checkDecimalBounds checks a stored decimal against the bounds of its field
+func checkDecimalBounds(value primitive.Decimal128, field entity.Field) error {
+	if field.MinValue != nil {
+		if order, _ := decimal.Compare(value, *field.MinValue); order < 0 {
+			return errors.New("decimal number below minimum limit")
+		}
+	}
+	if field.MaxValue != nil && *field.MaxValue != -1 {
+		if order, _ := decimal.Compare(value, *field.MaxValue); order > 0 {
+			return errors.New("decimal number exceeds maximum limit")
+		}
+	}
+	if field.MinDecimal != "" {
+		if bound, err := decimal.Parse(field.MinDecimal); err == nil {
+			if order, _ := decimal.Compare(value, bound); order < 0 {
+				return errors.New("decimal number is below the minimum " + field.MinDecimal)
+			}
+		}
+	}
+	if field.MaxDecimal != "" {
+		if bound, err := decimal.Parse(field.MaxDecimal); err == nil {
+			if order, _ := decimal.Compare(value, bound); order > 0 {
+				return errors.New("decimal number is above the maximum " + field.MaxDecimal)
+			}
+		}
+	}
+	return nil
+}
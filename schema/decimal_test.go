@@ -0,0 +1,73 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/kbc0/DynamicStockManager/decimal"
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+func TestToDecimal(t *testing.T) {
+	two, four := 2, 4
+	tests := []struct {
+		name  string
+		value interface{}
+		field entity.Field
+		want  string // Empty when the value is rejected
+	}{
+		{"scale", "1.005", entity.Field{Scale: &two}, "1.01"},
+		{"scale adds zeros", 1.5, entity.Field{Scale: &two}, "1.50"},
+		{"rounding mode", "1.005", entity.Field{Scale: &two, Rounding: entity.RoundHalfEven}, "1.00"},
+		{"precision", "12.3456", entity.Field{Precision: &four}, "12.35"},
+		{"precision carrying into another digit", "99.996", entity.Field{Precision: &four}, "100.0"},
+		{"scale and precision", "12.345", entity.Field{Scale: &two, Precision: &four}, "12.35"},
+		{"too many digits before the point", "123.4", entity.Field{Scale: &two, Precision: &four}, ""},
+		{"too many digits without scale", "12345", entity.Field{Precision: &four}, ""},
+		{"not a number", "abc", entity.Field{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.field.Type = entity.NumberDecimal
+			d, err := toDecimal(test.value, test.field)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("accepted as %s", decimal.Text(d))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decimal.Text(d); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateDecimal(t *testing.T) {
+	zero, two, four, forty := 0, 2, 4, 40
+	tests := []struct {
+		name  string
+		field entity.Field
+		valid bool
+	}{
+		{"scale and precision", entity.Field{Scale: &two, Precision: &four}, true},
+		{"scale above precision", entity.Field{Scale: &four, Precision: &two}, false},
+		{"precision of zero", entity.Field{Precision: &zero}, false},
+		{"precision past decimal128", entity.Field{Precision: &forty}, false},
+		{"unknown rounding", entity.Field{Rounding: "bankers"}, false},
+		{"bounds", entity.Field{MinDecimal: "0.01", MaxDecimal: "99.99"}, true},
+		{"inverted bounds", entity.Field{MinDecimal: "2", MaxDecimal: "1"}, false},
+		{"invalid bound", entity.Field{MinDecimal: "x"}, false},
+		{"default value too large", entity.Field{Scale: &two, Precision: &four, DefaultValue: "123.4"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.field.Type = entity.NumberDecimal
+			if err := validateDecimal(test.field); test.valid != (err == nil) {
+				t.Fatalf("got %v, valid %v", err, test.valid)
+			}
+		})
+	}
+}
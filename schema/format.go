@@ -0,0 +1,166 @@
+package schema
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+// FormatError reports a text value that does not have the format of its field
+type FormatError struct {
+	Rule    entity.TextFormat // The format that failed, such as email or regex
+	Message string
+}
+
+func (e *FormatError) Error() string {
+	return e.Message
+}
+
+func formatError(rule entity.TextFormat, message string) error {
+	return &FormatError{Rule: rule, Message: message}
+}
+
+// phonePattern matches E.164 numbers: a plus and up to 15 digits, the first
+// of which is a country code that never starts with 0
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// patterns caches the compiled patterns of regex fields
+var patterns sync.Map
+
+// validateFormat checks the format of a text field, compiling its pattern, and
+// that its default value has the format
+func validateFormat(field entity.Field) error {
+	switch field.Format {
+	case "":
+		if field.Pattern != "" {
+			return errors.New("pattern requires the regex format")
+		}
+		return nil
+	case entity.FormatRegex:
+		if field.Pattern == "" {
+			return errors.New("the regex format requires a pattern")
+		}
+		if _, err := compilePattern(field.Pattern); err != nil {
+			return errors.New("invalid pattern: " + err.Error())
+		}
+	case entity.FormatEmail, entity.FormatURL, entity.FormatPhone, entity.FormatEAN13, entity.FormatUPCA, entity.FormatGTIN:
+		if field.Pattern != "" {
+			return errors.New("pattern requires the regex format")
+		}
+	default:
+		return errors.New("unknown format " + strconv.Quote(string(field.Format)) + ", expected email, url, phone, ean13, upca, gtin or regex")
+	}
+
+	if field.DefaultValue != nil {
+		text, ok := field.DefaultValue.(string)
+		if !ok {
+			return errors.New("default value must be a string")
+		}
+		if err := CheckFormat(text, field); err != nil {
+			return errors.New("invalid default value: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// compilePattern compiles a pattern matching whole values
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := patterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+	// The pattern is compiled alone first so errors refer to it as written
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// CheckFormat checks a text value against the format of its field, failing
+// with a *FormatError naming the format
+func CheckFormat(text string, field entity.Field) error {
+	switch field.Format {
+	case entity.FormatEmail:
+		address, err := mail.ParseAddress(text)
+		if err != nil || address.Address != text || !strings.Contains(text[strings.LastIndex(text, "@")+1:], ".") {
+			return formatError(field.Format, "value is not an email address")
+		}
+	case entity.FormatURL:
+		parsed, err := url.Parse(text)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || strings.ContainsAny(text, " \t\n") {
+			return formatError(field.Format, "value is not an http or https URL")
+		}
+	case entity.FormatPhone:
+		if !phonePattern.MatchString(text) {
+			return formatError(field.Format, "value is not an E.164 phone number such as +14155552671")
+		}
+	case entity.FormatEAN13:
+		return checkBarcode(text, field.Format, "an EAN-13 barcode", 13)
+	case entity.FormatUPCA:
+		return checkBarcode(text, field.Format, "a UPC-A barcode", 12)
+	case entity.FormatGTIN:
+		return checkBarcode(text, field.Format, "a GTIN", 8, 12, 13, 14)
+	case entity.FormatRegex:
+		compiled, err := compilePattern(field.Pattern)
+		if err != nil {
+			return err
+		}
+		if !compiled.MatchString(text) {
+			return formatError(field.Format, "value does not match the pattern "+field.Pattern)
+		}
+	}
+	return nil
+}
+
+// checkBarcode checks the length and the GS1 check digit of a barcode
+func checkBarcode(text string, rule entity.TextFormat, name string, lengths ...int) error {
+	validLength := false
+	for _, length := range lengths {
+		validLength = validLength || len(text) == length
+	}
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			validLength = false
+		}
+	}
+	if !validLength {
+		counts := strconv.Itoa(lengths[len(lengths)-1])
+		if len(lengths) > 1 {
+			shorter := make([]string, len(lengths)-1)
+			for i, length := range lengths[:len(lengths)-1] {
+				shorter[i] = strconv.Itoa(length)
+			}
+			counts = strings.Join(shorter, ", ") + " or " + counts
+		}
+		return formatError(rule, "value is not "+name+", expected "+counts+" digits")
+	}
+	if !validCheckDigit(text) {
+		return formatError(rule, "value is not "+name+", its check digit is wrong")
+	}
+	return nil
+}
+
+// validCheckDigit verifies the last digit of a GS1 barcode: the other digits
+// are weighted 3 and 1 alternately from the right, and the check digit rounds
+// their sum up to a multiple of 10
+func validCheckDigit(digits string) bool {
+	sum := 0
+	for i := len(digits) - 2; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if (len(digits)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(digits[len(digits)-1]-'0')
+}
@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+func TestCheckFormat(t *testing.T) {
+	tests := []struct {
+		format  entity.TextFormat
+		pattern string
+		value   string
+		valid   bool
+	}{
+		{entity.FormatEmail, "", "name@example.com", true},
+		{entity.FormatEmail, "", "Name <name@example.com>", false},
+		{entity.FormatEmail, "", "name@localhost", false},
+		{entity.FormatEmail, "", "name", false},
+		{entity.FormatURL, "", "https://example.com/a?b=c", true},
+		{entity.FormatURL, "", "ftp://example.com", false},
+		{entity.FormatURL, "", "https://", false},
+		{entity.FormatURL, "", "https://example.com/a b", false},
+		{entity.FormatPhone, "", "+14155552671", true},
+		{entity.FormatPhone, "", "+04155552671", false},
+		{entity.FormatPhone, "", "4155552671", false},
+		{entity.FormatPhone, "", "+1234567890123456", false},
+		{entity.FormatEAN13, "", "4006381333931", true},
+		{entity.FormatEAN13, "", "4006381333932", false},
+		{entity.FormatEAN13, "", "400638133393", false},
+		{entity.FormatEAN13, "", "40063813339a1", false},
+		{entity.FormatUPCA, "", "036000291452", true},
+		{entity.FormatUPCA, "", "036000291453", false},
+		{entity.FormatGTIN, "", "96385074", true},        // GTIN-8
+		{entity.FormatGTIN, "", "036000291452", true},    // GTIN-12
+		{entity.FormatGTIN, "", "4006381333931", true},   // GTIN-13
+		{entity.FormatGTIN, "", "10614141000415", true},  // GTIN-14
+		{entity.FormatGTIN, "", "10614141000416", false}, // Wrong check digit
+		{entity.FormatGTIN, "", "1234567890", false},     // No GTIN has 10 digits
+		{entity.FormatRegex, "[A-Z]{2}-[0-9]+", "AB-12", true},
+		{entity.FormatRegex, "[A-Z]{2}-[0-9]+", "xAB-12", false}, // Patterns match whole values
+		{entity.FormatRegex, "a|b", "ab", false},
+		{"", "", "anything", true},
+	}
+	for _, test := range tests {
+		t.Run(string(test.format)+" "+test.value, func(t *testing.T) {
+			err := CheckFormat(test.value, entity.Field{Type: entity.Text, Format: test.format, Pattern: test.pattern})
+			if test.valid != (err == nil) {
+				t.Fatalf("got %v, valid %v", err, test.valid)
+			}
+			var formatErr *FormatError
+			if err != nil && (!errors.As(err, &formatErr) || formatErr.Rule != test.format) {
+				t.Fatalf("got %#v, want a format error for %s", err, test.format)
+			}
+		})
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		field entity.Field
+		valid bool
+	}{
+		{"format", entity.Field{Format: entity.FormatEAN13}, true},
+		{"unknown format", entity.Field{Format: "isbn"}, false},
+		{"pattern without regex format", entity.Field{Pattern: "a+"}, false},
+		{"regex format without pattern", entity.Field{Format: entity.FormatRegex}, false},
+		{"invalid pattern", entity.Field{Format: entity.FormatRegex, Pattern: "a("}, false},
+		{"default with the format", entity.Field{Format: entity.FormatPhone, DefaultValue: "+14155552671"}, true},
+		{"default without the format", entity.Field{Format: entity.FormatPhone, DefaultValue: "555"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.field.Type = entity.Text
+			if err := validateFormat(test.field); test.valid != (err == nil) {
+				t.Fatalf("got %v, valid %v", err, test.valid)
+			}
+		})
+	}
+}
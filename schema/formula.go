@@ -0,0 +1,33 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+// errFormulaValue refuses formula values in stock data, they are computed
+var errFormulaValue = errors.New("values of formula fields are computed, not set in stock data")
+
+// validateFormula checks that a formula field has an expression and none of
+// the attributes of stored values. The expression itself is checked against
+// the other fields of the form by the formula package.
+func validateFormula(field entity.Field) error {
+	if strings.TrimSpace(field.Expression) == "" {
+		return errors.New("formula must have an expression")
+	}
+	if len(field.Options) > 0 {
+		return errors.New("formula cannot have options")
+	}
+	if field.DefaultValue != nil {
+		return errors.New("formula cannot have a default value")
+	}
+	if field.IsUnique {
+		return errors.New("formula cannot be unique")
+	}
+	if field.MinValue != nil || field.MaxValue != nil {
+		return errors.New("formula cannot have a min or max value")
+	}
+	return nil
+}
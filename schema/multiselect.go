@@ -0,0 +1,147 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SelectionSeparator separates the options of a multiselect value written as
+// text, such as in CSV files. Options cannot contain it.
+const SelectionSeparator = ","
+
+// Selection reads a multiselect value, an array of strings, as submitted in a
+// request or decoded by the MongoDB driver
+func Selection(value interface{}) ([]string, bool) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		items = v
+	case primitive.A:
+		items = v
+	default:
+		return nil, false
+	}
+	selection := make([]string, len(items))
+	for i, item := range items {
+		text, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		selection[i] = text
+	}
+	return selection, true
+}
+
+// SplitSelection reads a multiselect value written as text, with its options
+// separated by commas
+func SplitSelection(text string) []string {
+	selection := []string{}
+	for _, option := range strings.Split(text, SelectionSeparator) {
+		if option = strings.TrimSpace(option); option != "" {
+			selection = append(selection, option)
+		}
+	}
+	return selection
+}
+
+// JoinSelection writes a multiselect value as text
+func JoinSelection(selection []string) string {
+	return strings.Join(selection, SelectionSeparator+" ")
+}
+
+// validateMultiselect checks the options, selection counts and default value
+// of a multiselect field
+func validateMultiselect(field entity.Field) error {
+	if len(field.Options) == 0 || len(field.Options) > MaxOptions {
+		return errors.New("multiselect must have 1 to " + strconv.Itoa(MaxOptions) + " options")
+	}
+	seen := make(map[string]bool, len(field.Options))
+	for _, option := range field.Options {
+		if strings.TrimSpace(option) == "" {
+			return errors.New("multiselect options cannot be empty")
+		}
+		if strings.Contains(option, SelectionSeparator) {
+			return errors.New("multiselect option " + strconv.Quote(option) + " cannot contain " + strconv.Quote(SelectionSeparator))
+		}
+		if seen[option] {
+			return errors.New("multiselect option " + strconv.Quote(option) + " is listed more than once")
+		}
+		seen[option] = true
+	}
+
+	if err := validateCounts(field); err != nil {
+		return err
+	}
+	if field.MinValue != nil && *field.MinValue > len(field.Options) {
+		return errors.New("min value cannot be greater than the number of options")
+	}
+
+	if field.DefaultValue != nil {
+		selection, ok := Selection(field.DefaultValue)
+		if !ok {
+			return errors.New("default value must be an array of options")
+		}
+		if err := checkSelection(selection, field); err != nil {
+			return errors.New("default value: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// checkSelection checks the options of a multiselect value. Every option of a
+// unique field can only be selected by one stock, so a stock of a unique field
+// must select at least one option to be told apart.
+func checkSelection(selection []string, field entity.Field) error {
+	seen := make(map[string]bool, len(selection))
+	for _, option := range selection {
+		if !Contains(field.Options, option) {
+			return errors.New(strconv.Quote(option) + " is not a multiselect option")
+		}
+		if seen[option] {
+			return errors.New(strconv.Quote(option) + " is selected more than once")
+		}
+		seen[option] = true
+	}
+	if err := checkCount(len(selection), field, "options", "selected"); err != nil {
+		return err
+	}
+	if field.IsUnique && len(selection) == 0 {
+		return errors.New("a unique multiselect requires at least one selected option")
+	}
+	return nil
+}
+
+// validateCounts checks the bounds on the number of items in the values of a
+// multiselect or reference field
+func validateCounts(field entity.Field) error {
+	if field.MinValue != nil && *field.MinValue < 0 {
+		return errors.New("min value cannot be negative")
+	}
+	if field.MaxValue != nil && *field.MaxValue != -1 {
+		if *field.MaxValue < 1 {
+			return errors.New("max value must allow at least one item")
+		}
+		if field.MinValue != nil && *field.MinValue > *field.MaxValue {
+			return errors.New("min value cannot be greater than max value")
+		}
+	}
+	return nil
+}
+
+// checkCount checks the number of items in a multiselect or reference value,
+// such as "at least 2 options must be selected"
+func checkCount(count int, field entity.Field, items string, verb string) error {
+	if field.MinValue != nil && count < *field.MinValue {
+		return errors.New("at least " + strconv.Itoa(*field.MinValue) + " " + items + " must be " + verb)
+	}
+	if field.MaxValue != nil && *field.MaxValue != -1 && count > *field.MaxValue {
+		return errors.New("at most " + strconv.Itoa(*field.MaxValue) + " " + items + " can be " + verb)
+	}
+	return nil
+}
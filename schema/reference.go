@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+)
+
+// validateReference checks the target form, on-delete action and reference
+// counts of a reference field. Whether the target form exists is up to the
+// caller.
+func validateReference(field entity.Field) error {
+	if field.TargetFormID == nil || *field.TargetFormID == uuid.Nil {
+		return errors.New("reference must have a targetFormId")
+	}
+	switch field.OnDelete {
+	case "", entity.OnDeleteRestrict, entity.OnDeleteNullify, entity.OnDeleteCascade:
+	default:
+		return errors.New("onDelete must be restrict, nullify or cascade")
+	}
+	if len(field.Options) > 0 {
+		return errors.New("reference cannot have options")
+	}
+	if field.DefaultValue != nil {
+		return errors.New("reference cannot have a default value")
+	}
+	if field.IsUnique {
+		return errors.New("reference cannot be unique")
+	}
+	return validateCounts(field)
+}
+
+// References reads a reference value, an array of stock IDs, as submitted in a
+// request or stored, and returns the IDs in their canonical form
+func References(value interface{}) ([]string, error) {
+	items, ok := Selection(value)
+	if !ok {
+		return nil, errors.New("invalid data type for reference, expected an array of stock IDs")
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		id, err := uuid.Parse(item)
+		if err != nil {
+			return nil, errors.New(strconv.Quote(item) + " is not a stock ID")
+		}
+		ids[i] = id.String()
+	}
+	return ids, nil
+}
+
+// checkReferences checks the number of stock IDs in a reference value and
+// that none is repeated. Whether the stocks exist is up to the caller.
+func checkReferences(ids []string, field entity.Field) error {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return errors.New("stock " + id + " is referenced more than once")
+		}
+		seen[id] = true
+	}
+	return checkCount(len(ids), field, "stocks", "referenced")
+}
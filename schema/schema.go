@@ -0,0 +1,212 @@
+// Package schema checks field definitions and the stock values they accept
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxOptions is the most options a combobox or multiselect field can have
+const MaxOptions = 100
+
+// ValidateField checks the definition of a field for its type
+func ValidateField(field entity.Field) error {
+	if field.Type != entity.Text && (field.Format != "" || field.Pattern != "") {
+		return errors.New("only text fields can have a format")
+	}
+	if field.Type != entity.NumberDecimal && hasDecimalAttributes(field) {
+		return errors.New("only numberDecimal fields can have a scale, precision, rounding, minDecimal or maxDecimal")
+	}
+
+	switch field.Type {
+	case entity.Combobox:
+		if len(field.Options) == 0 || len(field.Options) > MaxOptions {
+			return errors.New("combobox must have 1 to " + strconv.Itoa(MaxOptions) + " options")
+		}
+		if defaultValue, ok := field.DefaultValue.(string); !ok || !Contains(field.Options, defaultValue) {
+			return errors.New("default value must be one of the provided options")
+		}
+	case entity.Text:
+		// Handling -1 as no limit for maxValue in text fields
+		if field.MinValue != nil && field.MaxValue != nil {
+			if *field.MaxValue == -1 {
+				field.MaxValue = nil // Set maxValue to nil indicating no upper limit
+			} else if *field.MinValue > *field.MaxValue {
+				return errors.New("min value cannot be greater than max value")
+			}
+		}
+		return validateFormat(field)
+	case entity.NumberDecimal:
+		return validateDecimal(field)
+	case entity.Number:
+		// Handling -1 as no limit for maxValue in number fields
+		if field.MinValue != nil && field.MaxValue != nil {
+			if *field.MaxValue == -1 {
+				field.MaxValue = nil // Set maxValue to nil indicating no upper limit
+			} else if *field.MinValue > *field.MaxValue {
+				return errors.New("min value cannot be greater than max value")
+			}
+		}
+	case entity.Multiselect:
+		return validateMultiselect(field)
+	case entity.Reference:
+		return validateReference(field)
+	case entity.Attachment:
+		return validateAttachment(field)
+	case entity.Formula:
+		return validateFormula(field)
+	case entity.Date, entity.DateTime, entity.Time:
+		return validateBounds(field)
+	case entity.Checkbox:
+	default:
+		return errors.New("unknown field type")
+	}
+
+	return nil
+}
+
+// ParseValue converts a value as submitted in a request to the value stored
+// for the field, such as ISO-8601 strings to dates, arrays of options to
+// string slices and numbers to rounded decimals, and validates it. References
+// to stocks are only checked for their format here.
+func ParseValue(value interface{}, field entity.Field) (interface{}, error) {
+	if number, ok := value.(json.Number); ok && field.Type != entity.NumberDecimal {
+		// Only decimals keep every digit of the request
+		parsed, err := number.Float64()
+		if err != nil {
+			return nil, errors.New("invalid number " + number.String())
+		}
+		value = parsed
+	}
+	if field.Type == entity.NumberDecimal {
+		parsed, err := toDecimal(value, field)
+		if err != nil {
+			return nil, err
+		}
+		value = parsed
+	}
+	if field.Type == entity.Multiselect {
+		if selection, ok := Selection(value); ok {
+			value = selection
+		}
+	}
+	if field.Type == entity.Reference {
+		ids, err := References(value)
+		if err != nil {
+			return nil, err
+		}
+		value = ids
+	}
+	if IsTemporal(field.Type) {
+		parsed, err := toTime(value, field.Type, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		value = parsed
+	}
+	if err := ValidateValue(value, field); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// ValidateValue checks if the given stock value is valid for the field type
+func ValidateValue(value interface{}, field entity.Field) error {
+	switch field.Type {
+	case entity.Combobox:
+		valStr, ok := value.(string)
+		if !ok {
+			return errors.New("invalid data type for combobox, expected string")
+		}
+		if !Contains(field.Options, valStr) {
+			return errors.New("value not in combobox options")
+		}
+
+	case entity.Text:
+		valStr, ok := value.(string)
+		if !ok {
+			return errors.New("invalid data type for text, expected string")
+		}
+		if field.MinValue != nil && len(valStr) < *field.MinValue {
+			return errors.New("text length below minimum limit")
+		}
+		if field.MaxValue != nil && *field.MaxValue != -1 && len(valStr) > *field.MaxValue {
+			return errors.New("text length exceeds maximum limit")
+		}
+		return CheckFormat(valStr, field)
+
+	case entity.Checkbox:
+		if _, ok := value.(bool); !ok {
+			return errors.New("invalid data type for checkbox, expected boolean")
+		}
+
+	case entity.Number:
+		valFloat, ok := value.(float64) // JSON numbers are decoded as float64 by default
+		if !ok {
+			return errors.New("invalid data type for number, expected integer")
+		}
+		valInt := int(valFloat) // Convert float64 to int; ensure it is a natural number
+		if float64(valInt) != valFloat {
+			return errors.New("invalid number value, expected integer without fractional part")
+		}
+		if field.MinValue != nil && valInt < *field.MinValue {
+			return errors.New("number below minimum limit")
+		}
+		if field.MaxValue != nil && *field.MaxValue != -1 && valInt > *field.MaxValue {
+			return errors.New("number exceeds maximum limit")
+		}
+
+	case entity.NumberDecimal:
+		valDecimal, ok := value.(primitive.Decimal128)
+		if !ok {
+			return errors.New("invalid data type for numberDecimal, expected decimal number")
+		}
+		return checkDecimalBounds(valDecimal, field)
+
+	case entity.Multiselect:
+		selection, ok := Selection(value)
+		if !ok {
+			return errors.New("invalid data type for multiselect, expected an array of strings")
+		}
+		return checkSelection(selection, field)
+
+	case entity.Reference:
+		ids, err := References(value)
+		if err != nil {
+			return err
+		}
+		return checkReferences(ids, field)
+
+	case entity.Attachment:
+		return errAttachmentValue
+
+	case entity.Formula:
+		return errFormulaValue
+
+	case entity.Date, entity.DateTime, entity.Time:
+		valTime, ok := asTime(value)
+		if !ok {
+			return errors.New("invalid data type for " + string(field.Type) + ", expected an ISO-8601 string")
+		}
+		return checkTimeBounds(valTime, field)
+
+	default:
+		return errors.New("unknown field type")
+	}
+	return nil
+}
+
+// Contains checks if a slice contains a specific string
+func Contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kbc0/DynamicStockManager/entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Layouts accepted for values of the temporal field types, all ISO-8601. Times
+// without an offset are taken as UTC.
+var (
+	dateLayouts     = []string{"2006-01-02"}
+	dateTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02T15:04", "2006-01-02"}
+	timeLayouts     = []string{"15:04:05.999999999", "15:04"}
+)
+
+// Layouts values of the temporal field types are formatted with
+const (
+	DateLayout     = "2006-01-02"
+	DateTimeLayout = time.RFC3339Nano
+	TimeLayout     = "15:04:05.999999999"
+)
+
+// IsTemporal reports whether values of the field type are stored as dates
+func IsTemporal(fieldType entity.FieldType) bool {
+	return fieldType == entity.Date || fieldType == entity.DateTime || fieldType == entity.Time
+}
+
+// ParseTime parses an ISO-8601 value of a temporal field type, or a relative
+// one: "now" or "today", optionally followed by an offset such as "+7d" or
+// "-1m", in hours (h), days (d), weeks (w), months (m) or years (y). The result
+// is in UTC with the millisecond precision of BSON dates. Dates are kept at
+// midnight and times of day on January 1, 1970.
+func ParseTime(text string, fieldType entity.FieldType, now time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	value, err := parseRelative(text, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if value.IsZero() {
+		value, err = parseLayouts(text, fieldType)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return truncate(value, fieldType), nil
+}
+
+func parseLayouts(text string, fieldType entity.FieldType) (time.Time, error) {
+	layouts, expected := dateTimeLayouts, "an ISO-8601 date and time such as 2024-05-31T14:30:00Z"
+	switch fieldType {
+	case entity.Date:
+		layouts, expected = dateLayouts, "an ISO-8601 date such as 2024-05-31"
+	case entity.Time:
+		layouts, expected = timeLayouts, "an ISO-8601 time of day such as 14:30:00"
+	}
+	for _, layout := range layouts {
+		if value, err := time.Parse(layout, text); err == nil {
+			return value, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid " + string(fieldType) + " " + strconv.Quote(text) + ", expected " + expected + ", now or today")
+}
+
+// parseRelative resolves "now" and "today" with an optional offset, returning
+// a zero time for any other text
+func parseRelative(text string, now time.Time) (time.Time, error) {
+	lower := strings.ToLower(text)
+	var base time.Time
+	switch {
+	case strings.HasPrefix(lower, "now"):
+		base, lower = now.UTC(), lower[len("now"):]
+	case strings.HasPrefix(lower, "today"):
+		base, lower = truncate(now, entity.Date), lower[len("today"):]
+	default:
+		return time.Time{}, nil
+	}
+	if lower == "" {
+		return base, nil
+	}
+
+	invalid := errors.New("invalid relative time " + strconv.Quote(text) + ", expected an offset such as +7d")
+	if len(lower) < 3 || (lower[0] != '+' && lower[0] != '-') {
+		return time.Time{}, invalid
+	}
+	amount, err := strconv.Atoi(lower[1 : len(lower)-1])
+	if err != nil {
+		return time.Time{}, invalid
+	}
+	if lower[0] == '-' {
+		amount = -amount
+	}
+	switch lower[len(lower)-1] {
+	case 'h':
+		return base.Add(time.Duration(amount) * time.Hour), nil
+	case 'd':
+		return base.AddDate(0, 0, amount), nil
+	case 'w':
+		return base.AddDate(0, 0, 7*amount), nil
+	case 'm':
+		return base.AddDate(0, amount, 0), nil
+	case 'y':
+		return base.AddDate(amount, 0, 0), nil
+	}
+	return time.Time{}, invalid
+}
+
+// truncate reduces a time to what the field type keeps of it
+func truncate(value time.Time, fieldType entity.FieldType) time.Time {
+	value = value.UTC()
+	switch fieldType {
+	case entity.Date:
+		return time.Date(value.Year(), value.Month(), value.Day(), 0, 0, 0, 0, time.UTC)
+	case entity.Time:
+		return time.Date(1970, time.January, 1, value.Hour(), value.Minute(), value.Second(), value.Nanosecond(), time.UTC).Truncate(time.Millisecond)
+	}
+	return value.Truncate(time.Millisecond)
+}
+
+// asTime reads a stored temporal value, which the MongoDB driver decodes as a
+// primitive.DateTime
+func asTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), true
+	case primitive.DateTime:
+		return v.Time().UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// toTime converts a submitted or stored value of a temporal field type to the
+// time stored for it
+func toTime(value interface{}, fieldType entity.FieldType, now time.Time) (time.Time, error) {
+	if text, ok := value.(string); ok {
+		return ParseTime(text, fieldType, now)
+	}
+	if stored, ok := asTime(value); ok {
+		return truncate(stored, fieldType), nil
+	}
+	return time.Time{}, errors.New("invalid data type for " + string(fieldType) + ", expected an ISO-8601 string")
+}
+
+// FormatTime formats a stored value of a temporal field type the way it is
+// submitted
+func FormatTime(value interface{}, fieldType entity.FieldType) (string, bool) {
+	stored, ok := asTime(value)
+	if !ok {
+		return "", false
+	}
+	switch fieldType {
+	case entity.Date:
+		return stored.Format(DateLayout), true
+	case entity.Time:
+		return stored.Format(TimeLayout), true
+	}
+	return stored.Format(DateTimeLayout), true
+}
+
+// validateBounds checks the bounds of a temporal field, which may be relative
+func validateBounds(field entity.Field) error {
+	now := time.Now()
+	var minTime, maxTime time.Time
+	var err error
+	if field.MinDate != "" {
+		if minTime, err = ParseTime(field.MinDate, field.Type, now); err != nil {
+			return errors.New("minDate: " + err.Error())
+		}
+	}
+	if field.MaxDate != "" {
+		if maxTime, err = ParseTime(field.MaxDate, field.Type, now); err != nil {
+			return errors.New("maxDate: " + err.Error())
+		}
+	}
+	if field.MinDate != "" && field.MaxDate != "" && minTime.After(maxTime) {
+		return errors.New("minDate cannot be later than maxDate")
+	}
+	if field.DefaultValue != nil {
+		if _, err := toTime(field.DefaultValue, field.Type, now); err != nil {
+			return errors.New("default value: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// checkTimeBounds checks a stored temporal value against the bounds of its
+// field, resolving relative bounds at the current time
+func checkTimeBounds(value time.Time, field entity.Field) error {
+	now := time.Now()
+	if field.MinDate != "" {
+		if bound, err := ParseTime(field.MinDate, field.Type, now); err == nil && value.Before(bound) {
+			return errors.New(string(field.Type) + " is before the minimum " + field.MinDate)
+		}
+	}
+	if field.MaxDate != "" {
+		if bound, err := ParseTime(field.MaxDate, field.Type, now); err == nil && value.After(bound) {
+			return errors.New(string(field.Type) + " is after the maximum " + field.MaxDate)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/export"
+	"github.com/kbc0/DynamicStockManager/query"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+)
+
+// failingStocks fails to read stocks after the first one, as storage failing
+// halfway through an export does
+type failingStocks struct {
+	stockRepo.StockStore
+}
+
+func (s failingStocks) EachStock(formID uuid.UUID, q query.StockQuery, fn func(entity.Stock) error) error {
+	read := 0
+	return s.StockStore.EachStock(formID, q, func(stock entity.Stock) error {
+		if read++; read > 1 {
+			return errors.New("connection lost")
+		}
+		return fn(stock)
+	})
+}
+
+func TestExport(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	form := c.form(
+		map[string]interface{}{"name": "sku", "type": "text", "order": 1},
+		map[string]interface{}{"name": "qty", "type": "number", "order": 2},
+		map[string]interface{}{"name": "secret", "type": "text", "order": 3, "isHidden": true},
+	)
+	c.stock(form, map[string]interface{}{"sku": "A,1", "qty": 2, "secret": "x"})
+	c.stock(form, map[string]interface{}{"sku": "B", "qty": 3})
+
+	code, raw := c.do("GET", form+"/stock/export?sort=sku", nil)
+	if code != 200 {
+		t.Fatalf("got %d %s", code, raw)
+	}
+	records, err := csv.NewReader(strings.NewReader(raw)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"sku", "qty"}, {"A,1", "2"}, {"B", "3"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("got %q, want %q", records, want)
+	}
+
+	code, raw = c.do("GET", form+"/stock/export?format=xlsx&includeHidden=true", nil)
+	if code != 200 {
+		t.Fatalf("got %d %s", code, raw)
+	}
+	if _, err := zip.NewReader(bytes.NewReader([]byte(raw)), int64(len(raw))); err != nil {
+		t.Fatalf("workbook does not open: %v", err)
+	}
+}
+
+func TestExportCutShort(t *testing.T) {
+	repos := NewMemoryRepositories()
+	repos.Stocks = failingStocks{repos.Stocks}
+	c := newClientWith(t, repos)
+	c.register("alice")
+	form := c.form(map[string]interface{}{"name": "sku", "type": "text"})
+	for _, sku := range []string{"A", "B"} {
+		c.expect(201, "POST", form+"/stock", map[string]interface{}{"sku": sku}, nil)
+	}
+
+	_, raw := c.do("GET", form+"/stock/export", nil)
+	reader := csv.NewReader(strings.NewReader(raw))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[2][0] != export.ErrorMarker {
+		t.Fatalf("csv cut short without a marker: %q", records)
+	}
+
+	_, raw = c.do("GET", form+"/stock/export?format=xlsx", nil)
+	if _, err := zip.NewReader(bytes.NewReader([]byte(raw)), int64(len(raw))); err == nil {
+		t.Fatal("a workbook cut short opens")
+	}
+}
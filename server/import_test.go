@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	stockHandler "github.com/kbc0/DynamicStockManager/handler/stock"
+)
+
+// upload posts the content as the multipart file field "file"
+func (c *client) upload(path, name, contentType string, content []byte) (int, string) {
+	c.t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := make(map[string][]string)
+	header["Content-Disposition"] = []string{`form-data; name="file"; filename="` + name + `"`}
+	header["Content-Type"] = []string{contentType}
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.srv.App.Test(req, -1)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(raw)
+}
+
+func (c *client) importCSV(formPath, query, content string) stockHandler.ImportReport {
+	c.t.Helper()
+	code, raw := c.upload(formPath+"/stock/import"+query, "stock.csv", "text/csv", []byte(content))
+	if code != 200 && code != 201 {
+		c.t.Fatalf("import: got %d %s", code, raw)
+	}
+	var report stockHandler.ImportReport
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		c.t.Fatalf("import: %v in %s", err, raw)
+	}
+	return report
+}
+
+func TestImport(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	form := c.form(
+		map[string]interface{}{"name": "sku", "type": "text", "isUnique": true},
+		map[string]interface{}{"name": "qty", "type": "number", "minValue": 0},
+		map[string]interface{}{"name": "active", "type": "checkbox", "defaultValue": true},
+	)
+	c.stock(form, map[string]interface{}{"sku": "A0", "qty": 1})
+
+	content := "\ufeffSKU,qty,active\n" +
+		"A1,3,yes\n" + // line 2
+		"A1,2,no\n" + // duplicate of line 2
+		"A0,1,\n" + // duplicate of a stored stock
+		"A2,x,\n" + // not a number
+		"A3,-1,\n" + // below the minimum
+		"A4,5\n" + // missing cell
+		"A5,7,true\n"
+	report := c.importCSV(form, "?dryRun=true", content)
+	if !report.DryRun || report.Total != 7 || report.Valid != 2 || report.Imported != 0 || len(report.Errors) != 5 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	for i, row := range []int{3, 4, 5, 6, 7} {
+		if report.Errors[i].Row != row {
+			t.Fatalf("error %d on row %d, want %d: %+v", i, report.Errors[i].Row, row, report.Errors)
+		}
+	}
+	var stocks []struct{}
+	c.expect(200, "GET", form+"/stock", nil, &stocks)
+	if len(stocks) != 1 {
+		t.Fatalf("dry run stored stocks: %d", len(stocks))
+	}
+
+	report = c.importCSV(form, "", content)
+	if report.Imported != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	c.expect(200, "GET", form+"/stock", nil, &stocks)
+	if len(stocks) != 3 {
+		t.Fatalf("got %d stocks, want 3", len(stocks))
+	}
+
+	if code, raw := c.upload(form+"/stock/import", "stock.csv", "text/csv", []byte("sku,bogus\n")); code != 400 {
+		t.Fatalf("unknown column: got %d %s", code, raw)
+	}
+}
+
+func TestImportMalformedRow(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	form := c.form(map[string]interface{}{"name": "sku", "type": "text"})
+
+	// A bare quote on line 3 fails to parse, the rows around it are imported
+	report := c.importCSV(form, "", "sku\nB1\nB\"2\nB3\n")
+	if report.Total != 3 || report.Imported != 2 || len(report.Errors) != 1 || report.Errors[0].Row != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// So does a quoted field left open up to the end of the file
+	report = c.importCSV(form, "", "sku\nC1\n\"C2\n")
+	if report.Total != 2 || report.Imported != 1 || len(report.Errors) != 1 || report.Errors[0].Row != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import "testing"
+
+// TestNameOfUnfinishedJob checks that a name stays taken while a job still
+// migrates stock data under it. Jobs are not started by the tests, so they
+// stay pending.
+func TestNameOfUnfinishedJob(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	form := c.form(map[string]interface{}{"name": "sku", "type": "text"})
+	c.stock(form, map[string]interface{}{"sku": "A-1"})
+
+	var fields []struct{ ID string }
+	c.expect(200, "GET", form+"/field", nil, &fields)
+	field := form + "/field/" + fields[0].ID
+	c.expect(200, "PUT", field, map[string]string{"name": "code"}, nil)
+
+	c.expect(409, "POST", form+"/field", map[string]string{"name": "sku", "type": "text"}, nil)
+	c.expect(409, "PUT", field, map[string]string{"name": "sku"}, nil)
+	c.expect(200, "PUT", field, map[string]string{"name": "article"}, nil)
+	c.expect(201, "POST", form+"/field", map[string]string{"name": "other", "type": "text"}, nil)
+}
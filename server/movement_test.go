@@ -0,0 +1,39 @@
+package server
+
+import "testing"
+
+func TestMovements(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	var warehouse, store struct{ ID string }
+	c.expect(201, "POST", "/api/v1/location", map[string]interface{}{"name": "WH1", "kind": "warehouse"}, &warehouse)
+	c.expect(201, "POST", "/api/v1/location", map[string]interface{}{"name": "WH2", "kind": "warehouse"}, &store)
+	stock := c.stock(c.form(map[string]interface{}{"name": "sku", "type": "text"}), map[string]interface{}{"sku": "bolt"})
+
+	var movement struct{ BalanceAfter int64 }
+	c.expect(201, "POST", stock+"/movement", map[string]interface{}{"type": "receipt", "quantity": 5, "reason": "purchase", "locationId": warehouse.ID}, &movement)
+	// The quantity is held at the warehouse, so an issue without a location
+	// cannot take it
+	c.expect(409, "POST", stock+"/movement", map[string]interface{}{"type": "issue", "quantity": 5, "reason": "sale"}, nil)
+	c.expect(409, "POST", stock+"/movement", map[string]interface{}{"type": "issue", "quantity": 1, "reason": "sale", "locationId": store.ID}, nil)
+	c.expect(201, "POST", stock+"/transfer", map[string]interface{}{"from": warehouse.ID, "to": store.ID, "quantity": 2}, nil)
+	c.expect(409, "POST", stock+"/transfer", map[string]interface{}{"from": warehouse.ID, "to": store.ID, "quantity": 4}, nil)
+	c.expect(201, "POST", stock+"/movement", map[string]interface{}{"type": "issue", "quantity": 3, "reason": "sale", "locationId": warehouse.ID}, &movement)
+	if movement.BalanceAfter != 2 {
+		t.Fatalf("got balance %d, want 2", movement.BalanceAfter)
+	}
+
+	var stored struct {
+		OnHand    int64
+		Locations map[string]int64
+	}
+	c.expect(200, "GET", stock, nil, &stored)
+	if stored.OnHand != 2 || stored.Locations[warehouse.ID] != 0 || stored.Locations[store.ID] != 2 {
+		t.Fatalf("unexpected quantities %+v", stored)
+	}
+	var ledger struct{ Movements []struct{} }
+	c.expect(200, "GET", stock+"/movement", nil, &ledger)
+	if len(ledger.Movements) != 4 {
+		t.Fatalf("got %d movements, want 4", len(ledger.Movements))
+	}
+}
@@ -1,35 +1,122 @@
 package server
 
 import (
+	"context"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/rs/zerolog"
-	"go.mongodb.org/mongo-driver/mongo"
-	userHandler "github.com/kbc0/DynamicStockManager/handler/user"
-	formHandler "github.com/kbc0/DynamicStockManager/handler/form"
+	"github.com/kbc0/DynamicStockManager/audit"
+	"github.com/kbc0/DynamicStockManager/blob"
+	"github.com/kbc0/DynamicStockManager/config"
+	"github.com/kbc0/DynamicStockManager/formula"
+	auditHandler "github.com/kbc0/DynamicStockManager/handler/audit"
 	fieldHandler "github.com/kbc0/DynamicStockManager/handler/field"
+	formHandler "github.com/kbc0/DynamicStockManager/handler/form"
+	jobHandler "github.com/kbc0/DynamicStockManager/handler/job"
+	locationHandler "github.com/kbc0/DynamicStockManager/handler/location"
+	movementHandler "github.com/kbc0/DynamicStockManager/handler/movement"
 	stockHandler "github.com/kbc0/DynamicStockManager/handler/stock" // Import the stock handler
+	trashHandler "github.com/kbc0/DynamicStockManager/handler/trash"
+	userHandler "github.com/kbc0/DynamicStockManager/handler/user"
+	"github.com/kbc0/DynamicStockManager/job"
 	"github.com/kbc0/DynamicStockManager/middleware"
-	userRepo "github.com/kbc0/DynamicStockManager/repository/user"
-	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	"github.com/kbc0/DynamicStockManager/reference"
+	auditRepo "github.com/kbc0/DynamicStockManager/repository/audit"
 	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	jobRepo "github.com/kbc0/DynamicStockManager/repository/job"
+	locationRepo "github.com/kbc0/DynamicStockManager/repository/location"
+	memoryRepo "github.com/kbc0/DynamicStockManager/repository/memory"
+	movementRepo "github.com/kbc0/DynamicStockManager/repository/movement"
+	revisionRepo "github.com/kbc0/DynamicStockManager/repository/revision"
 	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock" // Import the stock repository
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	userRepo "github.com/kbc0/DynamicStockManager/repository/user"
+	"github.com/kbc0/DynamicStockManager/trash"
+	"github.com/kbc0/DynamicStockManager/utils"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Repositories groups the storage backends the server depends on
+type Repositories struct {
+	Users     userRepo.UserStore
+	Forms     formRepo.FormStore
+	Fields    fieldRepo.FieldStore
+	Stocks    stockRepo.StockStore
+	Movements movementRepo.MovementStore
+	Locations locationRepo.LocationStore
+	Audit     auditRepo.AuditStore
+	Revisions revisionRepo.RevisionStore
+	Jobs      jobRepo.JobStore
+	// Transactions groups changes across the stores into one transaction
+	Transactions transaction.Transactor
+	// Blobs keeps the files of attachment fields
+	Blobs blob.Store
+}
+
+// NewMongoRepositories creates the MongoDB backed repositories for a database,
+// keeping files in the given blob store
+func NewMongoRepositories(db *mongo.Database, blobs blob.Store) Repositories {
+	return Repositories{
+		Users:        userRepo.NewUserRepository(db),
+		Forms:        formRepo.NewFormRepository(db),
+		Fields:       fieldRepo.NewFieldRepository(db),
+		Stocks:       stockRepo.NewStockRepository(db),
+		Movements:    movementRepo.NewMovementRepository(db),
+		Locations:    locationRepo.NewLocationRepository(db),
+		Audit:        auditRepo.NewAuditRepository(db),
+		Revisions:    revisionRepo.NewRevisionRepository(db),
+		Jobs:         jobRepo.NewJobRepository(db),
+		Transactions: transaction.NewTransactionRepository(db),
+		Blobs:        blobs,
+	}
+}
+
+// NewMemoryRepositories creates repositories sharing one in-memory store, with
+// files kept in memory too, which lets the whole API run without MongoDB in
+// tests and local demos
+func NewMemoryRepositories() Repositories {
+	store := memoryRepo.NewStore()
+	return Repositories{
+		Users:        memoryRepo.NewUserRepository(store),
+		Forms:        memoryRepo.NewFormRepository(store),
+		Fields:       memoryRepo.NewFieldRepository(store),
+		Stocks:       memoryRepo.NewStockRepository(store),
+		Movements:    memoryRepo.NewMovementRepository(store),
+		Locations:    memoryRepo.NewLocationRepository(store),
+		Audit:        memoryRepo.NewAuditRepository(store),
+		Revisions:    memoryRepo.NewRevisionRepository(store),
+		Jobs:         memoryRepo.NewJobRepository(store),
+		Transactions: memoryRepo.NewTransactionRepository(store),
+		Blobs:        blob.NewMemoryStore(),
+	}
+}
+
 type Server struct {
 	App    *fiber.App
-	DB     *mongo.Database
+	Config *config.Config
+	Repos  Repositories
+	Tokens *utils.TokenManager
+	Jobs   *job.Runner
+	Purger *trash.Purger
 	logger *zerolog.Logger
 }
 
-func NewServer(db *mongo.Database, logger *zerolog.Logger) *Server {
+func NewServer(cfg *config.Config, repos Repositories, logger *zerolog.Logger) *Server {
 	logger.Info().Msg("Server is created")
-	app := fiber.New()
+	// Uploaded files are limited by the size of the request body
+	app := fiber.New(fiber.Config{BodyLimit: int(cfg.Blobs.MaxUploadSize)})
 
-	middleware.RegisterMiddleware(app) // Assume middleware setup is already in place
+	middleware.RegisterMiddleware(app, cfg.JWT.Secret)
 
+	jobs := job.NewRunner(repos.Jobs, repos.Stocks)
 	srv := &Server{
 		App:    app,
-		DB:     db,
+		Config: cfg,
+		Repos:  repos,
+		Tokens: utils.NewTokenManager(cfg.JWT.Secret, cfg.JWT.TTL),
+		Jobs:   jobs,
+		Purger: trash.NewPurger(repos.Forms, repos.Fields, repos.Stocks, repos.Movements, repos.Revisions, repos.Transactions, jobs, repos.Blobs),
 		logger: logger,
 	}
 
@@ -38,39 +125,111 @@ func NewServer(db *mongo.Database, logger *zerolog.Logger) *Server {
 	return srv
 }
 
+// StartTrashPurge purges the trash in the background, deleting everything that
+// has been in it for longer than the configured retention, until the context
+// is cancelled. A zero retention keeps the trash until it is purged by hand.
+func (srv *Server) StartTrashPurge(ctx context.Context) {
+	if srv.Config.Trash.Retention <= 0 {
+		return
+	}
+	go srv.Purger.Run(ctx, srv.Config.Trash.Retention, srv.Config.Trash.PurgeInterval, srv.logger)
+}
+
+// StartJobs runs the background jobs migrating stock data, resuming the ones
+// left unfinished, until the context is cancelled
+func (srv *Server) StartJobs(ctx context.Context) {
+	go srv.Jobs.Run(ctx, srv.logger)
+}
+
 func (srv *Server) registerRoutes() {
 	// User related routes setup
-	userRepo := userRepo.NewUserRepository(srv.DB)
-	userHandler := userHandler.NewUserHandler(userRepo)
+	userHandler := userHandler.NewUserHandler(srv.Repos.Users, srv.Tokens)
 	srv.App.Post("/api/v1/register", userHandler.RegisterUser)
 	srv.App.Post("/api/v1/login", userHandler.LoginUser)
 	srv.App.Get("/api/v1/account", userHandler.GetAccount)
 
+	// Every route below that addresses a form is only served to its owner
+	access := middleware.NewFormAccess(srv.Repos.Forms, srv.Repos.Fields, srv.Repos.Stocks)
+	requireForm := access.RequireForm
+	requireField := access.RequireField
+	requireStock := access.RequireStock
+
+	// Every change to forms, fields and stocks is recorded in the audit log
+	recorder := audit.NewRecorder(srv.Repos.Audit)
+
+	// Reference fields link stocks across the forms of a user
+	references := reference.NewResolver(srv.Repos.Forms, srv.Repos.Fields, srv.Repos.Stocks, srv.Repos.Transactions)
+
+	// Formula fields are computed again for every stock when they change
+	formulas := formula.NewUpdater(srv.Repos.Stocks, srv.Repos.Transactions)
+
 	// Field related routes setup
-	fieldRepo := fieldRepo.NewFieldRepository(srv.DB)
-	fieldHandler := fieldHandler.NewFieldHandler(fieldRepo)
-	srv.App.Post("/api/v1/form/:_id/field", fieldHandler.AddFieldToForm)
-	srv.App.Get("/api/v1/form/:_id/field", fieldHandler.GetAllFields)
-	srv.App.Get("/api/v1/form/:_id/field/:field_id", fieldHandler.GetField)
-	srv.App.Delete("/api/v1/form/:_id/field/:field_id", fieldHandler.DeleteField)
-	srv.App.Put("/api/v1/form/:_id/field/:field_id", fieldHandler.UpdateField)
+	fieldHandler := fieldHandler.NewFieldHandler(srv.Repos.Fields, srv.Repos.Stocks, srv.Jobs, references, srv.Repos.Transactions, formulas, recorder)
+	srv.App.Post("/api/v1/form/:_id/field", requireForm, fieldHandler.AddFieldToForm)
+	srv.App.Get("/api/v1/form/:_id/field", requireForm, fieldHandler.GetAllFields)
+	srv.App.Get("/api/v1/form/:_id/field/:field_id", requireForm, requireField, fieldHandler.GetField)
+	srv.App.Delete("/api/v1/form/:_id/field/:field_id", requireForm, requireField, fieldHandler.DeleteField)
+	srv.App.Put("/api/v1/form/:_id/field/:field_id", requireForm, requireField, fieldHandler.UpdateField)
+	srv.App.Post("/api/v1/form/:_id/field/:field_id/type/preview", requireForm, requireField, fieldHandler.PreviewTypeChange)
+	srv.App.Post("/api/v1/form/:_id/field/:field_id/type", requireForm, requireField, fieldHandler.ChangeFieldType)
+
+	// Background job routes setup
+	jobHandler := jobHandler.NewJobHandler(srv.Repos.Jobs)
+	srv.App.Get("/api/v1/form/:_id/job", requireForm, jobHandler.GetJobs)
+	srv.App.Get("/api/v1/form/:_id/job/:job_id", requireForm, jobHandler.GetJob)
 
 	// Stock related routes setup
-	stockRepo := stockRepo.NewStockRepository(srv.DB)
-	stockHandler := stockHandler.NewStockHandler(stockRepo, fieldRepo)
-	srv.App.Post("/api/v1/form/:_id/stock", stockHandler.AddStock)
-	srv.App.Get("/api/v1/form/:_id/stock", stockHandler.GetAllStocks)
-	srv.App.Get("/api/v1/form/:_id/stock/:stock_id", stockHandler.GetStock)
-	srv.App.Put("/api/v1/form/:_id/stock/:stock_id", stockHandler.UpdateStock)
-	srv.App.Delete("/api/v1/form/:_id/stock/:stock_id", stockHandler.DeleteStock)
+	stockHandler := stockHandler.NewStockHandler(srv.Repos.Stocks, srv.Repos.Fields, srv.Repos.Locations, srv.Repos.Revisions, references, srv.Repos.Blobs, recorder, srv.logger)
+	srv.App.Post("/api/v1/form/:_id/stock", requireForm, stockHandler.AddStock)
+	srv.App.Post("/api/v1/form/:_id/stock/import", requireForm, stockHandler.ImportStocks)
+	srv.App.Get("/api/v1/form/:_id/stock", requireForm, stockHandler.GetAllStocks)
+	srv.App.Get("/api/v1/form/:_id/stock/export", requireForm, stockHandler.ExportStocks)
+	srv.App.Get("/api/v1/form/:_id/stock/:stock_id", requireForm, requireStock, stockHandler.GetStock)
+	srv.App.Put("/api/v1/form/:_id/stock/:stock_id", requireForm, requireStock, stockHandler.UpdateStock)
+	srv.App.Patch("/api/v1/form/:_id/stock/:stock_id", requireForm, requireStock, stockHandler.PatchStock)
+	srv.App.Delete("/api/v1/form/:_id/stock/:stock_id", requireForm, requireStock, stockHandler.DeleteStock)
+
+	// Stock revision history routes setup
+	srv.App.Get("/api/v1/form/:_id/stock/:stock_id/revision", requireForm, requireStock, stockHandler.GetRevisions)
+	srv.App.Get("/api/v1/form/:_id/stock/:stock_id/revision/diff", requireForm, requireStock, stockHandler.DiffRevisions)
+	srv.App.Get("/api/v1/form/:_id/stock/:stock_id/revision/:revision", requireForm, requireStock, stockHandler.GetRevision)
+	srv.App.Post("/api/v1/form/:_id/stock/:stock_id/revision/:revision/restore", requireForm, requireStock, stockHandler.RestoreRevision)
+
+	// Stock attachment routes setup
+	srv.App.Post("/api/v1/form/:_id/stock/:stock_id/field/:field_id/attachment", requireForm, requireStock, requireField, stockHandler.UploadAttachment)
+	srv.App.Get("/api/v1/form/:_id/stock/:stock_id/attachment/:attachment_id", requireForm, requireStock, stockHandler.DownloadAttachment)
+	srv.App.Delete("/api/v1/form/:_id/stock/:stock_id/attachment/:attachment_id", requireForm, requireStock, stockHandler.DeleteAttachment)
+
+	// Inventory ledger routes setup
+	movementHandler := movementHandler.NewMovementHandler(srv.Repos.Movements, srv.Repos.Locations, srv.Repos.Transactions)
+	srv.App.Post("/api/v1/form/:_id/stock/:stock_id/movement", requireForm, requireStock, movementHandler.RecordMovement)
+	srv.App.Get("/api/v1/form/:_id/stock/:stock_id/movement", requireForm, requireStock, movementHandler.GetMovements)
+	srv.App.Post("/api/v1/form/:_id/stock/:stock_id/transfer", requireForm, requireStock, movementHandler.TransferStock)
+
+	// Location related routes setup
+	locationHandler := locationHandler.NewLocationHandler(srv.Repos.Locations, srv.Repos.Stocks)
+	srv.App.Post("/api/v1/location", locationHandler.CreateLocation)
+	srv.App.Get("/api/v1/location", locationHandler.GetLocations)
+	srv.App.Get("/api/v1/location/:location_id", locationHandler.GetLocation)
+	srv.App.Put("/api/v1/location/:location_id", locationHandler.UpdateLocation)
+	srv.App.Delete("/api/v1/location/:location_id", locationHandler.DeleteLocation)
 
 	// Form related routes setup
-	formRepo := formRepo.NewFormRepository(srv.DB)
-	formHandler := formHandler.NewFormHandler(formRepo,fieldRepo, stockRepo)
+	formHandler := formHandler.NewFormHandler(srv.Repos.Forms, references, recorder)
 	srv.App.Post("/api/v1/form/create", formHandler.CreateFormHandler)
 	srv.App.Get("/api/v1/form", formHandler.GetFormsHandler)
-	srv.App.Get("/api/v1/form/:_id", formHandler.GetFormHandler)
-	srv.App.Put("/api/v1/form/:_id", formHandler.UpdateFormHandler)
-	srv.App.Delete("/api/v1/form/:_id", formHandler.DeleteFormHandler)
+	srv.App.Get("/api/v1/form/:_id", requireForm, formHandler.GetFormHandler)
+	srv.App.Put("/api/v1/form/:_id", requireForm, formHandler.UpdateFormHandler)
+	srv.App.Delete("/api/v1/form/:_id", requireForm, formHandler.DeleteFormHandler)
+
+	// Audit log routes setup
+	auditHandler := auditHandler.NewAuditHandler(srv.Repos.Audit)
+	srv.App.Get("/api/v1/audit", auditHandler.GetAuditEntries)
+
+	// Trash routes
+	trashHandler := trashHandler.NewTrashHandler(srv.Repos.Forms, srv.Repos.Fields, srv.Repos.Stocks, srv.Purger, formulas, recorder)
+	srv.App.Get("/api/v1/trash", trashHandler.GetTrash)
+	srv.App.Post("/api/v1/trash/:type/:id/restore", trashHandler.RestoreItem)
+	srv.App.Delete("/api/v1/trash/:type/:id", trashHandler.PurgeItem)
 
 }
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/config"
+	"github.com/rs/zerolog"
+)
+
+// client sends requests to a server on the memory backend as one user
+type client struct {
+	t     *testing.T
+	srv   *Server
+	token string
+}
+
+func newClient(t *testing.T) *client {
+	return newClientWith(t, NewMemoryRepositories())
+}
+
+// newClientWith creates a client of a server on the given repositories
+func newClientWith(t *testing.T, repos Repositories) *client {
+	logger := zerolog.Nop()
+	cfg := config.Default()
+	cfg.Storage = config.StorageMemory
+	cfg.JWT.Secret = "test"
+	return &client{t: t, srv: NewServer(cfg, repos, &logger)}
+}
+
+// as returns a client of the same server for another user
+func (c *client) as(username string) *client {
+	other := &client{t: c.t, srv: c.srv}
+	other.register(username)
+	return other
+}
+
+// do sends the body, JSON encoded unless it is a string, followed by pairs of
+// header names and values, and returns the status and raw response body
+func (c *client) do(method, path string, body interface{}, headers ...string) (int, string) {
+	c.t.Helper()
+	var reader io.Reader
+	if text, ok := body.(string); ok {
+		reader = bytes.NewBufferString(text)
+	} else if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := c.srv.App.Test(req, -1)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(raw)
+}
+
+// expect sends a request like do, fails the test unless it gets the status,
+// and decodes the response body into out when given
+func (c *client) expect(status int, method, path string, body interface{}, out interface{}, headers ...string) {
+	c.t.Helper()
+	code, raw := c.do(method, path, body, headers...)
+	if code != status {
+		c.t.Fatalf("%s %s: got %d %s, want %d", method, path, code, raw, status)
+	}
+	if out != nil {
+		if err := json.Unmarshal([]byte(raw), out); err != nil {
+			c.t.Fatalf("%s %s: %v in %s", method, path, err, raw)
+		}
+	}
+}
+
+func (c *client) register(username string) {
+	c.t.Helper()
+	var resp struct{ Token string }
+	c.expect(201, "POST", "/api/v1/register", map[string]string{"name": username, "surname": username, "username": username, "password": "secret123"}, &resp)
+	c.token = resp.Token
+}
+
+// form creates a form with the fields and returns its path
+func (c *client) form(fields ...map[string]interface{}) string {
+	c.t.Helper()
+	var form struct{ ID string }
+	c.expect(201, "POST", "/api/v1/form/create", map[string]string{"name": "form " + uuid.NewString()}, &form)
+	path := "/api/v1/form/" + form.ID
+	for _, field := range fields {
+		c.expect(201, "POST", path+"/field", field, nil)
+	}
+	return path
+}
+
+// stock adds a stock to the form and returns its path
+func (c *client) stock(formPath string, data map[string]interface{}) string {
+	c.t.Helper()
+	c.expect(201, "POST", formPath+"/stock", data, nil)
+	var latest []struct{ ID string }
+	c.expect(200, "GET", formPath+"/stock?sort=-createdAt&limit=1", nil, &latest)
+	return formPath + "/stock/" + latest[0].ID
+}
+
+func TestSmoke(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	form := c.form(
+		map[string]interface{}{"name": "sku", "type": "text", "isUnique": true},
+		map[string]interface{}{"name": "qty", "type": "number", "minValue": 0},
+	)
+
+	stock := c.stock(form, map[string]interface{}{"sku": "A-1", "qty": 3})
+	c.stock(form, map[string]interface{}{"sku": "A-2", "qty": 3})
+	c.expect(409, "POST", form+"/stock", map[string]interface{}{"sku": "A-1"}, nil)
+	c.expect(400, "POST", form+"/stock", map[string]interface{}{"sku": "A-3", "qty": -1}, nil)
+
+	var stocks []struct {
+		ID   string
+		Data map[string]interface{}
+	}
+	c.expect(200, "GET", form+"/stock?sort=sku", nil, &stocks)
+	if len(stocks) != 2 || stocks[0].Data["sku"] != "A-1" || stocks[1].Data["sku"] != "A-2" {
+		t.Fatalf("unexpected stocks %+v", stocks)
+	}
+
+	c.expect(200, "PUT", stock, map[string]interface{}{"sku": "A-1", "qty": 5}, nil)
+	var updated struct{ Data map[string]interface{} }
+	c.expect(200, "GET", stock, nil, &updated)
+	if updated.Data["qty"] != 5.0 {
+		t.Fatalf("unexpected stock %+v", updated)
+	}
+
+	// Other users see neither the form nor its stocks
+	bob := c.as("bob")
+	if code, _ := bob.do("GET", form, nil); code != 403 && code != 404 {
+		t.Fatalf("another user read the form: %d", code)
+	}
+	if code, _ := bob.do("GET", stock, nil); code != 403 && code != 404 {
+		t.Fatalf("another user read the stock: %d", code)
+	}
+
+	c.expect(200, "DELETE", form, nil, nil)
+	if code, _ := c.do("GET", form+"/stock", nil); code != 404 {
+		t.Fatalf("stocks of a deleted form: got %d, want 404", code)
+	}
+}
+
+func TestTrashStateIgnoredInBodies(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	trashed := map[string]interface{}{"deletedAt": "2024-01-01T00:00:00Z", "deletedBy": uuid.NewString()}
+
+	var created struct{ ID string }
+	c.expect(201, "POST", "/api/v1/form/create", map[string]interface{}{"name": "form", "deletedAt": trashed["deletedAt"], "deletedBy": trashed["deletedBy"]}, &created)
+	form := "/api/v1/form/" + created.ID
+	c.expect(200, "PUT", form, map[string]interface{}{"name": "renamed", "deletedAt": trashed["deletedAt"], "deletedBy": trashed["deletedBy"]}, nil)
+	c.expect(201, "POST", form+"/field", map[string]interface{}{"name": "sku", "type": "text", "deletedAt": trashed["deletedAt"], "deletedBy": trashed["deletedBy"]}, nil)
+
+	var fields []struct{ ID string }
+	c.expect(200, "GET", form+"/field", nil, &fields)
+	if len(fields) != 1 {
+		t.Fatalf("field created in the trash: %+v", fields)
+	}
+	field := form + "/field/" + fields[0].ID
+	c.expect(200, "PUT", field, map[string]interface{}{"order": 2, "deletedAt": trashed["deletedAt"], "deletedBy": trashed["deletedBy"]}, nil)
+
+	var stored map[string]interface{}
+	for _, path := range []string{form, field} {
+		c.expect(200, "GET", path, nil, &stored)
+		if _, ok := stored["deletedAt"]; ok {
+			t.Fatalf("%s moved to the trash by its body: %v", path, stored)
+		}
+	}
+	var trash struct{ Forms, Fields []struct{} }
+	c.expect(200, "GET", "/api/v1/trash", nil, &trash)
+	if len(trash.Forms) != 0 || len(trash.Fields) != 0 {
+		t.Fatalf("unexpected trash %+v", trash)
+	}
+}
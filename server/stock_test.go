@@ -0,0 +1,34 @@
+package server
+
+import "testing"
+
+func TestPatchStock(t *testing.T) {
+	c := newClient(t)
+	c.register("alice")
+	form := c.form(
+		map[string]interface{}{"name": "sku", "type": "text"},
+		map[string]interface{}{"name": "qty", "type": "number", "defaultValue": 1},
+		map[string]interface{}{"name": "total", "type": "formula", "expression": "qty * 2"},
+		map[string]interface{}{"name": "photo", "type": "attachment"},
+	)
+	stock := c.stock(form, map[string]interface{}{"sku": "A-1", "qty": 4})
+
+	var patched struct{ Data map[string]interface{} }
+	c.expect(200, "PATCH", stock, map[string]interface{}{"qty": 3, "total": 99}, &patched)
+	if patched.Data["qty"] != 3.0 || patched.Data["total"] != 6.0 {
+		t.Fatalf("patched data %v", patched.Data)
+	}
+	c.expect(200, "PATCH", stock, map[string]interface{}{"qty": nil}, &patched)
+	if patched.Data["qty"] != 1.0 || patched.Data["total"] != 2.0 {
+		t.Fatalf("removed key not defaulted: %v", patched.Data)
+	}
+
+	for _, patch := range []map[string]interface{}{
+		{"unknown": nil},
+		{"unknown": "x"},
+		{"photo": nil},
+		{"photo": []interface{}{}},
+	} {
+		c.expect(400, "PATCH", stock, patch, nil)
+	}
+}
@@ -0,0 +1,209 @@
+package trash
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kbc0/DynamicStockManager/blob"
+	"github.com/kbc0/DynamicStockManager/entity"
+	"github.com/kbc0/DynamicStockManager/job"
+	fieldRepo "github.com/kbc0/DynamicStockManager/repository/field"
+	formRepo "github.com/kbc0/DynamicStockManager/repository/form"
+	movementRepo "github.com/kbc0/DynamicStockManager/repository/movement"
+	revisionRepo "github.com/kbc0/DynamicStockManager/repository/revision"
+	stockRepo "github.com/kbc0/DynamicStockManager/repository/stock"
+	transaction "github.com/kbc0/DynamicStockManager/repository/transaction"
+	"github.com/kbc0/DynamicStockManager/schema"
+	"github.com/rs/zerolog"
+)
+
+// Purger deletes forms, fields and stocks in the trash for good, along with
+// everything that belongs to them, including the files of their attachments
+type Purger struct {
+	forms        formRepo.FormStore
+	fields       fieldRepo.FieldStore
+	stocks       stockRepo.StockStore
+	movements    movementRepo.MovementStore
+	revisions    revisionRepo.RevisionStore
+	transactions transaction.Transactor
+	jobs         *job.Runner
+	blobs        blob.Store
+}
+
+func NewPurger(forms formRepo.FormStore, fields fieldRepo.FieldStore, stocks stockRepo.StockStore, movements movementRepo.MovementStore, revisions revisionRepo.RevisionStore, transactions transaction.Transactor, jobs *job.Runner, blobs blob.Store) *Purger {
+	return &Purger{
+		forms:        forms,
+		fields:       fields,
+		stocks:       stocks,
+		movements:    movements,
+		revisions:    revisions,
+		transactions: transactions,
+		jobs:         jobs,
+		blobs:        blobs,
+	}
+}
+
+// PurgeForm deletes a form along with its fields, stocks, movements and stock
+// revisions, all together or not at all, and then the files of its stocks
+func (p *Purger) PurgeForm(ctx context.Context, id uuid.UUID) error {
+	err := p.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := p.fields.DeleteFieldsByFormID(ctx, id); err != nil {
+			return err
+		}
+		if err := p.stocks.DeleteStocksByFormID(ctx, id); err != nil {
+			return err
+		}
+		if err := p.movements.DeleteMovementsByFormID(ctx, id); err != nil {
+			return err
+		}
+		if err := p.revisions.DeleteRevisionsByFormID(ctx, id); err != nil {
+			return err
+		}
+		return p.forms.DeleteForm(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+	return p.blobs.DeletePrefix(ctx, blob.FormPrefix(id))
+}
+
+// PurgeField deletes a field, which stops enforcing it if it was unique, and
+// starts a job removing its values from the stocks of its form. With archive
+// set the values are kept in the archived data of the stocks instead, except
+// for attachment fields whose files are deleted right away. The job is
+// returned, or nil when no stock holds a value for the field.
+func (p *Purger) PurgeField(ctx context.Context, id uuid.UUID, archive bool) (*entity.Job, error) {
+	field, err := p.fields.GetTrashedFieldByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if field.Type == entity.Attachment {
+		// Deleting a missing file succeeds, so a failed purge can be retried
+		if err := p.deleteAttachments(ctx, *field); err != nil {
+			return nil, err
+		}
+		archive = false
+	}
+	if err := p.fields.DeleteField(ctx, id); err != nil {
+		return nil, err
+	}
+
+	holding, err := p.stocks.CountStocksWithDataKey(field.FormID, field.Name)
+	if err != nil || holding == 0 {
+		return nil, err
+	}
+	kind := entity.JobDropField
+	if archive {
+		kind = entity.JobArchiveField
+	}
+	return p.jobs.Submit(entity.Job{Kind: kind, FormID: field.FormID, FieldID: field.ID, FieldName: field.Name})
+}
+
+// PurgeStock deletes a stock in the trash along with its movements and
+// revisions, all together or not at all, and then the files of its attachments
+func (p *Purger) PurgeStock(ctx context.Context, id uuid.UUID) error {
+	stock, err := p.stocks.GetTrashedStockByID(id)
+	if err != nil {
+		return err
+	}
+	err = p.transactions.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := p.stocks.DeleteStock(ctx, id); err != nil {
+			return err
+		}
+		if err := p.movements.DeleteMovementsByStockID(ctx, id); err != nil {
+			return err
+		}
+		return p.revisions.DeleteRevisionsByStockID(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+	return p.blobs.DeletePrefix(ctx, blob.StockPrefix(stock.FormID, id))
+}
+
+// deleteAttachments deletes the files attached to an attachment field by the
+// stocks of its form, including the ones in the trash
+func (p *Purger) deleteAttachments(ctx context.Context, field entity.Field) error {
+	stocks, err := p.stocks.GetAllStocksByFormId(field.FormID)
+	if err != nil {
+		return err
+	}
+	trashed, err := p.stocks.GetTrashedStocks([]uuid.UUID{field.FormID}, time.Time{}, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, stock := range append(stocks, trashed...) {
+		attachments, _ := schema.Attachments(stock.Data[field.Name])
+		for _, attachment := range attachments {
+			if err := p.blobs.Delete(ctx, blob.Key(stock.FormID, stock.ID, attachment.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PurgeExpired purges everything moved to the trash before the given time and
+// returns how many forms, fields and stocks it purged
+func (p *Purger) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+
+	forms, err := p.forms.GetTrashedForms(uuid.Nil, before, 0, 0)
+	if err != nil {
+		return purged, err
+	}
+	for _, form := range forms {
+		if err := p.PurgeForm(ctx, form.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	fields, err := p.fields.GetTrashedFields(nil, before, 0, 0)
+	if err != nil {
+		return purged, err
+	}
+	for _, field := range fields {
+		// Values of expired fields are archived, only a purge by hand drops them
+		if _, err := p.PurgeField(ctx, field.ID, true); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	stocks, err := p.stocks.GetTrashedStocks(nil, before, 0, 0)
+	if err != nil {
+		return purged, err
+	}
+	for _, stock := range stocks {
+		if err := p.PurgeStock(ctx, stock.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Run purges everything that has been in the trash for longer than the
+// retention period, once right away and then at every interval, until the
+// context is cancelled
+func (p *Purger) Run(ctx context.Context, retention time.Duration, interval time.Duration, logger *zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := p.PurgeExpired(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logger.Error().Err(err).Int("purged", purged).Msg("purging the trash failed")
+		} else if purged > 0 {
+			logger.Info().Int("purged", purged).Msg("purged expired trash")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrDuplicate is wrapped by the errors stores return for a value that must be
+// unique and is already taken
+var ErrDuplicate = errors.New("duplicate value")
+
+type duplicateError string
+
+func (e duplicateError) Error() string { return string(e) }
+func (e duplicateError) Unwrap() error { return ErrDuplicate }
+
+// DuplicateError creates an error wrapping ErrDuplicate with the message
+func DuplicateError(message string) error {
+	return duplicateError(message)
+}
+
+// MapDuplicateKey turns a duplicate key error of a unique index into an error
+// wrapping ErrDuplicate with the message, and returns other errors unchanged
+func MapDuplicateKey(err error, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return DuplicateError(message)
+	}
+	return err
+}
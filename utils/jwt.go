@@ -7,23 +7,36 @@ import (
 	"github.com/google/uuid"
 )
 
+// TokenManager signs and verifies the JWT tokens handed out to users
+type TokenManager struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewTokenManager(secret string, ttl time.Duration) *TokenManager {
+	return &TokenManager{
+		secret: []byte(secret),
+		ttl:    ttl,
+	}
+}
+
 // GenerateToken creates a JWT token for authenticated users including their UUID
-func GenerateToken(userID uuid.UUID, username string) (string, error) {
+func (m *TokenManager) GenerateToken(userID uuid.UUID, username string) (string, error) {
     token := jwt.New(jwt.SigningMethodHS256)
     claims := token.Claims.(jwt.MapClaims)
 
     claims["userID"] = userID.String() // Include UUID in the token
     claims["username"] = username
-    claims["exp"] = time.Now().Add(time.Hour * 144).Unix() // Token expires after 144 hours
+    claims["exp"] = time.Now().Add(m.ttl).Unix() // Token expires after the configured lifetime
 
-    tokenString, err := token.SignedString([]byte("secret"))
+    tokenString, err := token.SignedString(m.secret)
     if err != nil {
         return "", err
     }
     return tokenString, nil
 }
 // VerifyToken verifies the JWT token and returns the claims if the token is valid
-func VerifyToken(tokenString string) (jwt.MapClaims, error) {
+func (m *TokenManager) VerifyToken(tokenString string) (jwt.MapClaims, error) {
 	
     token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
         // Check if the token signing method is HMAC
@@ -31,7 +44,7 @@ func VerifyToken(tokenString string) (jwt.MapClaims, error) {
             return nil, jwt.ErrSignatureInvalid
         }
         // Return the secret key used to sign the token
-        return []byte("secret"), nil
+        return m.secret, nil
     })
     if err != nil {
         return nil, err
@@ -49,4 +62,4 @@ func VerifyToken(tokenString string) (jwt.MapClaims, error) {
     }
 
     return claims, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// NotDeleted adds the condition leaving out documents in the trash to a filter
+func NotDeleted(filter bson.M) bson.M {
+	filter["deletedAt"] = nil
+	return filter
+}
+
+// InTrash adds the condition only matching documents in the trash to a filter,
+// limited to the ones deleted before the given time unless it is zero
+func InTrash(filter bson.M, before time.Time) bson.M {
+	if before.IsZero() {
+		filter["deletedAt"] = bson.M{"$ne": nil}
+	} else {
+		filter["deletedAt"] = bson.M{"$ne": nil, "$lt": before}
+	}
+	return filter
+}
+
+// TrashUpdate moves a document to the trash and counts as an update of it
+func TrashUpdate(deletedBy interface{}, deletedAt time.Time) bson.M {
+	return bson.M{
+		"$set": bson.M{"deletedAt": deletedAt, "deletedBy": deletedBy},
+		"$inc": bson.M{"version": 1},
+	}
+}
+
+// RestoreUpdate takes a document out of the trash and counts as an update of it
+func RestoreUpdate() bson.M {
+	return bson.M{
+		"$unset": bson.M{"deletedAt": "", "deletedBy": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrVersionConflict is returned by an update that was based on another
+// version of the document than the stored one
+var ErrVersionConflict = errors.New("the document was changed by someone else, reload it and try again")
+
+// VersionFilter matches the stored version of a document in an update filter.
+// Documents stored before versions existed have no version and count as 0.
+func VersionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// SetETag sends the version of the requested document as its ETag
+func SetETag(c *fiber.Ctx, version int64) {
+	c.Set(fiber.HeaderETag, `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// IfMatch reports whether the If-Match precondition of the request holds for
+// the version, which it always does without the header
+func IfMatch(c *fiber.Ctx, version int64) bool {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return true
+	}
+	etag := `"` + strconv.FormatInt(version, 10) + `"`
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ConflictStatus returns the status code to answer an ErrVersionConflict with:
+// 412 when the client made the update conditional with If-Match, 409 otherwise
+func ConflictStatus(c *fiber.Ctx) int {
+	if c.Get(fiber.HeaderIfMatch) != "" {
+		return fiber.StatusPreconditionFailed
+	}
+	return fiber.StatusConflict
+}